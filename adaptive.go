@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 自适应并发的范围: 从较小的并发数开始，根据超时和连接错误的比例增减
+//
+// 合适的并发数取决于网络而不是CPU核数，过高的并发会让本机出口拥塞，
+// 使本来可用的镜像源也超时。
+const (
+	adaptiveStartWorkers = 4
+	adaptiveMinWorkers   = 2
+	adaptiveMaxWorkers   = 64
+)
+
+// 每轮 (完成的检测数达到当前并发数) 结束时，失败比例高于该值时并发数减半
+const adaptiveBackoffRatio = 0.3
+
+// 失败比例低于该值时并发数增加一半
+const adaptiveGrowRatio = 0.1
+
+// 限制同时进行的检测数量，并根据检测结果调整上限，nil表示不限制 (固定数量的worker)
+type adaptiveConcurrency struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	// 本轮完成的检测数和其中的失败数
+	done     int
+	failures int
+}
+
+func newAdaptiveConcurrency() *adaptiveConcurrency {
+	a := &adaptiveConcurrency{limit: adaptiveStartWorkers}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+// 等待到同时进行的检测数低于当前上限
+func (a *adaptiveConcurrency) acquire() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	for a.active >= a.limit {
+		a.cond.Wait()
+	}
+	a.active++
+	a.mu.Unlock()
+}
+
+// 一个检测结束，result为nil表示没有完成 (已取消)，不计入统计
+func (a *adaptiveConcurrency) release(result *CheckResult) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active--
+	if result != nil {
+		a.done++
+		if congested(*result) {
+			a.failures++
+		}
+		if a.done >= a.limit {
+			a.adjust()
+		}
+	}
+	a.cond.Broadcast()
+}
+
+// 按本轮的失败比例调整上限 (调用时持有锁)
+func (a *adaptiveConcurrency) adjust() {
+	ratio := float64(a.failures) / float64(a.done)
+	switch {
+	case ratio > adaptiveBackoffRatio:
+		a.limit /= 2
+	case ratio < adaptiveGrowRatio:
+		a.limit += a.limit/2 + 1
+	}
+	if a.limit < adaptiveMinWorkers {
+		a.limit = adaptiveMinWorkers
+	}
+	if a.limit > adaptiveMaxWorkers {
+		a.limit = adaptiveMaxWorkers
+	}
+	a.done, a.failures = 0, 0
+}
+
+// 检测结果是否像是并发过高导致的: 超时或连接被重置
+//
+// 域名无法解析、连接被拒绝等错误与并发数无关，不计入。
+func congested(result CheckResult) bool {
+	if result.IsTimeout {
+		return true
+	}
+	return result.StatusCode == 0 && strings.Contains(result.Error, "connection reset")
+}
+
+// 显示的并发数，0表示自动调整
+func workersLabel(workers int) string {
+	if workers <= 0 {
+		return "自动"
+	}
+	return strconv.Itoa(workers)
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// agent 模式的配置文件
+//
+// 示例:
+//
+//	list: docker.txt
+//	interval: 10m
+//	workers: 8
+//	timeout: 10s
+//	method: HEAD
+//	probe_path: /v2/
+//	expect_status: [2xx, 401, 403]
+//	reject_redirect: login|signin
+//	reload_policy: wait
+//	deep_checks: ac_power_unmetered
+//	shutdown_grace: 10s
+//	state_file: /var/lib/docker-registry-checker/state.json
+//	shutdown_webhook: https://hooks.example.com/agent
+//	textfile: /var/lib/node_exporter/textfile_collector/docker_mirrors.prom
+type AgentConfig struct {
+	List         string        `yaml:"list"`
+	Interval     time.Duration `yaml:"interval"`
+	Workers      int           `yaml:"workers"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      int           `yaml:"retries"`
+	Method       string        `yaml:"method"`
+	ProbePath    string        `yaml:"probe_path"`
+	ExpectStatus []string      `yaml:"expect_status"`
+	// 被重定向到匹配该正则的地址时视为不可用
+	RejectRedirect string `yaml:"reject_redirect"`
+	HTTP3          bool   `yaml:"http3"`
+	Warm           bool   `yaml:"warm"`
+	PerIP          bool   `yaml:"per_ip"`
+	// 用于探测OCI能力的镜像，为空时不探测
+	OCIImage string `yaml:"oci_image"`
+	// 深度检测的并发数，不设置时为defaultDeepWorkers
+	DeepWorkers int `yaml:"deep_workers"`
+	// 外部探测插件的路径
+	Plugins []string `yaml:"plugins"`
+	// 每轮检测后写入的node_exporter textfile collector文件
+	Textfile string `yaml:"textfile"`
+	// 每轮检测后追加结果的历史记录文件，供 report 子命令汇总
+	History string `yaml:"history"`
+	// 检测进行中重新加载配置时的处理方式: wait (默认，等待完成) 或 cancel (取消并重新检测)
+	ReloadPolicy string `yaml:"reload_policy"`
+	// 深度检测 (warm、http3、oci_image、per_ip、plugins) 的执行条件:
+	// always (默认)、ac_power、unmetered 或 ac_power_unmetered，不满足时只做快速检测
+	DeepChecks string `yaml:"deep_checks"`
+	// 收到SIGTERM时等待进行中的检测完成的最长时间，默认10s
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+	// 退出时写入最终状态 (JSON) 的文件
+	StateFile string `yaml:"state_file"`
+	// 退出时POST通知的地址
+	ShutdownWebhook string `yaml:"shutdown_webhook"`
+
+	criteria successCriteria
+}
+
+// 读取agent配置文件并填充默认值
+func loadAgentConfig(path string) (*AgentConfig, error) {
+	config := &AgentConfig{}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := unmarshalYAMLStrict(data, config); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+
+	if config.List == "" {
+		config.List = "docker.txt"
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	config.Method = strings.ToUpper(config.Method)
+	if config.Method == "" {
+		config.Method = "GET"
+	}
+	if config.Method != "GET" && config.Method != "HEAD" {
+		return nil, fmt.Errorf("不支持的请求方法: %s", config.Method)
+	}
+	if config.ProbePath == "" {
+		config.ProbePath = "/v2/"
+	}
+	config.criteria, err = newSuccessCriteria(strings.Join(config.ExpectStatus, ","), config.RejectRedirect)
+	if err != nil {
+		return nil, err
+	}
+	switch config.ReloadPolicy {
+	case "":
+		config.ReloadPolicy = "wait"
+	case "wait", "cancel":
+	default:
+		return nil, fmt.Errorf("不支持的reload_policy: %s (可选 wait/cancel)", config.ReloadPolicy)
+	}
+	if config.ShutdownGrace <= 0 {
+		config.ShutdownGrace = 10 * time.Second
+	}
+	if config.DeepChecks == "" {
+		config.DeepChecks = "always"
+	}
+	if err := validateDeepCheckPolicy(config.DeepChecks); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *AgentConfig) checkOptions() checkOptions {
+	return checkOptions{
+		Timeout:   c.Timeout,
+		Method:    c.Method,
+		ProbePath: c.ProbePath,
+		Criteria:  c.criteria,
+		HTTP3:     c.HTTP3,
+		Warm:      c.Warm,
+		OCIImage:  c.OCIImage,
+		Retries:   c.Retries,
+		PerIP:     c.PerIP,
+		Plugins:   c.Plugins,
+
+		DeepWorkers: c.DeepWorkers,
+	}
+}
+
+// agent 当前的状态
+type agentState struct {
+	LastRun time.Time
+	Runs    int
+	Results []CheckResult
+	// 产生当前结果的配置版本，每次重新加载配置加1
+	ConfigGeneration int
+	// 最近一次检测跳过深度检测的原因，为空表示执行了完整检测
+	QuickOnly string
+	Events    []agentEvent
+}
+
+// 状态变化记录，如配置重新加载、检测被取消
+type agentEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// 最多保留的事件数量
+const maxAgentEvents = 50
+
+func (s *agentState) addEvent(logger *log.Logger, format string, args ...interface{}) {
+	event := agentEvent{Time: time.Now(), Message: fmt.Sprintf(format, args...)}
+	logger.Println(event.Message)
+
+	s.Events = append(s.Events, event)
+	if len(s.Events) > maxAgentEvents {
+		s.Events = s.Events[len(s.Events)-maxAgentEvents:]
+	}
+}
+
+// 一轮检测的结果
+type agentRunOutcome struct {
+	Start      time.Time
+	Results    []CheckResult
+	Generation int
+	Err        error
+	Cancelled  bool
+	// 因电源或网络计费状态跳过深度检测的原因
+	QuickOnly string
+	// 通过gRPC接口检测指定的镜像源，结果不更新状态、指标和历史记录
+	Adhoc bool
+}
+
+// agent 子命令：常驻后台定期检测
+//
+// 信号约定 (非Windows系统):
+//
+//	SIGHUP  重新加载配置文件
+//	SIGUSR1 立即执行一次检测，并将当前状态输出到日志
+//	SIGTERM 等待进行中的检测完成 (最多 shutdown_grace) 并保存结果后退出
+//
+// 每轮检测在后台执行并持有启动时的配置快照，重新加载配置只影响之后的检测。
+// 检测进行中重新加载时，按新配置的 reload_policy 等待其完成 (wait) 或取消后
+// 立即用新配置重新检测 (cancel)，不会出现新旧配置混用的结果。
+//
+// 指定 -grpc-listen 时同时提供gRPC接口 (api/checker.proto)，StartRun 与定时检测共用同一个检测循环。
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	configPath := fs.String("config", "agent.yaml", "agent配置文件 (YAML)")
+	grpcListen := fs.String("grpc-listen", "", "提供gRPC接口 (CheckService) 的地址，如 unix:/run/docker-registry-checker.sock 或 127.0.0.1:50051 (只写端口时监听本机)，默认不提供")
+	grpcTokenFile := fs.String("grpc-token-file", "", "gRPC接口的令牌文件，请求需要带上 authorization: Bearer <令牌>；也可以通过环境变量 DRC_GRPC_TOKEN 设置，监听非本机地址时必须设置")
+	grpcCert := fs.String("grpc-tls-cert", "", "gRPC接口的TLS证书")
+	grpcKey := fs.String("grpc-tls-key", "", "gRPC接口的TLS私钥")
+	fs.Parse(args)
+
+	config, err := loadAgentConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(os.Stderr, "[agent] ", log.LstdFlags)
+	logger.Printf("已启动 (列表: %s, 间隔: %s, 并发数: %s)", config.List, config.Interval, workersLabel(config.Workers))
+
+	reload := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reload, reloadSignals...)
+	}
+	dump := make(chan os.Signal, 1)
+	if len(dumpSignals) > 0 {
+		signal.Notify(dump, dumpSignals...)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	// 没有开启gRPC接口时tracker和server为nil，runRequests不会收到请求
+	var tracker *runTracker
+	var server *checkServer
+	runRequests := make(chan agentRunRequest)
+	if *grpcListen != "" {
+		// 令牌在解析参数之后读取，避免 -h 输出环境变量中的令牌
+		grpcOpts := grpcOptions{Addr: *grpcListen, Token: os.Getenv("DRC_GRPC_TOKEN"), CertFile: *grpcCert, KeyFile: *grpcKey}
+		if *grpcTokenFile != "" {
+			data, err := os.ReadFile(*grpcTokenFile)
+			if err != nil {
+				return fmt.Errorf("读取令牌文件失败: %v", err)
+			}
+			grpcOpts.Token = strings.TrimSpace(string(data))
+		}
+		tracker = newRunTracker()
+		server = newCheckServer(config, tracker, runRequests)
+		grpcServer, _, err := serveGRPC(grpcOpts, server, logger)
+		if err != nil {
+			return err
+		}
+		defer grpcServer.Stop()
+	}
+
+	state := &agentState{}
+	generation := 1
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// 同一时间最多只有一轮检测
+	var (
+		running     bool
+		cancelRun   context.CancelFunc
+		rerun       bool // 当前检测结束后立即用新配置再检测一次
+		pendingDump bool // 当前检测结束后输出状态
+	)
+	done := make(chan agentRunOutcome, 1)
+
+	// entries为nil时检测配置中的列表，返回供 StreamResults 使用的检测ID
+	startRun := func(entries []listEntry) string {
+		ctx, cancel := context.WithCancel(context.Background())
+		running, cancelRun = true, cancel
+		snapshot, gen := config, generation
+		id := tracker.begin(time.Now())
+		go func() {
+			outcome := agentCheck(ctx, snapshot, gen, entries, func(result CheckResult) {
+				tracker.add(id, result)
+			})
+			switch {
+			case outcome.Cancelled:
+				tracker.finish(id, fmt.Errorf("检测已取消"))
+			default:
+				tracker.finish(id, outcome.Err)
+			}
+			done <- outcome
+		}()
+		return id
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			if running {
+				logger.Println("上一轮检测尚未结束，跳过本次检测")
+			} else {
+				startRun(nil)
+			}
+			timer.Reset(config.Interval)
+
+		case outcome := <-done:
+			running = false
+			cancelRun()
+			switch {
+			case outcome.Cancelled:
+				state.addEvent(logger, "第%d版配置的检测已取消，结果已丢弃", outcome.Generation)
+			case outcome.Err != nil:
+				logger.Printf("%v", outcome.Err)
+			case outcome.Adhoc:
+				logger.Printf("通过gRPC接口请求的检测完成 (成功: %d, 总计: %d)", len(filterSuccess(outcome.Results)), len(outcome.Results))
+			default:
+				agentApplyOutcome(logger, config, state, outcome)
+				server.setResults(state.Results)
+			}
+			// 需要重新检测时等新配置的结果出来后再输出状态
+			if pendingDump && !rerun {
+				pendingDump = false
+				dumpAgentState(logger, config, state)
+			}
+			if rerun {
+				rerun = false
+				startRun(nil)
+				resetTimer(timer, config.Interval)
+			}
+
+		case <-reload:
+			newConfig, err := loadAgentConfig(*configPath)
+			if err != nil {
+				state.addEvent(logger, "重新加载配置失败，继续使用第%d版配置: %v", generation, err)
+				continue
+			}
+			config = newConfig
+			generation++
+			server.setConfig(config)
+
+			switch {
+			case !running:
+				state.addEvent(logger, "配置已重新加载为第%d版 (列表: %s, 间隔: %s, 并发数: %s)",
+					generation, config.List, config.Interval, workersLabel(config.Workers))
+			case config.ReloadPolicy == "cancel":
+				state.addEvent(logger, "配置已重新加载为第%d版，取消进行中的检测并使用新配置重新检测", generation)
+				cancelRun()
+				rerun = true
+			default:
+				state.addEvent(logger, "配置已重新加载为第%d版，进行中的检测将使用旧配置完成，新配置从下一轮开始生效", generation)
+			}
+			// 新的检测间隔从现在开始计算
+			resetTimer(timer, config.Interval)
+
+		case <-dump:
+			logger.Println("收到信号，立即执行检测")
+			pendingDump = true
+			if !running {
+				startRun(nil)
+				resetTimer(timer, config.Interval)
+			}
+
+		case req := <-runRequests:
+			if running {
+				req.reply <- agentRunReply{err: fmt.Errorf("已有检测正在进行，请稍后再试")}
+				continue
+			}
+			logger.Println("收到gRPC请求，立即执行检测")
+			req.reply <- agentRunReply{id: startRun(req.entries)}
+			// 按配置检测时下一轮定时检测从现在开始计算
+			if req.entries == nil {
+				resetTimer(timer, config.Interval)
+			}
+
+		case sig := <-stop:
+			state.addEvent(logger, "收到 %v，准备退出", sig)
+			if running {
+				agentDrain(logger, config, state, done, stop, cancelRun)
+			}
+			agentShutdown(logger, config, state, sig)
+			return nil
+		}
+	}
+}
+
+// 重置定时器，丢弃尚未读取的触发
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// 使用给定的配置快照执行一轮检测，entries为nil时检测配置中的列表
+//
+// 每完成一个镜像源调用一次onResult (可以为nil)。
+func agentCheck(ctx context.Context, config *AgentConfig, generation int, entries []listEntry, onResult func(CheckResult)) agentRunOutcome {
+	outcome := agentRunOutcome{Start: time.Now(), Generation: generation, Adhoc: entries != nil}
+
+	if entries == nil {
+		var err error
+		if entries, err = readList(config.List); err != nil {
+			outcome.Err = fmt.Errorf("读取列表失败: %v", err)
+			return outcome
+		}
+	}
+
+	opts := config.checkOptions()
+	if reason := deepChecksBlocked(config.DeepChecks); reason != "" {
+		outcome.QuickOnly = reason
+		opts = opts.quick()
+	}
+
+	checkEntries := dedupeEntries(entries, nil)
+	outcome.Results = make([]CheckResult, 0, len(checkEntries))
+	checkEach(ctx, checkEntries, config.Workers, opts, func(result CheckResult) {
+		outcome.Results = append(outcome.Results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	})
+	attributeSources(outcome.Results, entries)
+	outcome.Cancelled = ctx.Err() != nil
+	return outcome
+}
+
+// 用完成的检测结果更新状态
+func agentApplyOutcome(logger *log.Logger, config *AgentConfig, state *agentState, outcome agentRunOutcome) {
+	state.LastRun = outcome.Start
+	state.Runs++
+	state.Results = outcome.Results
+	state.ConfigGeneration = outcome.Generation
+
+	// 只在状态变化时记录，避免电池供电期间每轮都产生事件
+	if outcome.QuickOnly != state.QuickOnly {
+		if outcome.QuickOnly != "" {
+			state.addEvent(logger, "%s，只执行快速检测 (deep_checks: %s)", outcome.QuickOnly, config.DeepChecks)
+		} else {
+			state.addEvent(logger, "已满足深度检测的条件，恢复完整检测")
+		}
+		state.QuickOnly = outcome.QuickOnly
+	}
+
+	if config.Textfile != "" {
+		if err := writeTextfile(config.Textfile, outcome.Results, time.Now()); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+	if config.History != "" {
+		if err := appendHistory(config.History, outcome.Start, outcome.Results); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+
+	logger.Printf("检测完成 (配置版本: %d, 成功: %d, 总计: %d, 耗时: %.1fs)", outcome.Generation,
+		len(filterSuccess(outcome.Results)), len(outcome.Results), time.Since(outcome.Start).Seconds())
+}
+
+// 将当前配置和最近一次检测结果输出到日志
+func dumpAgentState(logger *log.Logger, config *AgentConfig, state *agentState) {
+	logger.Printf("当前配置: %+v", *config)
+	logger.Printf("已执行 %d 轮检测，最近一次: %s (配置版本: %d)",
+		state.Runs, state.LastRun.Format(time.RFC3339), state.ConfigGeneration)
+
+	results := append([]CheckResult(nil), state.Results...)
+	sortResults(results, "time")
+	for _, result := range results {
+		status := "ok"
+		if !isSuccess(result) {
+			status = "fail"
+		}
+		logger.Printf("  %-30s %-4s %3d %.2fs", result.Host, status, result.StatusCode, result.Time.Seconds())
+	}
+
+	for _, event := range state.Events {
+		logger.Printf("  [%s] %s", event.Time.Format(time.RFC3339), event.Message)
+	}
+}
+
+// 退出前等待进行中的检测完成并保存其结果，超过 shutdown_grace 或再次收到退出信号时取消
+func agentDrain(logger *log.Logger, config *AgentConfig, state *agentState, done <-chan agentRunOutcome, stop <-chan os.Signal, cancelRun context.CancelFunc) {
+	logger.Printf("等待进行中的检测完成 (最多 %s)", config.ShutdownGrace)
+	grace := time.NewTimer(config.ShutdownGrace)
+	defer grace.Stop()
+
+	select {
+	case outcome := <-done:
+		cancelRun()
+		switch {
+		case outcome.Err != nil || outcome.Cancelled:
+		case outcome.Adhoc:
+			// 与检测循环中相同，指定镜像源的检测不更新状态、指标和历史记录
+			logger.Printf("通过gRPC接口请求的检测完成 (成功: %d, 总计: %d)", len(filterSuccess(outcome.Results)), len(outcome.Results))
+		default:
+			agentApplyOutcome(logger, config, state, outcome)
+		}
+		return
+	case <-grace.C:
+		state.addEvent(logger, "检测未在%s内完成，已取消", config.ShutdownGrace)
+	case <-stop:
+		state.addEvent(logger, "再次收到退出信号，取消进行中的检测")
+	}
+	cancelRun()
+	<-done
+}
+
+// agent 退出时保存的最终状态
+type agentSnapshot struct {
+	StoppedAt        time.Time     `json:"stopped_at"`
+	Signal           string        `json:"signal"`
+	LastRun          time.Time     `json:"last_run"`
+	Runs             int           `json:"runs"`
+	ConfigGeneration int           `json:"config_generation"`
+	Results          []CheckResult `json:"results"`
+	Events           []agentEvent  `json:"events"`
+}
+
+// 退出前写入最终状态、清空指标并发送通知
+//
+// 指标文件只保留最后一次检测的时间，去掉各镜像源的 up 等指标，
+// 避免node_exporter在agent停止后一直报告过时的"可用"状态。
+func agentShutdown(logger *log.Logger, config *AgentConfig, state *agentState, sig os.Signal) {
+	snapshot := agentSnapshot{
+		StoppedAt:        time.Now(),
+		Signal:           sig.String(),
+		LastRun:          state.LastRun,
+		Runs:             state.Runs,
+		ConfigGeneration: state.ConfigGeneration,
+		Results:          state.Results,
+		Events:           state.Events,
+	}
+
+	if config.StateFile != "" {
+		if err := writeAgentSnapshot(config.StateFile, snapshot); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+	if config.Textfile != "" {
+		if err := writeTextfile(config.Textfile, nil, state.LastRun); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+	if config.ShutdownWebhook != "" {
+		if err := notifyShutdown(config.ShutdownWebhook, snapshot); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+	logger.Printf("已退出 (共执行 %d 轮检测)", state.Runs)
+}
+
+func writeAgentSnapshot(path string, snapshot agentSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态失败: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("写入状态文件失败: %v", err)
+	}
+	return nil
+}
+
+// 发送退出通知，只包含汇总信息
+func notifyShutdown(endpoint string, snapshot agentSnapshot) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"event":      "shutdown",
+		"time":       snapshot.StoppedAt,
+		"signal":     snapshot.Signal,
+		"runs":       snapshot.Runs,
+		"last_run":   snapshot.LastRun,
+		"available":  len(filterSuccess(snapshot.Results)),
+		"total":      len(snapshot.Results),
+		"generation": snapshot.ConfigGeneration,
+	})
+	if err != nil {
+		return fmt.Errorf("序列化退出通知失败: %v", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("发送退出通知失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("发送退出通知失败，状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// 重新加载配置的信号
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	// 立即检测并输出状态的信号
+	dumpSignals = []os.Signal{syscall.SIGUSR1}
+)
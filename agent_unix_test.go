@@ -0,0 +1,116 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"docker-registry-checker/api"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// 通过gRPC接口驱动真实的agent检测循环，最后用SIGINT让agent退出
+func TestRunAgentGRPC(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "list.txt")
+	history := filepath.Join(dir, "history.jsonl")
+	configPath := filepath.Join(dir, "agent.yaml")
+	socket := filepath.Join(dir, "agent.sock")
+	if err := os.WriteFile(list, []byte(registry.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf("list: %s\ninterval: 1h\ntimeout: 5s\nhistory: %s\n", list, history)
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- runAgent([]string{"-config", configPath, "-grpc-listen", "unix:" + socket})
+	}()
+	waitFor(t, "gRPC接口启动", func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	})
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unix socket的权限应为0600: %v, %v", info.Mode(), err)
+	}
+
+	conn := dialTestGRPC(t, "unix:"+socket, insecure.NewCredentials())
+	defer conn.Close()
+	client := api.NewCheckServiceClient(conn)
+	ctx := context.Background()
+
+	// 启动时的第一轮检测可能还没有结束，此时返回 UNAVAILABLE
+	startRun := func(req *api.StartRunRequest) string {
+		var id string
+		waitFor(t, "StartRun", func() bool {
+			resp, err := client.StartRun(ctx, req)
+			if status.Code(err) == codes.Unavailable {
+				return false
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			id = resp.RunId
+			return true
+		})
+		return id
+	}
+
+	results, err := streamAll(t, client, startRun(&api.StartRunRequest{Hosts: []string{registry.URL}}))
+	if err != nil || len(results) != 1 || !results[0].Available {
+		t.Fatalf("指定hosts的检测: %v, %v", results, err)
+	}
+	if results, err := streamAll(t, client, startRun(&api.StartRunRequest{})); err != nil || len(results) != 1 {
+		t.Fatalf("按配置检测: %v, %v", results, err)
+	}
+
+	// 启动时和按配置的两轮检测写入了历史记录，指定hosts的检测没有
+	resp, err := client.GetHistory(ctx, &api.GetHistoryRequest{})
+	if err != nil || len(resp.Runs) != 2 {
+		t.Fatalf("历史记录应有2轮检测: %v, %v", resp, err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("agent没有退出")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("退出后unix socket没有删除: %v", err)
+	}
+}
+
+// 等待cond成立，最多5秒
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("等待%s超时", what)
+}
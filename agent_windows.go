@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows 不支持 SIGHUP / SIGUSR1
+var (
+	reloadSignals []os.Signal
+	dumpSignals   []os.Signal
+)
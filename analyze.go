@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// analyze 子命令：离线分析之前保存的检测结果，不发起任何网络请求
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	listSuccess := fs.Bool("l", false, "只显示成功的结果")
+	sortKey := fs.String("sort", "host", "排序字段 (host/time/ttfb/warm/status/score)")
+	output := fs.String("output", "table", "输出格式 (table/json/csv/yaml)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker analyze [参数] <结果文件>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("请指定一个结果文件")
+	}
+
+	allResults, err := loadResults(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var displayResults []CheckResult
+	if *listSuccess {
+		displayResults = filterSuccess(allResults)
+	} else {
+		displayResults = append([]CheckResult(nil), allResults...)
+	}
+
+	if err := sortResults(displayResults, *sortKey); err != nil {
+		return err
+	}
+
+	if err := writeResults(os.Stdout, displayResults, *output); err != nil {
+		return err
+	}
+
+	if *output == "table" {
+		writeDetails(os.Stdout, displayResults)
+		writeSourceStats(os.Stdout, allResults)
+		printSummary(allResults)
+	}
+	return nil
+}
+
+// 输出结果统计信息
+func printSummary(results []CheckResult) {
+	successResults := filterSuccess(results)
+	timeoutCount := 0
+	for _, result := range results {
+		if result.IsTimeout {
+			timeoutCount++
+		}
+	}
+
+	fmt.Printf("\n统计: 成功 %d, 失败 %d (其中超时 %d), 总计 %d\n",
+		len(successResults), len(results)-len(successResults), timeoutCount, len(results))
+
+	if len(successResults) == 0 {
+		return
+	}
+
+	var total time.Duration
+	fastest := successResults[0]
+	for _, result := range successResults {
+		total += result.Time
+		if result.Time < fastest.Time {
+			fastest = result
+		}
+	}
+	average := total / time.Duration(len(successResults))
+	fmt.Printf("最快: %s (%.2fs), 平均响应时间: %.2fs\n",
+		fastest.Host, fastest.Time.Seconds(), average.Seconds())
+}
@@ -0,0 +1,261 @@
+// docker-registry-checker 的 gRPC 接口定义
+//
+// 由 agent 子命令在指定 -grpc-listen 时提供，字段与 JSON 输出 (-output json) 保持一致。
+// 修改后在 api 目录中执行 go generate 重新生成Go代码。
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: checker.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	CheckService_StartRun_FullMethodName      = "/registrychecker.v1.CheckService/StartRun"
+	CheckService_StreamResults_FullMethodName = "/registrychecker.v1.CheckService/StreamResults"
+	CheckService_GetHistory_FullMethodName    = "/registrychecker.v1.CheckService/GetHistory"
+	CheckService_ApplyConfig_FullMethodName   = "/registrychecker.v1.CheckService/ApplyConfig"
+)
+
+// CheckServiceClient is the client API for CheckService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CheckServiceClient interface {
+	// 立即开始一轮检测，返回本轮检测的ID；已有检测正在进行时返回 UNAVAILABLE
+	StartRun(ctx context.Context, in *StartRunRequest, opts ...grpc.CallOption) (*StartRunResponse, error)
+	// 按完成顺序推送检测结果，检测结束后关闭流
+	StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (CheckService_StreamResultsClient, error)
+	// 查询历史记录 (与 report 子命令使用相同的数据)
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+	// 将最近一轮按 agent 配置完成的检测中最快的镜像源写入本机配置，与 -apply 相同
+	ApplyConfig(ctx context.Context, in *ApplyConfigRequest, opts ...grpc.CallOption) (*ApplyConfigResponse, error)
+}
+
+type checkServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCheckServiceClient(cc grpc.ClientConnInterface) CheckServiceClient {
+	return &checkServiceClient{cc}
+}
+
+func (c *checkServiceClient) StartRun(ctx context.Context, in *StartRunRequest, opts ...grpc.CallOption) (*StartRunResponse, error) {
+	out := new(StartRunResponse)
+	err := c.cc.Invoke(ctx, CheckService_StartRun_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkServiceClient) StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (CheckService_StreamResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CheckService_ServiceDesc.Streams[0], CheckService_StreamResults_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &checkServiceStreamResultsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CheckService_StreamResultsClient interface {
+	Recv() (*CheckResult, error)
+	grpc.ClientStream
+}
+
+type checkServiceStreamResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *checkServiceStreamResultsClient) Recv() (*CheckResult, error) {
+	m := new(CheckResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *checkServiceClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, CheckService_GetHistory_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkServiceClient) ApplyConfig(ctx context.Context, in *ApplyConfigRequest, opts ...grpc.CallOption) (*ApplyConfigResponse, error) {
+	out := new(ApplyConfigResponse)
+	err := c.cc.Invoke(ctx, CheckService_ApplyConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CheckServiceServer is the server API for CheckService service.
+// All implementations must embed UnimplementedCheckServiceServer
+// for forward compatibility
+type CheckServiceServer interface {
+	// 立即开始一轮检测，返回本轮检测的ID；已有检测正在进行时返回 UNAVAILABLE
+	StartRun(context.Context, *StartRunRequest) (*StartRunResponse, error)
+	// 按完成顺序推送检测结果，检测结束后关闭流
+	StreamResults(*StreamResultsRequest, CheckService_StreamResultsServer) error
+	// 查询历史记录 (与 report 子命令使用相同的数据)
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	// 将最近一轮按 agent 配置完成的检测中最快的镜像源写入本机配置，与 -apply 相同
+	ApplyConfig(context.Context, *ApplyConfigRequest) (*ApplyConfigResponse, error)
+	mustEmbedUnimplementedCheckServiceServer()
+}
+
+// UnimplementedCheckServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCheckServiceServer struct {
+}
+
+func (UnimplementedCheckServiceServer) StartRun(context.Context, *StartRunRequest) (*StartRunResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartRun not implemented")
+}
+func (UnimplementedCheckServiceServer) StreamResults(*StreamResultsRequest, CheckService_StreamResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamResults not implemented")
+}
+func (UnimplementedCheckServiceServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedCheckServiceServer) ApplyConfig(context.Context, *ApplyConfigRequest) (*ApplyConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyConfig not implemented")
+}
+func (UnimplementedCheckServiceServer) mustEmbedUnimplementedCheckServiceServer() {}
+
+// UnsafeCheckServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CheckServiceServer will
+// result in compilation errors.
+type UnsafeCheckServiceServer interface {
+	mustEmbedUnimplementedCheckServiceServer()
+}
+
+func RegisterCheckServiceServer(s grpc.ServiceRegistrar, srv CheckServiceServer) {
+	s.RegisterService(&CheckService_ServiceDesc, srv)
+}
+
+func _CheckService_StartRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckServiceServer).StartRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckService_StartRun_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckServiceServer).StartRun(ctx, req.(*StartRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckService_StreamResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CheckServiceServer).StreamResults(m, &checkServiceStreamResultsServer{stream})
+}
+
+type CheckService_StreamResultsServer interface {
+	Send(*CheckResult) error
+	grpc.ServerStream
+}
+
+type checkServiceStreamResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *checkServiceStreamResultsServer) Send(m *CheckResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CheckService_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckServiceServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckService_GetHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckServiceServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckService_ApplyConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckServiceServer).ApplyConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckService_ApplyConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckServiceServer).ApplyConfig(ctx, req.(*ApplyConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CheckService_ServiceDesc is the grpc.ServiceDesc for CheckService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CheckService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "registrychecker.v1.CheckService",
+	HandlerType: (*CheckServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartRun",
+			Handler:    _CheckService_StartRun_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _CheckService_GetHistory_Handler,
+		},
+		{
+			MethodName: "ApplyConfig",
+			Handler:    _CheckService_ApplyConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResults",
+			Handler:       _CheckService_StreamResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "checker.proto",
+}
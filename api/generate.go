@@ -0,0 +1,4 @@
+// Package api 是 checker.proto 生成的 gRPC 接口代码 (CheckService)。
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative checker.proto
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 原子地写入配置文件
+//
+// 先写入同目录下的临时文件并fsync，通过validate校验后再重命名覆盖原文件，
+// 写入中途崩溃或内容无效时原文件保持不变。原文件存在时保留其权限。
+func atomicWriteFile(path string, data []byte, validate func(tmpPath string) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	if validate != nil {
+		if err := validate(tmp.Name()); err != nil {
+			return fmt.Errorf("新配置校验失败，未修改 %s: %v", path, err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// 同步目录，确保重命名在断电后仍然有效
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// 校验写入的配置: 按目标的格式重新解析，检查镜像源与预期一致，dockerd的配置还会尽量用 dockerd --validate 校验
+func validateTargetConfig(target dockerTarget, expected *DaemonConfig) func(string) error {
+	return func(tmpPath string) error {
+		parsed := target
+		parsed.ConfigPath = tmpPath
+		config, err := parsed.readConfig()
+		if err != nil {
+			return err
+		}
+		if expected != nil && !sameMirrors(config.RegistryMirrors, expected.RegistryMirrors) {
+			return fmt.Errorf("重新读取的镜像源与预期不一致: %v", config.RegistryMirrors)
+		}
+		if target.Format == configDaemonJSON {
+			return dockerdValidate(tmpPath)
+		}
+		return nil
+	}
+}
+
+// 使用 dockerd --validate 校验daemon.json (Docker 23.0起支持)，没有dockerd或版本不支持时跳过
+func dockerdValidate(path string) error {
+	dockerd, err := exec.LookPath("dockerd")
+	if err != nil {
+		return nil
+	}
+	out, err := exec.Command(dockerd, "--validate", "--config-file", path).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	message := strings.TrimSpace(string(out))
+	if strings.Contains(message, "unknown flag") {
+		return nil
+	}
+	return fmt.Errorf("dockerd --validate: %s", message)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 系统级Docker的daemon.json路径
+const daemonConfigPath = "/etc/docker/daemon.json"
+
+// 备份文件名的时间格式，如 daemon.json.bak.20240102-150405
+const backupTimeFormat = "20060102-150405"
+
+// 将当前的daemon.json复制为带时间戳的备份，文件不存在时不备份，返回备份路径
+func backupDaemonConfig(configPath string) (string, error) {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("读取daemon.json失败: %v", err)
+	}
+
+	path := configPath + ".bak." + time.Now().Format(backupTimeFormat)
+	// 同一秒内多次备份时追加序号，避免覆盖
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = fmt.Sprintf("%s.bak.%s-%d", configPath, time.Now().Format(backupTimeFormat), i)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("备份daemon.json失败: %v", err)
+	}
+	return path, nil
+}
+
+// 列出所有备份，最新的在前
+func listBackups(configPath string) ([]string, error) {
+	backups, err := filepath.Glob(configPath + ".bak.*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// restore 子命令：列出daemon.json的备份并恢复选中的一个
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	list := fs.Bool("list", false, "只列出备份")
+	yes := fs.Bool("y", false, "恢复前不再确认")
+	restart := fs.Bool("restart", false, "恢复后重启Docker服务")
+	answersPath := fs.String("answers", "", "从YAML应答文件读取选择的备份 (backup) 和确认 (restore)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker restore [参数] [编号|备份文件]")
+		fmt.Fprintln(fs.Output(), "不指定时交互式选择，编号1为最新的备份")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := useAnswersFile(*answersPath); err != nil {
+		return err
+	}
+
+	target := detectDockerTarget()
+	backups, err := listBackups(target.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("没有找到daemon.json的备份")
+	}
+
+	fmt.Println("daemon.json 的备份 (最新的在前):")
+	for i, backup := range backups {
+		fmt.Printf("%d. %s\n", i+1, backup)
+	}
+	if *list {
+		return nil
+	}
+
+	choice := fs.Arg(0)
+	if choice == "" {
+		choice, _ = prompter.Ask("backup", "请选择要恢复的备份编号: ")
+	}
+
+	var backup string
+	if index, err := strconv.Atoi(choice); err == nil {
+		if index < 1 || index > len(backups) {
+			return fmt.Errorf("无效的编号: %d", index)
+		}
+		backup = backups[index-1]
+	} else if containsString(backups, choice) {
+		backup = choice
+	} else {
+		return fmt.Errorf("不是daemon.json的备份: %s", choice)
+	}
+
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return fmt.Errorf("读取备份失败: %v", err)
+	}
+
+	if !*yes && !confirm("restore", fmt.Sprintf("\n%s 的内容:\n%s\n\n将用以上内容覆盖 %s，是否继续? (y/n): ", backup, data, target.ConfigPath)) {
+		return fmt.Errorf("已取消")
+	}
+
+	// 恢复前同样备份当前配置，恢复错了也能再恢复回来
+	current, err := backupDaemonConfig(target.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		fmt.Printf("当前配置已备份到 %s\n", current)
+	}
+
+	if err := atomicWriteFile(target.ConfigPath, data, validateTargetConfig(target, nil)); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	fmt.Printf("已恢复 %s\n", backup)
+
+	if !*restart {
+		fmt.Printf("执行 %s 后生效\n", target.Restart)
+		return nil
+	}
+	fmt.Println("正在重启Docker服务...")
+	if err := execCommand(target.Restart); err != nil {
+		return fmt.Errorf("重启Docker服务失败: %v", err)
+	}
+	fmt.Println("Docker服务已重启")
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+)
+
+// 默认的黑名单文件，与docker.txt放在同一目录
+const defaultBlocklistPath = "blocklist.txt"
+
+// 黑名单文件，path为空时使用默认位置
+//
+// 黑名单使用列表文件的格式，每行一个host，# 之后为原因，如:
+//
+//	mirror.example.com  # 返回过期的镜像 2024-01-02
+func blocklistFile(path string) hostFile {
+	if path == "" {
+		path = defaultBlocklistPath
+	}
+	return hostFile{Path: path, Name: "黑名单", Header: "docker-registry-checker 黑名单，检测时跳过以下镜像源，也不会推荐或写入配置", Flag: "blocklist"}
+}
+
+// 读取黑名单，返回host到原因 (注释) 的映射，文件不存在时返回空的黑名单
+func loadBlocklist(path string) (map[string]string, error) {
+	entries, err := blocklistFile(path).load()
+	if err != nil {
+		return nil, err
+	}
+	blocked := map[string]string{}
+	for _, entry := range entries {
+		blocked[entry.Host] = entry.Comment
+	}
+	return blocked, nil
+}
+
+// 去掉黑名单中的条目，返回剩余的条目和被跳过的host
+func filterBlocked(entries []listEntry, blocked map[string]string) ([]listEntry, []string) {
+	var kept []listEntry
+	var skipped []string
+	for _, entry := range entries {
+		if _, ok := blocked[entry.Host]; ok {
+			if !containsString(skipped, entry.Host) {
+				skipped = append(skipped, entry.Host)
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, skipped
+}
+
+// 合并镜像源时去掉现有配置中已加入黑名单的镜像源
+func withoutBlocked(mirrors []string, blocked map[string]string) []string {
+	var kept []string
+	for _, mirror := range mirrors {
+		if _, ok := blocked[mirrorHost(mirror)]; ok {
+			fmt.Printf("去掉现有配置中黑名单里的镜像源 %s\n", mirror)
+			continue
+		}
+		kept = append(kept, mirror)
+	}
+	return kept
+}
+
+// block 子命令：把镜像源加入黑名单，之后的检测会跳过这些镜像源，也不会推荐或写入配置
+func runBlock(args []string) error {
+	return runHostFileAdd("block", blocklistFile(""), args)
+}
+
+// unblock 子命令：把镜像源移出黑名单
+func runUnblock(args []string) error {
+	return runHostFileRemove("unblock", blocklistFile(""), args)
+}
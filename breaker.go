@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 同一镜像源连续连接失败达到该次数后，本轮检测不再尝试 (包括重试和其他分类中的同一镜像源)
+const breakerThreshold = 3
+
+// 本轮检测的重试总次数上限: 镜像源数量的20%，至少10次
+//
+// 列表中大量镜像源已经失效时，每个都按 -retries 重试会让检测时间成倍增加，
+// 而真正偶发的故障通常只占少数。
+const (
+	retryBudgetRatio = 0.2
+	retryBudgetMin   = 10
+)
+
+// 按镜像源记录连续的连接失败 (熔断) 和本轮剩余的重试次数
+//
+// 域名无法解析的镜像源一次就熔断，连接被拒绝、超时等需要连续失败breakerThreshold次。
+// 收到任何HTTP响应都说明镜像源还活着，计数清零。nil表示不熔断也不限制重试次数。
+type hostBreaker struct {
+	mu       sync.Mutex
+	failures map[string]int
+	// 剩余的重试次数
+	budget int
+}
+
+func newHostBreaker(entries int) *hostBreaker {
+	budget := int(float64(entries) * retryBudgetRatio)
+	if budget < retryBudgetMin {
+		budget = retryBudgetMin
+	}
+	return &hostBreaker{failures: map[string]int{}, budget: budget}
+}
+
+// 镜像源是否还可以尝试
+func (b *hostBreaker) allow(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures[host] < breakerThreshold
+}
+
+// 记录一次探测的结果
+func (b *hostBreaker) record(host string, result CheckResult) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case result.StatusCode != 0:
+		delete(b.failures, host)
+	case isNoSuchHost(result.Error):
+		b.failures[host] = breakerThreshold
+	default:
+		b.failures[host]++
+	}
+}
+
+// 是否还可以重试，可以时占用一次重试次数
+func (b *hostBreaker) retry(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures[host] >= breakerThreshold || b.budget <= 0 {
+		return false
+	}
+	b.budget--
+	return true
+}
+
+// 熔断后跳过检测的结果
+func breakerResult(host string) CheckResult {
+	return CheckResult{
+		Host:  host,
+		Error: fmt.Sprintf("已跳过: 本轮检测中连续 %d 次连接失败或域名无法解析", breakerThreshold),
+	}
+}
+
+// 域名无法解析，重试也不会成功
+func isNoSuchHost(err string) bool {
+	return strings.Contains(err, "no such host")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// buildkitd.toml 中Docker Hub的表头
+const buildkitRegistryTable = `[registry."docker.io"]`
+
+// 独立运行的buildkitd (如CI机器上)，配置位于 /etc/buildkit/buildkitd.toml
+var buildkitTarget = dockerTarget{
+	Name:       "buildkit",
+	ConfigPath: "/etc/buildkit/buildkitd.toml",
+	Format:     configBuildkitTOML,
+	Binary:     "buildkitd",
+	Reload:     "systemctl restart buildkit",
+	Restart:    "systemctl restart buildkit",
+	NeedRoot:   true,
+}
+
+// docker buildx 的builder运行在容器中，不读取宿主机的daemon.json，
+// 新建builder时默认使用 ~/.docker/buildx/buildkitd.default.toml
+func buildxTarget() dockerTarget {
+	home, _ := os.UserHomeDir()
+	return dockerTarget{
+		Name:       "buildx",
+		ConfigPath: filepath.Join(home, ".docker", "buildx", "buildkitd.default.toml"),
+		Format:     configBuildkitTOML,
+		Note:       "已有的builder不会读取新配置，需要重新创建: docker buildx rm <名称> && docker buildx create --use --name <名称>",
+	}
+}
+
+// 读取buildkitd.toml中docker.io的mirrors
+//
+// mirrors中写了协议时原样保留；只写主机名时按该镜像源的表中是否有 http = true 补上 http:// 或 https://。
+func readBuildkitTOML(path string) (*DaemonConfig, error) {
+	config := &DaemonConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取buildkitd.toml失败: %v", err)
+	}
+
+	var mirrors []string
+	plainHTTP := map[string]bool{}
+	table := ""
+	lines := splitLines(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "[") {
+			table = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key = strings.TrimSpace(key); {
+		case table == buildkitRegistryTable && key == "mirrors":
+			mirrors, i = scanTOMLArray(lines, i, value)
+		case key == "http" && strings.HasPrefix(strings.TrimSpace(value), "true"):
+			if host, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(table, "[registry."), "]")); err == nil {
+				plainHTTP[host] = true
+			}
+		default:
+			// 其他跨多行的数组 (如 insecure-entitlements) 的后续行不当作表头或键值
+			if strings.HasPrefix(strings.TrimSpace(value), "[") {
+				_, i = scanTOMLArray(lines, i, value)
+			}
+		}
+	}
+	for _, mirror := range mirrors {
+		switch {
+		case strings.Contains(mirror, "://"):
+		case plainHTTP[mirror]:
+			mirror = "http://" + mirror
+		default:
+			mirror = "https://" + mirror
+		}
+		config.RegistryMirrors = append(config.RegistryMirrors, mirror)
+	}
+	return config, nil
+}
+
+// 读取从第i行的value开始、可能跨多行的TOML数组，返回其中的字符串和数组最后一行的下标
+//
+// 只处理字符串数组，引号中的括号和 # 之后的注释不计入；value不是数组时返回nil和i。
+func scanTOMLArray(lines []string, i int, value string) ([]string, int) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return nil, i
+	}
+	var items []string
+	depth := 0
+	for text := value; ; {
+		for j := 0; j < len(text); j++ {
+			switch c := text[j]; c {
+			case '#':
+				j = len(text)
+			case '[':
+				depth++
+			case ']':
+				depth--
+				if depth == 0 {
+					return items, i
+				}
+			case '"', '\'':
+				// 找到字符串的结尾，双引号字符串中跳过转义
+				end := j + 1
+				for end < len(text) && text[end] != c {
+					if c == '"' && text[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(text) {
+					j = len(text)
+					break
+				}
+				if c == '"' {
+					if item, err := strconv.Unquote(text[j : end+1]); err == nil {
+						items = append(items, item)
+					}
+				} else {
+					items = append(items, text[j+1:end])
+				}
+				j = end
+			}
+		}
+		// 数组没有闭合时读到文件末尾为止
+		if i+1 >= len(lines) {
+			return items, i
+		}
+		i++
+		text = lines[i]
+	}
+}
+
+// 修改buildkitd.toml中docker.io的mirrors，其他配置逐行保留
+//
+// buildkit的mirrors只写主机名，不带 https://；HTTP镜像源另外添加 http = true 的表，
+// 已有该镜像源的表时保持不变。
+func renderBuildkitTOML(path string, config *DaemonConfig) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取buildkitd.toml失败: %v", err)
+	}
+
+	hosts := make([]string, 0, len(config.RegistryMirrors))
+	for _, mirror := range config.RegistryMirrors {
+		hosts = append(hosts, strconv.Quote(mirrorHost(mirror)))
+	}
+	mirrorsLine := "  mirrors = [" + strings.Join(hosts, ", ") + "]"
+
+	// mirrors写在表头的下一行，表中原有的mirrors (包括跨多行的数组) 去掉，其他跨多行的数组原样保留
+	var out []string
+	inTable, written := false, false
+	lines := splitLines(string(data))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inTable = trimmed == buildkitRegistryTable
+			if inTable && !written {
+				out = append(out, line, mirrorsLine)
+				written = true
+				continue
+			}
+		} else if key, value, ok := strings.Cut(trimmed, "="); ok {
+			_, end := scanTOMLArray(lines, i, value)
+			if inTable && strings.TrimSpace(key) == "mirrors" {
+				i = end
+				continue
+			}
+			out = append(out, lines[i:end+1]...)
+			i = end
+			continue
+		}
+		out = append(out, line)
+	}
+	if !written {
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, buildkitRegistryTable, mirrorsLine)
+	}
+	for _, mirror := range config.RegistryMirrors {
+		table := fmt.Sprintf("[registry.%q]", mirrorHost(mirror))
+		if strings.HasPrefix(mirror, "http://") && !hasLine(out, table) {
+			out = append(out, "", table, "  http = true")
+		}
+	}
+	return []byte(strings.Join(out, "\n") + "\n"), nil
+}
+
+// 是否有去掉首尾空白后与line相同的行
+func hasLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildkitTOML(t *testing.T) {
+	existing := `debug = true
+insecure-entitlements = [
+  "network.host",
+  "security.insecure",
+]
+
+[registry."docker.io"]
+  mirrors = [
+    "old.example.com", # 旧的镜像源
+    "http://plain.example.com",
+    "bracket.example.com:5000",
+  ]
+  ca = ["/etc/certs/ca.pem"]
+
+[registry."bracket.example.com:5000"]
+  http = true
+
+[worker.oci]
+  enabled = true
+`
+	path := filepath.Join(t.TempDir(), "buildkitd.toml")
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readBuildkitTOML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 写了协议时原样保留，只写主机名时按 http = true 补上协议
+	want := []string{"https://old.example.com", "http://plain.example.com", "http://bracket.example.com:5000"}
+	if !reflect.DeepEqual(config.RegistryMirrors, want) {
+		t.Fatalf("mirrors = %v，期望 %v", config.RegistryMirrors, want)
+	}
+
+	config.RegistryMirrors = []string{"https://a.example.com", "http://b.example.com"}
+	data, err := renderBuildkitTOML(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, removed := range []string{"old.example.com", "plain.example.com\"", "旧的镜像源"} {
+		if strings.Contains(out, removed) {
+			t.Errorf("原来的mirrors没有完全去掉 (%s):\n%s", removed, out)
+		}
+	}
+	for _, kept := range []string{"debug = true", "  \"security.insecure\",\n]", `ca = ["/etc/certs/ca.pem"]`, "[registry.\"bracket.example.com:5000\"]\n  http = true", "[worker.oci]\n  enabled = true"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("其他配置丢失 (%s):\n%s", kept, out)
+		}
+	}
+	// 多行数组只剩下未闭合的 ] 时写入的文件无效
+	if strings.Count(out, "[")-strings.Count(out, "]") != 0 {
+		t.Errorf("括号不匹配:\n%s", out)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = readBuildkitTOML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://a.example.com", "http://b.example.com"}; !reflect.DeepEqual(config.RegistryMirrors, want) {
+		t.Errorf("写入后读取的mirrors = %v，期望 %v\n%s", config.RegistryMirrors, want, out)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// 默认的结果缓存文件，保存在当前目录
+const defaultCachePath = ".docker-registry-checker.cache.json"
+
+// 最近的检测结果，按镜像源 (和上游) 缓存，-cache 指定的时间内再次检测时直接使用
+//
+// 调整参数后反复检测同一个列表时，只有影响检测结果的参数 (探测方式、超时、判定规则等) 不变的结果才会使用，
+// 其余镜像源照常检测。
+type resultCache struct {
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+type cacheEntry struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// 检测时影响结果的参数，与本次不同时不使用
+	Options string      `json:"options"`
+	Result  CheckResult `json:"result"`
+}
+
+// 读取缓存文件，文件不存在时返回空缓存
+func loadResultCache(path string) (*resultCache, error) {
+	cache := &resultCache{Entries: map[string]cacheEntry{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取结果缓存失败: %v", err)
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("解析结果缓存失败: %v", err)
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]cacheEntry{}
+	}
+	return cache, nil
+}
+
+// 分出缓存中仍然有效的结果和需要检测的镜像源
+func (c *resultCache) lookup(entries []listEntry, options string, ttl time.Duration, now time.Time) (cached []CheckResult, remaining []listEntry) {
+	for _, entry := range entries {
+		cacheEntry, ok := c.Entries[checkpointKey(entry.Host, entry.Upstream)]
+		if ok && cacheEntry.Options == options && now.Sub(cacheEntry.CheckedAt) < ttl {
+			result := cacheEntry.Result
+			result.Cached = true
+			cached = append(cached, result)
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	return cached, remaining
+}
+
+// 记录本次检测的结果，从缓存中取出的结果保持原来的检测时间
+func (c *resultCache) store(result CheckResult, options string, now time.Time) {
+	if result.Cached {
+		return
+	}
+	c.Entries[checkpointKey(result.Host, result.Upstream)] = cacheEntry{CheckedAt: now, Options: options, Result: result}
+}
+
+// 删除过期的结果后写入缓存文件
+func (c *resultCache) save(path string, ttl time.Duration, now time.Time) error {
+	for key, entry := range c.Entries {
+		if now.Sub(entry.CheckedAt) >= ttl {
+			delete(c.Entries, key)
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("序列化结果缓存失败: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入结果缓存失败: %v", err)
+	}
+	return nil
+}
+
+// 影响检测结果的参数，参数不同时缓存的结果不能使用
+func (o checkOptions) cacheOptions() string {
+	reject := ""
+	if o.Criteria.RejectRedirect != nil {
+		reject = o.Criteria.RejectRedirect.String()
+	}
+	connect, tls, response := o.phaseTimeouts()
+	return fmt.Sprintf("method=%s path=%s status=%v reject=%q timeout=%s/%s/%s/%s http3=%t warm=%t warmup=%t oci=%s integrity=%t per-ip=%t plugins=%s",
+		o.Method, o.ProbePath, o.Criteria.Status, reject, o.Timeout, connect, tls, response,
+		o.HTTP3, o.Warm, o.Warmup, o.OCIImage, o.Integrity != nil, o.PerIP, strings.Join(o.Plugins, ","))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+)
+
+// 按上游分类的镜像源列表，每个分类对应仓库中的一个列表文件
+//
+// 列表中没有标注 upstream= 的镜像源按分类的上游检测，
+// 如 ghcr.txt 中的镜像源需要能拉取到 ghcr.io 上的镜像才视为可用。
+type listCategory struct {
+	Name     string
+	File     string
+	Upstream string
+}
+
+// 支持的分类，顺序即 -category all 时的检测顺序
+var listCategories = []listCategory{
+	{Name: "docker-hub", File: "docker.txt", Upstream: "docker.io"},
+	{Name: "ghcr", File: "ghcr.txt", Upstream: "ghcr.io"},
+	{Name: "k8s", File: "k8s.txt", Upstream: "registry.k8s.io"},
+	{Name: "gcr", File: "gcr.txt", Upstream: "gcr.io"},
+	{Name: "quay", File: "quay.txt", Upstream: "quay.io"},
+}
+
+// 分类名称的别名，也可以直接使用上游名称 (如 ghcr.io)
+var categoryAliases = map[string]string{
+	"dockerhub":       "docker-hub",
+	"docker":          "docker-hub",
+	"docker.io":       "docker-hub",
+	"ghcr.io":         "ghcr",
+	"registry.k8s.io": "k8s",
+	"gcr.io":          "gcr",
+	"quay.io":         "quay",
+}
+
+// 程序内置的各分类列表，本地没有列表文件并且无法从GitHub下载时使用
+//
+//go:embed docker.txt ghcr.txt k8s.txt gcr.txt quay.txt
+var builtinLists embed.FS
+
+// 解析 -category 参数，逗号分隔，all 表示所有分类，为空时只检测 docker-hub
+func parseCategories(spec string) ([]listCategory, error) {
+	if strings.TrimSpace(spec) == "" {
+		return listCategories[:1], nil
+	}
+	var categories []listCategory
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			return listCategories, nil
+		}
+		if alias, ok := categoryAliases[name]; ok {
+			name = alias
+		}
+		category, ok := findCategory(name)
+		if !ok {
+			return nil, fmt.Errorf("未知的分类: %q (支持: %s、all)", name, strings.Join(categoryNames(), "、"))
+		}
+		if !containsCategory(categories, category.Name) {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		return listCategories[:1], nil
+	}
+	return categories, nil
+}
+
+func findCategory(name string) (listCategory, bool) {
+	for _, category := range listCategories {
+		if category.Name == name {
+			return category, true
+		}
+	}
+	return listCategory{}, false
+}
+
+func containsCategory(categories []listCategory, name string) bool {
+	for _, category := range categories {
+		if category.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func categoryNames() []string {
+	names := make([]string, 0, len(listCategories))
+	for _, category := range listCategories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+// 分类对应的解析器，未标注 upstream= 的镜像源使用分类的上游
+func newCategoryParser(c listCategory) *listParser {
+	p := newListParser()
+	p.upstream = c.Upstream
+	return p
+}
+
+// 读取分类的列表文件
+func (c listCategory) read() ([]listEntry, error) {
+	return newCategoryParser(c).parseFile(c.File)
+}
+
+// 本地补充列表的文件名，如 docker.local.txt
+//
+// 本地列表总是合并在下载的列表之前 (相同的镜像源以本地列表中的标注为准)，-update 不会覆盖，
+// 适合添加公司内部的镜像源。
+func (c listCategory) localFile() string {
+	return strings.TrimSuffix(c.File, ".txt") + ".local.txt"
+}
+
+// 读取本地补充列表，文件不存在时返回nil
+func (c listCategory) readLocal() ([]listEntry, error) {
+	path := c.localFile()
+	if !fileExists(path) {
+		return nil, nil
+	}
+	entries, err := newCategoryParser(c).parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(infoOut, "已合并本地列表 %s (%d 个镜像源)\n", path, len(entries))
+	return entries, nil
+}
+
+// 读取程序内置的分类列表
+func (c listCategory) readBuiltin() ([]listEntry, error) {
+	fmt.Fprintf(infoOut, "使用程序内置的%s列表，可能不是最新的\n", c.File)
+	data, err := builtinLists.ReadFile(c.File)
+	if err != nil {
+		return nil, err
+	}
+	return newCategoryParser(c).parse(data, builtinListSource)
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 定义检查结果的结构体
+type CheckResult struct {
+	Host      string `json:"host" yaml:"host"`
+	Available bool   `json:"available" yaml:"available"`
+	// 从发起请求到读完响应体的总耗时
+	Time time.Duration `json:"time" yaml:"time"`
+	// 从发起请求到收到响应第一个字节的耗时
+	TTFB       time.Duration `json:"ttfb,omitempty" yaml:"ttfb,omitempty"`
+	StatusCode int           `json:"status_code" yaml:"status_code"`
+	IsTimeout  bool          `json:"timeout" yaml:"timeout"`
+	// 超时发生的阶段: connect (包括DNS解析)、tls 或 response (等待响应)
+	TimeoutPhase string `json:"timeout_phase,omitempty" yaml:"timeout_phase,omitempty"`
+	Method       string `json:"method,omitempty" yaml:"method,omitempty"`
+	IP           string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	// 证书是否能通过系统根证书和主机名校验 (检测时本身不校验证书)
+	TLSVerified bool `json:"tls_verified" yaml:"tls_verified"`
+	// 通过HTTP访问的镜像源，配置时需要加入insecure-registries
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// 协商的HTTP协议版本，如 HTTP/1.1、HTTP/2.0
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// 响应的Alt-Svc头是否声明支持HTTP/3
+	H3Advertised bool `json:"h3_advertised,omitempty" yaml:"h3_advertised,omitempty"`
+	// QUIC探测是否成功 (仅在开启 -http3 时探测)
+	QUIC bool `json:"quic,omitempty" yaml:"quic,omitempty"`
+	// 复用已有连接 (keep-alive) 时的响应时间，Time为新建连接时的响应时间
+	WarmTime time.Duration `json:"warm_time,omitempty" yaml:"warm_time,omitempty"`
+	// OCI清单与referrers API支持情况 (仅在开启 -oci 时探测)
+	OCI *OCIResult `json:"oci,omitempty" yaml:"oci,omitempty"`
+	// 与Docker Hub的内容一致性比对 (仅在开启 -integrity 时比对)
+	Integrity *IntegrityResult `json:"integrity,omitempty" yaml:"integrity,omitempty"`
+	// 实际发起的探测次数 (包括重试)
+	Attempts int `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	// 结果是否来自对冲发出的第二次探测 (仅在开启 -hedge 时)，此时Time包括发出对冲请求前等待的时间
+	Hedged bool `json:"hedged,omitempty" yaml:"hedged,omitempty"`
+	// 探测请求经过的重定向，按先后顺序排列
+	Redirects []RedirectHop `json:"redirects,omitempty" yaml:"redirects,omitempty"`
+	// 逐IP检测结果 (仅在开启 -per-ip 时检测)
+	IPs []IPResult `json:"ips,omitempty" yaml:"ips,omitempty"`
+	// 镜像源代理的上游，为空时表示Docker Hub
+	Upstream string `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	// 列表中标注的地区、提供方和是否需要登录
+	Region   string `json:"region,omitempty" yaml:"region,omitempty"`
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	Auth     string `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// 只有一种地址族时为 ipv4 或 ipv6，双栈时为空
+	Family string `json:"family,omitempty" yaml:"family,omitempty"`
+	// 检测失败的原因
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+	// 外部探测插件的结果
+	Plugins []PluginResult `json:"plugins,omitempty" yaml:"plugins,omitempty"`
+	// 综合评分 (0-100)
+	Score float64 `json:"score" yaml:"score"`
+	// 该镜像源所在的列表文件或URL (同一个host可能出现在多个列表中)
+	Sources []string `json:"sources,omitempty" yaml:"sources,omitempty"`
+	// 在置顶列表中的顺序 (从1开始)，0表示没有置顶
+	Pinned int `json:"pinned,omitempty" yaml:"pinned,omitempty"`
+	// 是否是 -cache 缓存的结果 (本次没有检测)
+	Cached bool `json:"cached,omitempty" yaml:"cached,omitempty"`
+}
+
+// 一次重定向
+type RedirectHop struct {
+	StatusCode int    `json:"status_code" yaml:"status_code"`
+	From       string `json:"from" yaml:"from"`
+	To         string `json:"to" yaml:"to"`
+	// 是否跳转到了其他主机 (如CDN)
+	CrossHost bool `json:"cross_host" yaml:"cross_host"`
+}
+
+// 检测参数
+type checkOptions struct {
+	Timeout time.Duration
+	// 建立连接、TLS握手和等待响应头的超时时间，为0时使用Timeout
+	ConnectTimeout  time.Duration
+	TLSTimeout      time.Duration
+	ResponseTimeout time.Duration
+	Method          string // GET 或 HEAD
+	ProbePath       string // 探测路径，默认 /v2/
+	// 判断是否可用的规则
+	Criteria successCriteria
+	// 额外通过UDP探测HTTP/3 (QUIC) 支持
+	HTTP3 bool
+	// 额外在同一连接上再请求一次，测量复用连接时的响应时间
+	Warm bool
+	// 计时的探测之前先发送一次不计时的预热请求
+	Warmup bool
+	// 用于探测OCI能力的镜像，为空时不探测
+	OCIImage string
+	// 内容一致性比对的基准，为nil时不比对
+	Integrity *integrityReference
+	// 深度检测 (OCI能力和内容一致性) 的并发数，不大于0时使用defaultDeepWorkers
+	DeepWorkers int
+	// 失败后的最大重试次数
+	Retries int
+	// 分别检测域名解析出的每个IP
+	PerIP bool
+	// 外部探测插件的路径
+	Plugins []string
+	// 录制或回放网络交互，为nil时直接访问网络
+	Tape *tape
+	// 限制发出探测请求的速度，为nil时不限制
+	Limiter *rateLimiter
+	// 限制下载速度 (只用于深度检测) 和同时打开的连接数，为nil时不限制
+	Bandwidth *bandwidthLimiter
+	Conns     *connLimiter
+	// 本轮检测共用的DNS缓存，checkAll会在为nil时创建
+	DNS *dnsCache
+	// 较慢的探测发送对冲请求，为nil时不对冲
+	Hedge *hedger
+	// 检测顺序的优先级，为nil时按列表顺序检测
+	Priority jobPriority
+	// 连续连接失败的镜像源不再尝试，为nil时不熔断
+	Breaker *hostBreaker
+}
+
+// 定义worker池来处理检查任务
+//
+// gate不为nil时每次检测前需要取得许可，用于自适应并发。
+// deep不为nil时，通过快速检测的镜像源交给深度检测的worker继续检测，其余的直接输出结果。
+func worker(ctx context.Context, id int, jobs <-chan listEntry, results chan<- CheckResult, deep chan<- deepJob, client *http.Client, opts checkOptions, gate *adaptiveConcurrency, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for entry := range jobs {
+		gate.acquire()
+		// 已取消时不再处理剩余任务
+		if ctx.Err() != nil {
+			gate.release(nil)
+			return
+		}
+		result := checkHost(ctx, client, entry, opts)
+		// 被取消打断的检测没有完成，不计入结果
+		if ctx.Err() != nil {
+			gate.release(nil)
+			return
+		}
+		gate.release(&result)
+		if deep != nil && result.Available {
+			deep <- deepJob{entry: entry, result: result}
+			continue
+		}
+		results <- finishHost(ctx, entry, result, opts)
+	}
+}
+
+// 深度检测默认的并发数
+//
+// 深度检测要下载镜像清单和镜像层，比快速检测慢得多，也更占带宽，并发数通常应小于快速检测。
+const defaultDeepWorkers = 4
+
+// 等待深度检测的镜像源和它快速检测的结果
+type deepJob struct {
+	entry  listEntry
+	result CheckResult
+}
+
+// 深度检测的worker，与快速检测的worker数量分别设置
+//
+// 取消后继续读取剩余的任务 (不再检测)，避免快速检测的worker阻塞。
+func deepWorker(ctx context.Context, jobs <-chan deepJob, results chan<- CheckResult, client *http.Client, opts checkOptions, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for job := range jobs {
+		if ctx.Err() != nil {
+			continue
+		}
+		result := checkDeep(ctx, client, job.entry, job.result, opts)
+		if ctx.Err() != nil {
+			continue
+		}
+		results <- finishHost(ctx, job.entry, result, opts)
+	}
+}
+
+// 把快速检测的worker交来的深度检测任务暂存起来，按顺序交给深度检测的worker，in关闭且任务取完后关闭返回的通道
+//
+// 深度检测比快速检测慢得多，固定容量的通道很快就会填满，快速检测的worker随之阻塞，
+// 整轮检测退化为深度检测的速度；暂存的只有通过快速检测的镜像源。
+func deepQueue(in <-chan deepJob) <-chan deepJob {
+	out := make(chan deepJob)
+	go func() {
+		defer close(out)
+		var pending []deepJob
+		for in != nil || len(pending) > 0 {
+			// 没有暂存的任务时send为nil，select只等待新的任务
+			var send chan<- deepJob
+			var next deepJob
+			if len(pending) > 0 {
+				send, next = out, pending[0]
+			}
+			select {
+			case job, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				pending = append(pending, job)
+			case send <- next:
+				pending = pending[1:]
+			}
+		}
+	}()
+	return out
+}
+
+// 使用worker池检测所有host，每完成一个host调用一次progress
+// ctx取消后尚未开始和进行中的检测会被跳过，返回已完成的结果
+// numWorkers不大于0时根据超时和连接错误的比例自动调整并发数
+func checkAll(ctx context.Context, entries []listEntry, numWorkers int, opts checkOptions, progress func(done, total int)) []CheckResult {
+	allResults := make([]CheckResult, 0, len(entries))
+	checkEach(ctx, entries, numWorkers, opts, func(result CheckResult) {
+		allResults = append(allResults, result)
+		if progress != nil {
+			progress(len(allResults), len(entries))
+		}
+	})
+	return allResults
+}
+
+// 与checkAll相同，但不保存结果，每完成一个host按完成顺序调用一次handle (在调用者的goroutine中)
+//
+// 任务和结果通道的容量只与worker数量有关，检测几十万个镜像源时内存占用不会随列表增长
+// (需要深度检测时，等待深度检测的可用镜像源暂存在内存中)。
+func checkEach(ctx context.Context, entries []listEntry, numWorkers int, opts checkOptions, handle func(CheckResult)) {
+	var gate *adaptiveConcurrency
+	if numWorkers <= 0 {
+		gate = newAdaptiveConcurrency()
+		numWorkers = adaptiveMaxWorkers
+	}
+	if numWorkers > len(entries) {
+		numWorkers = len(entries)
+	}
+	if opts.DNS == nil {
+		opts.DNS = newDNSCache()
+	}
+	// 所有快速检测的worker共用一个transport；设置了 -max-bandwidth 时深度检测使用单独的限速transport
+	transport := newCheckTransport(opts, nil)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Timeout: opts.clientTimeout(), Transport: opts.Tape.wrap(transport, "")}
+	deepClient, deepTransport := client, transport
+	if opts.Bandwidth != nil && opts.deepChecks() {
+		deepTransport = newCheckTransport(opts, opts.Bandwidth)
+		defer deepTransport.CloseIdleConnections()
+		deepClient = &http.Client{Timeout: opts.clientTimeout(), Transport: opts.Tape.wrap(deepTransport, "")}
+	}
+	if opts.Conns != nil {
+		opts.Conns.closeIdle = func() {
+			transport.CloseIdleConnections()
+			deepTransport.CloseIdleConnections()
+		}
+	}
+
+	// 创建任务和结果通道
+	jobs := make(chan listEntry, numWorkers)
+	results := make(chan CheckResult, numWorkers)
+
+	// 启动worker池，需要深度检测时另外启动深度检测的worker池
+	var deep chan deepJob
+	var deepWG sync.WaitGroup
+	if opts.deepChecks() {
+		deepWorkers := opts.DeepWorkers
+		if deepWorkers <= 0 {
+			deepWorkers = defaultDeepWorkers
+		}
+		deep = make(chan deepJob)
+		pending := deepQueue(deep)
+		for i := 0; i < deepWorkers; i++ {
+			deepWG.Add(1)
+			go deepWorker(ctx, pending, results, deepClient, opts, &deepWG)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go worker(ctx, i, jobs, results, deep, client, opts, gate, &wg)
+	}
+
+	// 在后台按优先级逐个发送任务，已取消时不再发送剩余的任务
+	entries = opts.Priority.schedule(entries)
+	go func() {
+		defer close(jobs)
+		for _, entry := range entries {
+			select {
+			case jobs <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// 在后台等待所有worker完成并关闭results通道
+	go func() {
+		wg.Wait()
+		if deep != nil {
+			close(deep)
+			deepWG.Wait()
+		}
+		close(results)
+	}()
+
+	for result := range results {
+		handle(result)
+	}
+}
+
+// 镜像源的根地址和探测地址
+func (o checkOptions) probeURL(entry listEntry) (base, url string) {
+	probePath := o.ProbePath
+	if probePath == "" {
+		probePath = "/v2/"
+	}
+	base = "https://" + entry.Host
+	if entry.Insecure {
+		base = "http://" + entry.Host
+	}
+	return base, base + probePath
+}
+
+// 快速检测单个registry: 探测路径、上游、逐IP、复用连接和HTTP/3
+//
+// 深度检测和插件在checkDeep和finishHost中完成。
+func checkHost(ctx context.Context, client *http.Client, entry listEntry, opts checkOptions) CheckResult {
+	host := entry.Host
+	base, url := opts.probeURL(entry)
+
+	// 已熔断的镜像源 (如其他分类中的同一镜像源已连续失败) 直接跳过
+	if !opts.Breaker.allow(host) {
+		result := breakerResult(host)
+		result.Insecure = entry.Insecure
+		result.Region, result.Provider, result.Auth = entry.Region, entry.Provider, entry.Auth
+		if entry.Upstream != "" && entry.Upstream != defaultUpstream {
+			result.Upstream = entry.Upstream
+		}
+		return result
+	}
+
+	if opts.Warmup && opts.Limiter.wait(ctx) {
+		warmup(ctx, client, url)
+	}
+
+	// 网络错误、超时和5xx视为临时故障，按指数退避重试
+	var result CheckResult
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		// 等待的时间不计入响应时间
+		opts.Limiter.wait(ctx)
+		result, resp = probeHedged(ctx, client, host, url, opts)
+		result.Attempts = attempt
+		result.Insecure = entry.Insecure
+		if resp != nil {
+			result.Available = opts.Criteria.accept(resp, url)
+		}
+		// 被取消打断的探测不能说明镜像源的状态
+		if ctx.Err() == nil {
+			opts.Breaker.record(host, result)
+		}
+
+		if attempt > opts.Retries || !shouldRetry(result) || !opts.Breaker.retry(host) {
+			break
+		}
+		if !sleepContext(ctx, backoff(attempt)) {
+			break
+		}
+	}
+
+	// 即使检测失败也记录地址族，失败可能正是因为本机缺少该地址族
+	result.Family, _ = opts.Tape.call("family "+host, func() (string, error) {
+		return lookupFamily(ctx, opts.DNS, host), nil
+	})
+
+	result.Region, result.Provider, result.Auth = entry.Region, entry.Provider, entry.Auth
+
+	// 非Docker Hub的镜像源还需要能拉取到对应上游的镜像
+	if entry.Upstream != "" && entry.Upstream != defaultUpstream {
+		result.Upstream = entry.Upstream
+		if result.Available && opts.Limiter.wait(ctx) {
+			if err := probeUpstream(ctx, client, base, upstreamProbeImages[entry.Upstream]); err != nil {
+				result.Available = false
+				result.Error = err.Error()
+			}
+		}
+	}
+
+	// 即使整体检测失败，也可能只是其中某个IP故障
+	if opts.PerIP {
+		result.IPs = checkPerIP(ctx, host, url, opts)
+	}
+
+	if result.StatusCode == 0 {
+		return result
+	}
+
+	if opts.Warm && opts.Limiter.wait(ctx) {
+		result.WarmTime = measureWarm(ctx, client, result.Method, url)
+	}
+
+	// HTTP镜像源不支持QUIC
+	if opts.HTTP3 && !entry.Insecure {
+		quic, _ := opts.Tape.call("quic "+host, func() (string, error) {
+			ok, err := probeQUIC(host, opts.Timeout)
+			return strconv.FormatBool(ok), err
+		})
+		result.QUIC = quic == "true"
+	}
+
+	return result
+}
+
+// 是否需要深度检测 (拉取镜像清单和镜像层)
+func (o checkOptions) deepChecks() bool {
+	return o.OCIImage != "" || o.Integrity != nil
+}
+
+// 深度检测通过快速检测的镜像源: OCI能力和内容一致性
+func checkDeep(ctx context.Context, client *http.Client, entry listEntry, result CheckResult, opts checkOptions) CheckResult {
+	base, _ := opts.probeURL(entry)
+
+	if opts.OCIImage != "" && result.Available && opts.Limiter.wait(ctx) {
+		result.OCI = probeOCI(ctx, client, base, opts.OCIImage)
+	}
+
+	// 内容与Docker Hub不一致的镜像源视为不可用，可能被篡改或缓存损坏
+	if opts.Integrity != nil && result.Available && result.Upstream == "" && opts.Limiter.wait(ctx) {
+		result.Integrity = probeIntegrity(ctx, client, base, opts.Integrity)
+		if result.Integrity.Compared && !result.Integrity.Match {
+			result.Available = false
+			result.Error = result.Integrity.Error
+		}
+	}
+	return result
+}
+
+// 运行插件并计算评分，没有收到响应的镜像源原样返回
+func finishHost(ctx context.Context, entry listEntry, result CheckResult, opts checkOptions) CheckResult {
+	if result.StatusCode == 0 {
+		return result
+	}
+	if len(opts.Plugins) > 0 {
+		_, url := opts.probeURL(entry)
+		result.Plugins = runPlugins(ctx, opts.Plugins, url, result, opts)
+	}
+	result.Score = scoreResult(result)
+	return result
+}
+
+// 发送一次探测请求，请求失败时返回的resp为nil
+func probeHost(ctx context.Context, client *http.Client, host, url string, opts checkOptions) (CheckResult, *http.Response) {
+	start := time.Now()
+	result := CheckResult{
+		Host: host,
+	}
+
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// 记录实际连接的IP地址、首字节时间和请求进行到的阶段 (有重定向时以最后一个响应为准)
+	var phase phaseTracker
+	traceCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			phase.set(timeoutConnect)
+		},
+		TLSHandshakeStart: func() {
+			phase.set(timeoutTLS)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			phase.set(timeoutResponse)
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				result.IP = addr.IP.String()
+			}
+		},
+		GotFirstResponseByte: func() {
+			result.TTFB = time.Since(start)
+		},
+	})
+
+	resp, err := probe(traceCtx, client, method, url)
+	// 部分镜像源不支持对/v2/发送HEAD请求，此时回退为GET
+	if err == nil && method == http.MethodHead && headRejected(resp.StatusCode) {
+		resp.Body.Close()
+		method = http.MethodGet
+		start = time.Now()
+		resp, err = probe(traceCtx, client, method, url)
+	}
+	result.Method = method
+
+	if err != nil {
+		result.Available = false
+		result.Error = err.Error()
+		if os.IsTimeout(err) || strings.Contains(err.Error(), "timeout") {
+			result.IsTimeout = true
+			result.TimeoutPhase = phase.get()
+		}
+		return result, nil
+	}
+	result.StatusCode = resp.StatusCode
+	// 读完响应体才能让连接回到连接池中复用
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	result.Time = time.Since(start)
+
+	result.Redirects = redirectChain(resp)
+	result.TLSVerified = verifyTLS(resp.TLS, host)
+	result.Protocol = resp.Proto
+	result.H3Advertised = strings.Contains(resp.Header.Get("Alt-Svc"), "h3")
+
+	return result, resp
+}
+
+// 从最终响应向前回溯，得到完整的重定向链
+func redirectChain(resp *http.Response) []RedirectHop {
+	var hops []RedirectHop
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		prev := req.Response
+		hops = append([]RedirectHop{{
+			StatusCode: prev.StatusCode,
+			From:       prev.Request.URL.String(),
+			To:         req.URL.String(),
+			CrossHost:  prev.Request.URL.Host != req.URL.Host,
+		}}, hops...)
+	}
+	return hops
+}
+
+// 判断是否是值得重试的临时故障
+func shouldRetry(result CheckResult) bool {
+	return result.StatusCode == 0 || result.StatusCode >= 500
+}
+
+// 第attempt次失败后的等待时间: 500ms起按2倍递增，上限5s，并加入±50%的随机抖动
+func backoff(attempt int) time.Duration {
+	d := 500 * time.Millisecond << (attempt - 1)
+	if d > 5*time.Second || d <= 0 {
+		d = 5 * time.Second
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// 等待指定时间，ctx被取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 在已建立的连接上再次请求，返回响应时间；没能复用连接时返回0
+func measureWarm(ctx context.Context, client *http.Client, method, url string) time.Duration {
+	reused := false
+	traceCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	})
+
+	start := time.Now()
+	resp, err := probe(traceCtx, client, method, url)
+	if err != nil {
+		return 0
+	}
+	elapsed := time.Since(start)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if !reused {
+		return 0
+	}
+	return elapsed
+}
+
+// 预热: 发送一次不计时的请求，让DNS缓存和TLS会话缓存就绪
+//
+// 预热请求的连接用完即关闭，之后计时的探测仍然新建连接，只是省去了DNS查询和完整的TLS握手，
+// 各镜像源的响应时间不再受本机是否缓存过DNS、首次握手快慢等因素的影响。失败时直接忽略，由计时的探测报告。
+func warmup(ctx context.Context, client *http.Client, url string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	req.Close = true
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func probe(ctx context.Context, client *http.Client, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// 判断服务端是否拒绝了HEAD请求
+func headRejected(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusBadRequest, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// 使用系统根证书校验服务端证书链和主机名
+func verifyTLS(state *tls.ConnectionState, host string) bool {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return false
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err == nil
+}
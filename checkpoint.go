@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 默认的检查点文件，保存在当前目录
+const defaultCheckpointPath = ".docker-registry-checker.checkpoint"
+
+// 检查点: 检测过程中每完成一个镜像源就把结果追加到文件中 (第一行为checkpointHeader，之后每行一个JSON对象)
+//
+// 检测被中断、超过 -max-duration 或程序崩溃时文件会保留下来，之后使用 -resume 只检测还没有结果的镜像源；
+// 全部检测完成后删除。nil表示不保存检查点。
+type checkpoint struct {
+	path string
+	file *os.File
+	// 写入失败后不再写入，检测照常进行
+	err error
+}
+
+// 创建检查点文件 (已有时覆盖，不使用 -resume 时应先调用rotateCheckpoint)，先写入从上一个检查点恢复的结果，使多次中断后仍能继续
+func createCheckpoint(path string, header checkpointHeader, resumed []CheckResult) (*checkpoint, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建检查点文件失败: %v", err)
+	}
+	c := &checkpoint{path: path, file: file}
+	c.writeLine(header)
+	for _, result := range resumed {
+		c.add(result)
+	}
+	if c.err != nil {
+		c.remove()
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// 检查点文件的第一行，记录影响检测结果的参数和列表来源，-resume 时必须与本次检测一致
+type checkpointHeader struct {
+	Options string   `json:"options"`
+	Sources []string `json:"sources"`
+}
+
+func newCheckpointHeader(opts checkOptions, entries []listEntry) checkpointHeader {
+	var sources []string
+	for _, entry := range entries {
+		if !containsString(sources, entry.Source) {
+			sources = append(sources, entry.Source)
+		}
+	}
+	sort.Strings(sources)
+	return checkpointHeader{Options: opts.cacheOptions(), Sources: sources}
+}
+
+// 检查点与本次检测的参数或列表不一致时返回错误
+func (h checkpointHeader) match(current checkpointHeader) error {
+	if h.Options != current.Options {
+		return fmt.Errorf("检查点的检测参数与本次不同:\n  检查点: %s\n  本次:   %s", h.Options, current.Options)
+	}
+	if strings.Join(h.Sources, "\n") != strings.Join(current.Sources, "\n") {
+		return fmt.Errorf("检查点的列表与本次不同:\n  检查点: %s\n  本次:   %s", strings.Join(h.Sources, ", "), strings.Join(current.Sources, ", "))
+	}
+	return nil
+}
+
+// 不使用 -resume 时把已有的检查点 (上次没有完成的检测) 移动到 path.old，避免被新的检查点覆盖
+//
+// 返回移动后的路径，没有已有的检查点时返回空。
+func rotateCheckpoint(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", nil
+	}
+	old := path + ".old"
+	if err := os.Rename(path, old); err != nil {
+		return "", fmt.Errorf("移动已有的检查点文件失败: %v", err)
+	}
+	return old, nil
+}
+
+// 追加一个检测结果
+//
+// 每个结果直接写入文件而不经过缓冲，程序崩溃时最多丢失正在写入的一行。
+func (c *checkpoint) add(result CheckResult) {
+	if c != nil {
+		c.writeLine(result)
+	}
+}
+
+func (c *checkpoint) writeLine(v interface{}) {
+	if c.err != nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		c.err = fmt.Errorf("写入检查点文件失败: %v", err)
+	}
+}
+
+func (c *checkpoint) close() {
+	if c != nil {
+		c.file.Close()
+	}
+}
+
+// 检测全部完成，不再需要检查点
+func (c *checkpoint) remove() {
+	if c != nil {
+		c.file.Close()
+		os.Remove(c.path)
+	}
+}
+
+// 读取检查点的参数和已完成的结果
+//
+// 程序崩溃时最后一行可能只写了一半，无法解析的行直接跳过。
+func loadCheckpoint(path string) (checkpointHeader, []CheckResult, error) {
+	var header checkpointHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return header, nil, fmt.Errorf("读取检查点文件失败: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// 带有重定向链、逐IP结果的行可能超过默认的64KB
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || header.Options == "" {
+		if err := scanner.Err(); err != nil {
+			return header, nil, fmt.Errorf("读取检查点文件失败: %v", err)
+		}
+		return header, nil, fmt.Errorf("%s 不是有效的检查点文件 (缺少检测参数)", path)
+	}
+
+	var results []CheckResult
+	for scanner.Scan() {
+		var result CheckResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, fmt.Errorf("读取检查点文件失败: %v", err)
+	}
+	return header, results, nil
+}
+
+// 从检查点恢复: 返回检查点中属于本次检测列表的结果，以及还没有结果的镜像源
+//
+// 同一镜像源按不同上游检测时是不同的条目，与dedupeEntries一致。
+func resumeEntries(entries []listEntry, checkpointed []CheckResult) (done []CheckResult, remaining []listEntry) {
+	results := map[string]CheckResult{}
+	for _, result := range checkpointed {
+		results[checkpointKey(result.Host, result.Upstream)] = result
+	}
+	for _, entry := range entries {
+		key := checkpointKey(entry.Host, entry.Upstream)
+		if result, ok := results[key]; ok {
+			done = append(done, result)
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	return done, remaining
+}
+
+// 检测结果中Docker Hub上游的Upstream为空，列表条目中为docker.io
+func checkpointKey(host, upstream string) string {
+	if upstream == defaultUpstream {
+		upstream = ""
+	}
+	return host + "\x00" + upstream
+}
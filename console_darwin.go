@@ -0,0 +1,31 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// 判断是否是在Finder中双击启动的
+//
+// Finder会打开终端并在用户主目录下执行程序，而在终端中手动运行时
+// 通常位于程序所在目录，这里据此判断。
+func launchedByDoubleClick() bool {
+	if len(os.Args) > 1 || !stdinIsTerminal() {
+		return false
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	home, _ := os.UserHomeDir()
+	cwd, _ := os.Getwd()
+	return cwd == home && filepath.Dir(exe) != cwd
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
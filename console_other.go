@@ -0,0 +1,8 @@
+//go:build !windows && !darwin
+
+package main
+
+// 其他系统通常在终端中运行，不显示菜单
+func launchedByDoubleClick() bool {
+	return false
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetConsoleProcessList = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleProcessList")
+
+// 判断是否是在资源管理器中双击启动的
+//
+// 双击启动时系统会为程序单独创建控制台，控制台上只有当前进程；
+// 在cmd或PowerShell中运行时控制台上还有shell进程。
+func launchedByDoubleClick() bool {
+	if len(os.Args) > 1 {
+		return false
+	}
+
+	pids := make([]uint32, 4)
+	n, _, _ := procGetConsoleProcessList.Call(uintptr(unsafe.Pointer(&pids[0])), uintptr(len(pids)))
+	return n == 1
+}
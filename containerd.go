@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// containerd 读取镜像仓库配置的目录 (config_path)
+const containerdCertsDir = "/etc/containerd/certs.d"
+
+// containerd 的主配置文件，旧版本需要在其中设置 config_path 才会读取 hosts.toml
+const containerdConfigPath = "/etc/containerd/config.toml"
+
+// 不运行dockerd的Kubernetes节点和nerdctl用户，镜像源写入 certs.d/docker.io/hosts.toml
+//
+// containerd每次拉取时都会重新读取hosts.toml，写入后不需要重启即可生效。
+var containerdTarget = dockerTarget{
+	Name:       "containerd",
+	ConfigPath: containerdCertsDir + "/docker.io/hosts.toml",
+	Format:     configHostsTOML,
+	Binary:     "containerd",
+	Restart:    "systemctl restart containerd",
+	NeedRoot:   true,
+}
+
+// 读取hosts.toml中的 [host."..."] 作为镜像源
+func readHostsTOML(path string) (*DaemonConfig, error) {
+	config := &DaemonConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取hosts.toml失败: %v", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		host, rest, ok := hostsTOMLTable(line)
+		if !ok {
+			if strings.HasPrefix(line, "[host.") {
+				return nil, fmt.Errorf("解析hosts.toml失败: %s", line)
+			}
+			continue
+		}
+		// [host."...".header] 等子表属于同一个镜像源
+		if strings.HasPrefix(rest, "]") {
+			config.RegistryMirrors = append(config.RegistryMirrors, host)
+		}
+	}
+	return config, scanner.Err()
+}
+
+// 解析 [host."..."] 及其子表 (如 [host."...".header]) 的表头，返回镜像源地址和地址之后的部分
+func hostsTOMLTable(line string) (host, rest string, ok bool) {
+	if !strings.HasPrefix(line, "[host.") {
+		return "", "", false
+	}
+	quoted, err := strconv.QuotedPrefix(strings.TrimPrefix(line, "[host."))
+	if err != nil {
+		return "", "", false
+	}
+	host, _ = strconv.Unquote(quoted)
+	rest = strings.TrimPrefix(line, "[host."+quoted)
+	if !strings.HasPrefix(rest, "]") && !strings.HasPrefix(rest, ".") {
+		return "", "", false
+	}
+	return host, rest, true
+}
+
+// 读取已有的hosts.toml并更新其中的镜像源，文件不存在时生成新的
+func renderHostsTOMLFile(path string, config *DaemonConfig) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取hosts.toml失败: %v", err)
+	}
+	return renderHostsTOML(data, config), nil
+}
+
+// 生成docker.io的hosts.toml，镜像源按顺序尝试，都不可用时回退到Docker Hub
+//
+// existing为已有的内容时只修改 [host."..."] 表: 去掉不再使用的镜像源，新的镜像源使用默认的capabilities，
+// 仍在使用的镜像源保留原有的 ca、skip_verify、client、[host."...".header] 等配置，按新的顺序排列；
+// server、顶层的 ca、[header] 等其他配置原样保留在镜像源之前。
+func renderHostsTOML(existing []byte, config *DaemonConfig) []byte {
+	var prelude []string
+	hosts := map[string][]string{}
+	if len(bytes.TrimSpace(existing)) == 0 {
+		prelude = []string{"# 由 docker-registry-checker 生成", `server = "https://registry-1.docker.io"`}
+	} else {
+		current := ""
+		inHost := false
+		for _, line := range splitLines(string(existing)) {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") {
+				var host string
+				host, _, inHost = hostsTOMLTable(trimmed)
+				current = host
+			}
+			if inHost {
+				hosts[current] = append(hosts[current], line)
+			} else {
+				prelude = append(prelude, line)
+			}
+		}
+	}
+
+	out := trimBlankLines(prelude)
+	for _, mirror := range config.RegistryMirrors {
+		block := trimBlankLines(hosts[mirror])
+		if len(block) == 0 {
+			block = []string{fmt.Sprintf("[host.%q]", mirror), `  capabilities = ["pull", "resolve"]`}
+		}
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, block...)
+	}
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// 去掉末尾的空行
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// 检查containerd是否会读取certs.d，旧版本 (1.x) 需要在config.toml中设置config_path
+func checkContainerdConfigPath() {
+	data, err := os.ReadFile(containerdConfigPath)
+	if err != nil || bytes.Contains(data, []byte("config_path")) {
+		return
+	}
+	fmt.Printf("\n注意: %s 中没有设置 config_path，containerd 1.x 不会读取 hosts.toml，请加入以下配置后重启containerd:\n", containerdConfigPath)
+	fmt.Println(`  [plugins."io.containerd.grpc.v1.cri".registry]`)
+	fmt.Printf("    config_path = %q\n", containerdCertsDir)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHostsTOML(t *testing.T) {
+	existing := `server = "https://registry-1.docker.io"
+ca = "/etc/containerd/certs.d/docker.io/ca.crt"
+
+[header]
+  x-custom = "1"
+
+[host."https://old.example.com"]
+  capabilities = ["pull", "resolve"]
+
+[host."https://keep.example.com"]
+  capabilities = ["pull", "resolve"]
+  skip_verify = true
+  client = [["/etc/certs/client.crt", "/etc/certs/client.key"]]
+
+[host."https://keep.example.com".header]
+  authorization = "Basic xxx"
+`
+	path := filepath.Join(t.TempDir(), "hosts.toml")
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readHostsTOML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://old.example.com", "https://keep.example.com"}; !reflect.DeepEqual(config.RegistryMirrors, want) {
+		t.Fatalf("mirrors = %v，期望 %v", config.RegistryMirrors, want)
+	}
+
+	config.RegistryMirrors = []string{"https://new.example.com", "https://keep.example.com"}
+	data, err := renderHostsTOMLFile(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if strings.Contains(out, "old.example.com") {
+		t.Errorf("不再使用的镜像源没有去掉:\n%s", out)
+	}
+	for _, kept := range []string{
+		`server = "https://registry-1.docker.io"`,
+		`ca = "/etc/containerd/certs.d/docker.io/ca.crt"`,
+		"[header]\n  x-custom = \"1\"",
+		"  skip_verify = true\n  client = [[",
+		"[host.\"https://keep.example.com\".header]\n  authorization = \"Basic xxx\"",
+	} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("其他配置丢失 (%s):\n%s", kept, out)
+		}
+	}
+	// 镜像源按新的顺序排列
+	if strings.Index(out, `[host."https://new.example.com"]`) > strings.Index(out, `[host."https://keep.example.com"]`) {
+		t.Errorf("镜像源的顺序不正确:\n%s", out)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = readHostsTOML(path); err != nil || !reflect.DeepEqual(config.RegistryMirrors, []string{"https://new.example.com", "https://keep.example.com"}) {
+		t.Errorf("重新读取: %v, %v", config, err)
+	}
+}
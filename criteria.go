@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 状态码范围 (闭区间)
+type statusRange struct {
+	Min, Max int
+}
+
+// 默认视为可用的状态码: 2xx/3xx/401
+var defaultStatus = []statusRange{{200, 399}, {401, 401}}
+
+// 判断registry是否可用的规则
+type successCriteria struct {
+	// 视为可用的状态码，为空时使用默认规则
+	Status []statusRange
+	// 被重定向且最终地址匹配该表达式时视为不可用，如跳转到登录页
+	RejectRedirect *regexp.Regexp
+}
+
+// 根据命令行参数或配置文件构造判定规则
+func newSuccessCriteria(status, rejectRedirect string) (successCriteria, error) {
+	var criteria successCriteria
+
+	ranges, err := parseStatusList(status)
+	if err != nil {
+		return criteria, err
+	}
+	criteria.Status = ranges
+
+	if rejectRedirect != "" {
+		re, err := regexp.Compile(rejectRedirect)
+		if err != nil {
+			return criteria, fmt.Errorf("无效的重定向匹配规则: %v", err)
+		}
+		criteria.RejectRedirect = re
+	}
+	return criteria, nil
+}
+
+// 判断响应是否表示registry可用，requestURL为最初请求的地址
+func (c successCriteria) accept(resp *http.Response, requestURL string) bool {
+	if !statusAccepted(resp.StatusCode, c.Status) {
+		return false
+	}
+
+	if c.RejectRedirect != nil && resp.Request != nil {
+		finalURL := resp.Request.URL.String()
+		if finalURL != requestURL && c.RejectRedirect.MatchString(finalURL) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断状态码是否在允许的范围内
+func statusAccepted(statusCode int, expect []statusRange) bool {
+	if len(expect) == 0 {
+		expect = defaultStatus
+	}
+	for _, r := range expect {
+		if statusCode >= r.Min && statusCode <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析逗号分隔的状态码列表，支持单个状态码、类别和范围，如 "2xx,401,403" 或 "200-299"
+func parseStatusList(value string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+
+		r, err := parseStatusRange(field)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseStatusRange(field string) (statusRange, error) {
+	invalid := fmt.Errorf("无效的状态码: %q", field)
+
+	// 类别，如 2xx
+	if len(field) == 3 && strings.HasSuffix(field, "xx") {
+		class, err := strconv.Atoi(field[:1])
+		if err != nil || class < 1 || class > 5 {
+			return statusRange{}, invalid
+		}
+		return statusRange{class * 100, class*100 + 99}, nil
+	}
+
+	// 范围，如 200-299
+	if lo, hi, ok := strings.Cut(field, "-"); ok {
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || !validStatus(min) || !validStatus(max) || min > max {
+			return statusRange{}, invalid
+		}
+		return statusRange{min, max}, nil
+	}
+
+	code, err := strconv.Atoi(field)
+	if err != nil || !validStatus(code) {
+		return statusRange{}, invalid
+	}
+	return statusRange{code, code}, nil
+}
+
+func validStatus(code int) bool {
+	return code >= 100 && code <= 599
+}
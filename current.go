@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 读取当前已配置的镜像源，用于 -current
+//
+// 同时读取配置文件和dockerd实际生效的镜像源 (Engine API的 /info)，两者不一致时
+// 通常是修改配置后还没有重启Docker，都会被检测，并通过来源区分。
+func currentMirrorEntries(target dockerTarget) ([]listEntry, error) {
+	var entries []listEntry
+	add := func(mirrors []string, source string) {
+		for i, mirror := range mirrors {
+			entries = append(entries, listEntry{Host: mirrorHost(mirror), Insecure: strings.HasPrefix(mirror, "http://"), Upstream: defaultUpstream, Source: source, Line: i + 1})
+		}
+	}
+
+	config, configErr := target.readConfig()
+	if configErr == nil {
+		add(config.RegistryMirrors, target.ConfigPath)
+	}
+
+	if target.isDockerd() {
+		if info, err := dockerdInfo(context.Background()); err == nil {
+			var configured []string
+			if config != nil {
+				configured = config.RegistryMirrors
+			}
+			if !sameMirrors(configured, info.RegistryConfig.Mirrors) {
+				fmt.Fprintf(infoOut, "%s 中的镜像源与Docker当前生效的不一致，可能修改配置后还没有重启Docker\n", target.ConfigPath)
+				add(info.RegistryConfig.Mirrors, "docker info")
+			}
+		} else if configErr != nil {
+			return nil, configErr
+		}
+	} else if configErr != nil {
+		return nil, configErr
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s当前没有配置镜像源", target.Name)
+	}
+	return entries, nil
+}
+
+// 比较两组镜像源的host，忽略协议和结尾的 /
+func sameMirrors(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if mirrorHost(a[i]) != mirrorHost(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 输出当前配置的健康状况
+func writeCurrentSummary(w io.Writer, results []CheckResult) {
+	var failed []string
+	for _, result := range results {
+		if !isSuccess(result) {
+			failed = append(failed, result.Host)
+		}
+	}
+
+	fmt.Fprintf(w, "\n当前配置的 %d 个镜像源中 %d 个可用\n", len(results), len(results)-len(failed))
+	switch {
+	case len(failed) == len(results):
+		fmt.Fprintln(w, "所有镜像源都不可用，拉取镜像会回退到Docker Hub，去掉 -current 重新检测并配置可用的镜像源")
+	case len(failed) > 0:
+		fmt.Fprintln(w, "以下镜像源不可用，Docker会在等待其超时后才尝试下一个，拉取可能因此变慢:")
+		for _, host := range failed {
+			fmt.Fprintln(w, "  "+host)
+		}
+	default:
+		fmt.Fprintln(w, "当前配置正常")
+	}
+}
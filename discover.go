@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// 列表文件所在的GitHub仓库，discover -pr 向该仓库提交PR
+const githubListRepo = "YMingPro/docker-register-check"
+
+// GitHub API地址
+const githubAPIBase = "https://api.github.com"
+
+// discover 子命令：深度检测候选的镜像源，把通过检测且列表中还没有的镜像源整理成列表文件的补丁或PR
+//
+// 深度检测除了 /v2/ 可访问，还要求能拉取到上游的镜像清单；Docker Hub的镜像源还需要通过内容一致性比对。
+func runDiscover(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	candidates := fs.String("candidates", "", "候选镜像源列表文件，格式与docker.txt相同，- 表示从标准输入读取")
+	category := fs.String("category", "", "候选镜像源所属的分类，决定补丁修改的列表文件和检测的上游 (默认: docker-hub)")
+	timeout := fs.Duration("timeout", 15*time.Second, "每个镜像源的超时时间")
+	workers := fs.Int("workers", 8, "并发数")
+	ociImage := fs.String("oci-image", "library/alpine:latest", "Docker Hub镜像源需要能拉取到清单的镜像")
+	integrityImage := fs.String("integrity-image", "library/hello-world:latest", "Docker Hub镜像源内容比对使用的镜像")
+	githubProxy := fs.String("github-proxy", "", "下载最新的列表文件时依次尝试的方式，与检测时的 -github-proxy 相同")
+	output := fs.String("o", "", "补丁写入的文件，默认输出到标准输出")
+	pr := fs.Bool("pr", false, "不输出补丁，直接通过GitHub API向列表仓库提交PR (需要 -token 或环境变量 GITHUB_TOKEN)")
+	token := fs.String("token", "", "提交PR使用的GitHub token，需要 public_repo 权限，默认读取环境变量 GITHUB_TOKEN")
+	yes := fs.Bool("y", false, "提交PR前不再确认")
+	answersPath := fs.String("answers", "", "从YAML应答文件读取提交PR前的确认 (discover)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker discover -candidates <文件> [参数]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *candidates == "" {
+		fs.Usage()
+		return fmt.Errorf("请通过 -candidates 指定候选镜像源列表")
+	}
+	if err := useAnswersFile(*answersPath); err != nil {
+		return err
+	}
+	// token不作为参数的默认值，以免在 -h 中显示
+	if *token == "" {
+		*token = os.Getenv("GITHUB_TOKEN")
+	}
+	if *pr && *token == "" {
+		return fmt.Errorf("提交PR需要通过 -token 或环境变量 GITHUB_TOKEN 指定GitHub token")
+	}
+	categories, err := parseCategories(*category)
+	if err != nil {
+		return err
+	}
+	if len(categories) != 1 {
+		return fmt.Errorf("-category 只能指定一个分类")
+	}
+	target := categories[0]
+	proxies, err := parseGithubProxies(*githubProxy)
+	if err != nil {
+		return err
+	}
+	// 标准输出只输出补丁，便于重定向到文件
+	infoOut = os.Stderr
+
+	entries, err := readCandidates(*candidates, target)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("候选列表中没有有效的主机地址")
+	}
+
+	base, baseSource := fetchLatestList(target, proxies)
+	known := map[string]bool{}
+	if existing, err := newCategoryParser(target).parse(base, baseSource); err == nil {
+		for _, entry := range existing {
+			known[entry.Host] = true
+		}
+	}
+	var fresh []listEntry
+	for _, entry := range dedupeEntries(entries, infoOut) {
+		if known[entry.Host] {
+			fmt.Fprintf(infoOut, "%s 已在%s中，跳过\n", entry.Host, target.File)
+			continue
+		}
+		fresh = append(fresh, entry)
+	}
+	if len(fresh) == 0 {
+		fmt.Fprintln(infoOut, "所有候选镜像源都已在列表中")
+		return nil
+	}
+
+	fmt.Fprintf(infoOut, "正在深度检测 %d 个候选镜像源 (上游: %s)...\n", len(fresh), target.Upstream)
+	passed, err := deepCheckCandidates(fresh, target, *timeout, *workers, *ociImage, *integrityImage)
+	if err != nil {
+		return err
+	}
+	if len(passed) == 0 {
+		return fmt.Errorf("没有候选镜像源通过深度检测")
+	}
+
+	var lines []string
+	for _, entry := range passed {
+		lines = append(lines, formatListLine(entry, target.Upstream))
+	}
+	if *pr {
+		if !*yes && !confirm("discover", fmt.Sprintf("将向 %s 提交PR，在%s中添加 %d 个镜像源: %s。是否继续? (y/n): ",
+			githubListRepo, target.File, len(lines), strings.Join(lines, ", "))) {
+			return fmt.Errorf("已取消")
+		}
+		api := &githubAPI{client: &http.Client{Timeout: 30 * time.Second}, token: *token}
+		link, err := api.openListPR(target.File, lines)
+		if err != nil {
+			return err
+		}
+		fmt.Println(link)
+		return nil
+	}
+
+	patch := listPatch(target.File, base, lines)
+	if *output == "" {
+		_, err := io.WriteString(os.Stdout, patch)
+		return err
+	}
+	if err := atomicWriteFile(*output, []byte(patch), nil); err != nil {
+		return fmt.Errorf("写入补丁失败: %v", err)
+	}
+	fmt.Fprintf(infoOut, "补丁已写入 %s，可以在仓库中用 git apply %s 应用\n", *output, *output)
+	return nil
+}
+
+// 读取候选镜像源，path为 - 时从标准输入读取
+func readCandidates(path string, category listCategory) ([]listEntry, error) {
+	p := newCategoryParser(category)
+	if path != "-" {
+		return p.parseFile(path)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("读取标准输入失败: %v", err)
+	}
+	return p.parse(data, "stdin")
+}
+
+// 取得最新的列表文件作为补丁的基准: 依次尝试从GitHub下载、本地文件和内置列表
+func fetchLatestList(category listCategory, proxies []string) ([]byte, string) {
+	client := &http.Client{Timeout: githubDownloadTimeout}
+	for _, proxy := range proxies {
+		url := githubProxyURL(proxy, category.File)
+		data, _, err := downloadList(client, url, nil)
+		if err == nil {
+			return data, url
+		}
+		fmt.Fprintf(infoOut, "通过 %s 下载%s失败: %v\n", proxy, category.File, err)
+	}
+	if data, err := os.ReadFile(category.File); err == nil {
+		fmt.Fprintf(infoOut, "无法从GitHub下载，以本地的%s为基准生成补丁\n", category.File)
+		return data, category.File
+	}
+	fmt.Fprintf(infoOut, "无法从GitHub下载，以内置的%s为基准生成补丁\n", category.File)
+	data, _ := builtinLists.ReadFile(category.File)
+	return data, builtinListSource
+}
+
+// 深度检测候选镜像源，返回通过检测的条目，顺序与候选列表相同
+func deepCheckCandidates(entries []listEntry, category listCategory, timeout time.Duration, workers int, ociImage, integrityImage string) ([]listEntry, error) {
+	criteria, _ := newSuccessCriteria("", "")
+	opts := checkOptions{Timeout: timeout, Method: "GET", ProbePath: "/v2/", Criteria: criteria, Retries: 1}
+	if category.Upstream == defaultUpstream {
+		opts.OCIImage = ociImage
+		client := &http.Client{Timeout: timeout}
+		reference, err := fetchIntegrityReference(context.Background(), client, integrityImage)
+		if err != nil {
+			return nil, fmt.Errorf("无法从Docker Hub取得内容比对的基准: %v", err)
+		}
+		opts.Integrity = reference
+	}
+	results := checkAll(context.Background(), entries, workers, opts, nil)
+
+	byHost := map[string]CheckResult{}
+	for _, result := range results {
+		byHost[result.Host] = result
+	}
+	var passed []listEntry
+	for _, entry := range entries {
+		result := byHost[entry.Host]
+		if reason := deepCheckFailure(result, entry); reason != "" {
+			fmt.Fprintf(infoOut, "✗ %s: %s\n", entry.Host, reason)
+			continue
+		}
+		fmt.Fprintf(infoOut, "✓ %s (%.2fs)\n", entry.Host, result.Time.Seconds())
+		passed = append(passed, entry)
+	}
+	return passed, nil
+}
+
+// 深度检测没有通过的原因，通过时返回空字符串
+func deepCheckFailure(result CheckResult, entry listEntry) string {
+	if !isSuccess(result) {
+		if result.Error != "" {
+			return result.Error
+		}
+		return fmt.Sprintf("状态码: %d", result.StatusCode)
+	}
+	// 非Docker Hub的镜像源在检测时已经拉取过上游的清单
+	if entry.Upstream != defaultUpstream {
+		return ""
+	}
+	if result.OCI == nil || result.OCI.Error != "" {
+		if result.OCI != nil {
+			return "无法拉取镜像清单: " + result.OCI.Error
+		}
+		return "无法拉取镜像清单"
+	}
+	if result.Integrity == nil || !result.Integrity.Compared {
+		if result.Integrity != nil && result.Integrity.Error != "" {
+			return "无法完成内容比对: " + result.Integrity.Error
+		}
+		return "无法完成内容比对"
+	}
+	return ""
+}
+
+// 按列表文件的格式输出一个条目，与分类上游相同的 upstream= 标注省略
+func formatListLine(entry listEntry, upstream string) string {
+	fields := []string{entry.Host}
+	if entry.Insecure {
+		fields[0] = "http://" + entry.Host
+	}
+	if entry.Upstream != upstream {
+		fields = append(fields, "upstream="+entry.Upstream)
+	}
+	for _, annotation := range []struct{ key, value string }{
+		{"region", entry.Region},
+		{"provider", entry.Provider},
+		{"auth", entry.Auth},
+	} {
+		if annotation.value != "" {
+			fields = append(fields, annotation.key+"="+annotation.value)
+		}
+	}
+	line := strings.Join(fields, " ")
+	if entry.Comment != "" {
+		line += "  # " + entry.Comment
+	}
+	return line
+}
+
+// 在列表末尾追加lines后的内容，原内容末尾没有换行时补上
+func appendListLines(base []byte, lines []string) []byte {
+	content := append([]byte{}, base...)
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	for _, line := range lines {
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+	return content
+}
+
+// 生成在列表文件name末尾追加lines的补丁 (unified diff)，可以在仓库中用 git apply 应用
+func listPatch(name string, base []byte, lines []string) string {
+	text := strings.ReplaceAll(string(base), "\r\n", "\n")
+	noEOL := text != "" && !strings.HasSuffix(text, "\n")
+	old := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		old = nil
+	}
+
+	// 最多保留3行上下文
+	tail := old
+	if len(tail) > 3 {
+		tail = tail[len(tail)-3:]
+	}
+	oldStart, newStart := len(old)-len(tail)+1, len(old)-len(tail)+1
+	if len(old) == 0 {
+		oldStart, newStart = 0, 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, len(tail), newStart, len(tail)+len(lines))
+	for i, line := range tail {
+		if noEOL && i == len(tail)-1 {
+			// 原文件最后一行没有换行，需要先删除再加上换行
+			fmt.Fprintf(&b, "-%s\n\\ No newline at end of file\n+%s\n", line, line)
+			continue
+		}
+		fmt.Fprintf(&b, " %s\n", line)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(&b, "+%s\n", line)
+	}
+	return b.String()
+}
+
+// GitHub API客户端
+type githubAPI struct {
+	client *http.Client
+	token  string
+}
+
+// 发送API请求，body不为nil时以JSON发送，out不为nil时解析JSON响应
+func (g *githubAPI) do(method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, githubAPIBase+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("请求GitHub API失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		if apiErr.Message != "" {
+			return resp.StatusCode, fmt.Errorf("%s %s 失败 (状态码: %d): %s", method, path, resp.StatusCode, apiErr.Message)
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s 失败，状态码: %d", method, path, resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("解析GitHub API响应失败: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// 在token对应用户的fork中新建分支，在列表文件name末尾追加lines，然后向列表仓库提交PR，返回PR的链接
+func (g *githubAPI) openListPR(name string, lines []string) (string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := g.do(http.MethodGet, "/user", nil, &user); err != nil {
+		return "", err
+	}
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if _, err := g.do(http.MethodGet, "/repos/"+githubListRepo, nil, &repo); err != nil {
+		return "", err
+	}
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if _, err := g.do(http.MethodGet, "/repos/"+githubListRepo+"/git/ref/heads/"+repo.DefaultBranch, nil, &ref); err != nil {
+		return "", err
+	}
+	var file struct {
+		SHA     string `json:"sha"`
+		Content string `json:"content"`
+	}
+	if _, err := g.do(http.MethodGet, "/repos/"+githubListRepo+"/contents/"+name+"?ref="+ref.Object.SHA, nil, &file); err != nil {
+		return "", err
+	}
+	// API返回的base64内容每60个字符换一行
+	base, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+	if err != nil {
+		return "", fmt.Errorf("解析%s的内容失败: %v", name, err)
+	}
+
+	// 已经fork过时返回现有的fork，fork是异步创建的，需要等待可以访问
+	var fork struct {
+		FullName string `json:"full_name"`
+	}
+	if _, err := g.do(http.MethodPost, "/repos/"+githubListRepo+"/forks", map[string]interface{}{}, &fork); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(infoOut, "使用fork %s\n", fork.FullName)
+	deadline := time.Now().Add(time.Minute)
+	for {
+		_, err := g.do(http.MethodGet, "/repos/"+fork.FullName, nil, nil)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("等待fork创建超时: %v", err)
+		}
+		time.Sleep(3 * time.Second)
+	}
+
+	branch := "add-mirrors-" + time.Now().Format("20060102-150405")
+	if _, err := g.do(http.MethodPost, "/repos/"+fork.FullName+"/git/refs", map[string]string{
+		"ref": "refs/heads/" + branch,
+		"sha": ref.Object.SHA,
+	}, nil); err != nil {
+		return "", err
+	}
+
+	title := fmt.Sprintf("%s: 添加 %d 个镜像源", name, len(lines))
+	if _, err := g.do(http.MethodPut, "/repos/"+fork.FullName+"/contents/"+name, map[string]string{
+		"message": title,
+		"content": base64.StdEncoding.EncodeToString(appendListLines(base, lines)),
+		"sha":     file.SHA,
+		"branch":  branch,
+	}, nil); err != nil {
+		return "", err
+	}
+
+	owner, _, _ := strings.Cut(fork.FullName, "/")
+	body := "以下镜像源通过了 `docker-registry-checker discover` 的深度检测 (/v2/ 可访问、能拉取镜像清单，Docker Hub镜像源的内容与Docker Hub一致):\n\n"
+	for _, line := range lines {
+		body += "- `" + line + "`\n"
+	}
+	var pull struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if _, err := g.do(http.MethodPost, "/repos/"+githubListRepo+"/pulls", map[string]string{
+		"title": title,
+		"head":  owner + ":" + branch,
+		"base":  repo.DefaultBranch,
+		"body":  body,
+	}, &pull); err != nil {
+		return "", err
+	}
+	return pull.HTMLURL, nil
+}
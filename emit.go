@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// -emit 支持的格式
+var emitFormats = []string{"ansible", "cloud-init", "shell", "k8s-daemonset", "docker-config", "skopeo", "crane"}
+
+func validateEmitFormat(format, runtimeName string) error {
+	if !containsString(emitFormats, format) {
+		return fmt.Errorf("不支持的 -emit 格式: %s (支持 %s)", format, strings.Join(emitFormats, "/"))
+	}
+	if format == "k8s-daemonset" && runtimeName != "docker" && runtimeName != "containerd" {
+		return fmt.Errorf("k8s-daemonset 只支持 -runtime docker 或 containerd")
+	}
+	return nil
+}
+
+// 输出写入镜像源的配置片段，path为空时输出到标准输出
+//
+// ansible、cloud-init、shell 写入 /etc/docker/daemon.json，k8s-daemonset 按运行时写入Docker或containerd的配置；
+// docker-config、skopeo、crane 用于不经过dockerd拉取镜像的工具。
+func emitSnippet(format, path string, mirrors []string, runtimeName string) error {
+	if len(mirrors) == 0 {
+		return fmt.Errorf("没有可用的镜像源，不生成 %s 配置", format)
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("创建文件失败: %v", err)
+		}
+		defer file.Close()
+		w = file
+	} else {
+		fmt.Println()
+	}
+
+	var err error
+	switch format {
+	case "ansible":
+		err = writeAnsibleSnippet(w, mirrors)
+	case "cloud-init":
+		err = writeCloudInitSnippet(w, mirrors)
+	case "shell":
+		err = writeShellSnippet(w, mirrors)
+	case "k8s-daemonset":
+		err = writeDaemonSetSnippet(w, mirrors, runtimeName)
+	case "docker-config":
+		err = writeDockerConfigSnippet(w, mirrors)
+	case "skopeo":
+		err = writeSkopeoSnippet(w, mirrors)
+	case "crane":
+		err = writeCraneSnippet(w, mirrors)
+	}
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Printf("\n%s 配置已写入 %s\n", format, path)
+	}
+	return nil
+}
+
+// 镜像源和需要加入insecure-registries的HTTP镜像源，均为JSON数组
+func emitMirrorLists(mirrors []string) (mirrorList, insecureList string) {
+	config := DaemonConfig{}
+	config.setMirrors(mirrors)
+	m, _ := json.Marshal(config.RegistryMirrors)
+	i, _ := json.Marshal(append([]string{}, config.InsecureRegistries...))
+	return string(m), string(i)
+}
+
+// Ansible playbook: 保留daemon.json中的其他配置，只替换registry-mirrors，有修改时重新加载Docker
+func writeAnsibleSnippet(w io.Writer, mirrors []string) error {
+	mirrorList, insecureList := emitMirrorLists(mirrors)
+	_, err := fmt.Fprintf(w, `# 由 docker-registry-checker 生成，可重复执行
+- name: 配置Docker镜像源
+  hosts: all
+  become: true
+  vars:
+    docker_registry_mirrors: %s
+    docker_insecure_registries: %s
+  tasks:
+    - name: 读取现有的 daemon.json
+      ansible.builtin.slurp:
+        src: %s
+      register: docker_daemon_json
+      failed_when: false
+
+    - name: 解析现有配置
+      ansible.builtin.set_fact:
+        docker_daemon_config: "{{ docker_daemon_json.content | default('e30=') | b64decode | from_json }}"
+
+    - name: 写入镜像源
+      ansible.builtin.copy:
+        dest: %s
+        content: >-
+          {{ docker_daemon_config
+             | combine({'registry-mirrors': docker_registry_mirrors})
+             | combine({'insecure-registries': docker_daemon_config['insecure-registries'] | default([]) | union(docker_insecure_registries)}
+                       if docker_insecure_registries else {})
+             | to_nice_json(indent=4) }}
+        mode: "0644"
+        backup: true
+      notify: 重新加载Docker
+
+  handlers:
+    - name: 重新加载Docker
+      ansible.builtin.systemd:
+        name: docker
+        state: reloaded
+`, mirrorList, insecureList, daemonConfigPath, daemonConfigPath)
+	return err
+}
+
+// cloud-init: 新建机器时写入daemon.json，Docker在之后安装时直接读取；已安装时重新加载
+func writeCloudInitSnippet(w io.Writer, mirrors []string) error {
+	config := &DaemonConfig{}
+	config.setMirrors(mirrors)
+	data, err := marshalDaemonConfig(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, `#cloud-config
+# 由 docker-registry-checker 生成
+write_files:
+  - path: %s
+    owner: root:root
+    permissions: "0644"
+    content: |
+%sruncmd:
+  - [sh, -c, "if systemctl is-active --quiet docker; then systemctl reload docker; fi"]
+`, daemonConfigPath, indentLines(string(data), "      "))
+	return err
+}
+
+// 可重复执行的shell脚本: 通过jq或python3修改daemon.json，内容没有变化时不做任何操作
+func writeShellSnippet(w io.Writer, mirrors []string) error {
+	mirrorList, insecureList := emitMirrorLists(mirrors)
+	_, err := fmt.Fprintf(w, `#!/bin/sh
+# 由 docker-registry-checker 生成: 配置Docker镜像源，保留daemon.json中的其他配置，可重复执行
+set -e
+
+CONFIG=%s
+MIRRORS='%s'
+INSECURE='%s'
+
+if [ -s "$CONFIG" ]; then CURRENT=$(cat "$CONFIG"); else CURRENT='{}'; fi
+TMP=$(mktemp)
+trap 'rm -f "$TMP"' EXIT
+
+if command -v jq >/dev/null 2>&1; then
+    printf '%%s' "$CURRENT" | jq --indent 4 --argjson m "$MIRRORS" --argjson i "$INSECURE" \
+        '.["registry-mirrors"] = $m | if ($i | length) > 0 then .["insecure-registries"] = ((.["insecure-registries"] // []) + $i | unique) else . end' > "$TMP"
+elif command -v python3 >/dev/null 2>&1; then
+    printf '%%s' "$CURRENT" | python3 -c '
+import json, sys
+config = json.load(sys.stdin)
+config["registry-mirrors"] = json.loads(sys.argv[1])
+insecure = json.loads(sys.argv[2])
+if insecure:
+    config["insecure-registries"] = sorted(set(config.get("insecure-registries", []) + insecure))
+print(json.dumps(config, indent=4))
+' "$MIRRORS" "$INSECURE" > "$TMP"
+else
+    echo "需要 jq 或 python3 来修改 $CONFIG" >&2
+    exit 1
+fi
+
+if [ -e "$CONFIG" ] && cmp -s "$TMP" "$CONFIG"; then
+    echo "镜像源已是最新，无需修改"
+    exit 0
+fi
+
+mkdir -p "$(dirname "$CONFIG")"
+if [ -e "$CONFIG" ]; then cp -p "$CONFIG" "$CONFIG.bak.$(date +%%Y%%m%%d-%%H%%M%%S)"; fi
+cat "$TMP" > "$CONFIG"
+chmod 644 "$CONFIG"
+echo "已写入 $CONFIG"
+
+if [ -d /run/systemd/system ]; then
+    if systemctl is-active --quiet docker; then systemctl reload docker; fi
+elif pid=$(pidof dockerd); then
+    kill -HUP $pid
+fi
+`, daemonConfigPath, mirrorList, insecureList)
+	return err
+}
+
+// Kubernetes DaemonSet: 在每个节点上通过特权init容器写入镜像源配置，适合无法SSH登录节点的集群
+//
+// 宿主机的根目录挂载到 /host，Docker节点通过chroot执行 -emit shell 生成的脚本 (保留其他配置并重新加载dockerd)，
+// containerd节点写入 certs.d/docker.io/hosts.toml (每次拉取时重新读取，不需要重启)。
+// 配置没有变化时init容器不做任何操作，Pod重建时可以安全地重复执行；配置的哈希写入Pod注解，修改后会滚动更新。
+func writeDaemonSetSnippet(w io.Writer, mirrors []string, runtimeName string) error {
+	// 节点上的运行时与本机无关，Docker按系统级的安装写入
+	target := systemDocker
+	if runtimeName == "containerd" {
+		target = containerdTarget
+	}
+
+	var file, script string
+	var content bytes.Buffer
+	if target.Format == configHostsTOML {
+		file = "hosts.toml"
+		config := &DaemonConfig{}
+		config.setMirrors(mirrors)
+		content.Write(renderHostsTOML(nil, config))
+		script = fmt.Sprintf(`set -e
+TARGET=/host%s
+mkdir -p "$(dirname "$TARGET")"
+if cmp -s /config/hosts.toml "$TARGET"; then echo "镜像源已是最新，无需修改"; exit 0; fi
+if [ -e "$TARGET" ]; then cp -p "$TARGET" "$TARGET.bak.$(date +%%Y%%m%%d-%%H%%M%%S)"; fi
+cp /config/hosts.toml "$TARGET"
+echo "已写入 %s"
+if ! grep -q config_path /host%s 2>/dev/null; then
+  echo "注意: %s 中没有设置 config_path，containerd 1.x 不会读取 hosts.toml" >&2
+fi`, target.ConfigPath, target.ConfigPath, containerdConfigPath, containerdConfigPath)
+	} else {
+		file = "configure.sh"
+		if err := writeShellSnippet(&content, mirrors); err != nil {
+			return err
+		}
+		script = "chroot /host sh -s < /config/configure.sh"
+	}
+
+	sum := sha256.Sum256(content.Bytes())
+	hash := hex.EncodeToString(sum[:])[:16]
+
+	_, err := fmt.Fprintf(w, `# 由 docker-registry-checker 生成: 在每个节点上写入%s的镜像源配置
+# kubectl apply -f docker-registry-mirrors.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: docker-registry-mirrors
+  namespace: kube-system
+data:
+  %s: |
+%s---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: docker-registry-mirrors
+  namespace: kube-system
+  labels:
+    app: docker-registry-mirrors
+spec:
+  selector:
+    matchLabels:
+      app: docker-registry-mirrors
+  template:
+    metadata:
+      labels:
+        app: docker-registry-mirrors
+      annotations:
+        docker-registry-checker/config-hash: "%s"
+    spec:
+      tolerations:
+        - operator: Exists
+      priorityClassName: system-node-critical
+      initContainers:
+        - name: configure
+          image: busybox:1.36
+          securityContext:
+            privileged: true
+          command:
+            - sh
+            - -c
+            - |
+%s          volumeMounts:
+            - name: host
+              mountPath: /host
+            - name: config
+              mountPath: /config
+      containers:
+        - name: pause
+          image: registry.k8s.io/pause:3.9
+          resources:
+            requests:
+              cpu: 1m
+              memory: 4Mi
+      volumes:
+        - name: host
+          hostPath:
+            path: /
+        - name: config
+          configMap:
+            name: docker-registry-mirrors
+`, target.Name, file, indentLines(content.String(), "    "), hash, indentLines(script, "              "))
+	return err
+}
+
+// 给每一行加上缩进，用于嵌入YAML的块标量
+func indentLines(text, indent string) string {
+	var b strings.Builder
+	for _, line := range splitLines(strings.TrimRight(text, "\n")) {
+		if line == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(indent + line + "\n")
+	}
+	return b.String()
+}
+
+// ~/.docker/config.json 片段: 为镜像源添加空的认证条目，表示匿名访问
+//
+// config.json 本身不支持配置镜像源，docker pull 和 docker compose 由dockerd拉取镜像，使用daemon.json中的registry-mirrors；
+// 这里的条目供直接访问镜像源的工具 (如crane) 使用，需要手动合并到现有的 auths 中。
+func writeDockerConfigSnippet(w io.Writer, mirrors []string) error {
+	auths := make(map[string]struct{}, len(mirrors))
+	for _, mirror := range mirrors {
+		auths[mirrorHost(mirror)] = struct{}{}
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"auths": auths}, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// containers-registries.conf (v2) 片段，skopeo、podman、buildah 拉取docker.io的镜像时按顺序尝试镜像源
+func writeSkopeoSnippet(w io.Writer, mirrors []string) error {
+	var b strings.Builder
+	b.WriteString("# 由 docker-registry-checker 生成，保存为 /etc/containers/registries.conf.d/docker-mirrors.conf\n")
+	b.WriteString("# 或 ~/.config/containers/registries.conf.d/docker-mirrors.conf\n")
+	b.WriteString("[[registry]]\nprefix = \"docker.io\"\nlocation = \"registry-1.docker.io\"\n")
+	for _, mirror := range mirrors {
+		fmt.Fprintf(&b, "\n[[registry.mirror]]\nlocation = %q\n", mirrorHost(mirror))
+		if strings.HasPrefix(mirror, "http://") {
+			b.WriteString("insecure = true\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// crane 的环境变量: crane不支持配置镜像源，拉取Docker Hub的镜像时直接使用镜像源地址
+func writeCraneSnippet(w io.Writer, mirrors []string) error {
+	mirror := mirrors[0]
+	var b strings.Builder
+	b.WriteString("# 由 docker-registry-checker 生成\n")
+	b.WriteString("# crane 不读取镜像源配置，拉取Docker Hub的镜像时把 docker.io 替换为 $DOCKER_MIRROR\n")
+	fmt.Fprintf(&b, "export DOCKER_MIRROR=%s\n", mirrorHost(mirror))
+	if len(mirrors) > 1 {
+		list := make([]string, 0, len(mirrors)-1)
+		for _, m := range mirrors[1:] {
+			list = append(list, mirrorHost(m))
+		}
+		fmt.Fprintf(&b, "# 备用: %s\n", strings.Join(list, " "))
+	}
+	flags := ""
+	if strings.HasPrefix(mirror, "http://") {
+		flags = " --insecure"
+	}
+	fmt.Fprintf(&b, "# 例: crane pull%s \"$DOCKER_MIRROR/library/alpine:latest\" alpine.tar\n", flags)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// 默认的Docker socket
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// 访问Docker Engine API的超时时间
+const engineTimeout = 5 * time.Second
+
+// Engine API /info 中用到的字段
+type engineInfo struct {
+	ServerVersion   string   `json:"ServerVersion"`
+	OperatingSystem string   `json:"OperatingSystem"`
+	SecurityOptions []string `json:"SecurityOptions"`
+	RegistryConfig  struct {
+		Mirrors []string `json:"Mirrors"`
+	} `json:"RegistryConfig"`
+}
+
+// 当前使用的Docker socket，DOCKER_HOST 为 unix:// 时使用其路径
+func dockerSocket() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if u, err := url.Parse(host); err == nil && u.Scheme == "unix" {
+			return u.Path
+		}
+	}
+	return defaultDockerSocket
+}
+
+// 通过socket访问Docker Engine API，不依赖docker命令和systemd
+func engineGet(ctx context.Context, path string, v interface{}) error {
+	socket := dockerSocket()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, engineTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("连接Docker失败 (%s): %v", socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求Docker %s 失败，状态码: %d", path, resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("解析Docker %s 的响应失败: %v", path, err)
+	}
+	return nil
+}
+
+// 检查dockerd是否在运行
+func enginePing(ctx context.Context) error {
+	return engineGet(ctx, "/_ping", nil)
+}
+
+// 读取dockerd的信息，包括当前生效的镜像源
+func dockerdInfo(ctx context.Context) (*engineInfo, error) {
+	info := &engineInfo{}
+	if err := engineGet(ctx, "/info", info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// 是否由systemd管理服务，WSL2、Alpine (OpenRC) 和容器中 (Docker-in-Docker) 通常没有systemd
+func hasSystemd() bool {
+	info, err := os.Stat("/run/systemd/system")
+	return err == nil && info.IsDir()
+}
+
+// 按当前的init系统调整让配置生效的命令
+//
+// 没有systemd时通过向dockerd发送SIGHUP重新加载配置 (registry-mirrors支持热加载)，
+// 重启使用OpenRC的rc-service或SysV的service；两者都没有时 (如Docker-in-Docker)
+// 只能重新加载。pidFile为dockerd的pid文件。
+func (t dockerTarget) forInitSystem(pidFile string) dockerTarget {
+	if hasSystemd() {
+		return t
+	}
+
+	t.DaemonReload = ""
+	t.Reload = fmt.Sprintf("kill -HUP $(cat %s 2>/dev/null || pidof dockerd)", pidFile)
+	switch {
+	case t.NeedRoot && commandExists("rc-service"):
+		t.Restart = "rc-service docker restart"
+	case t.NeedRoot && commandExists("service") && fileExists("/etc/init.d/docker"):
+		t.Restart = "service docker restart"
+	default:
+		t.Restart, t.ReloadOnly = t.Reload, true
+	}
+	return t
+}
+
+// rootless Docker 的pid文件
+func rootlessPidFile() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "docker.pid")
+	}
+	return filepath.Join("/run/user", fmt.Sprint(os.Getuid()), "docker.pid")
+}
+
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
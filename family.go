@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// 只有一种地址族的镜像源
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// 查询镜像源的地址族，只有A记录或只有AAAA记录时返回对应的地址族，
+// 双栈或解析失败时返回空
+func lookupFamily(ctx context.Context, dns *dnsCache, host string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		return ipFamily(ip)
+	}
+
+	addrs, err := dns.lookupIPAddr(ctx, hostname)
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	family := ipFamily(addrs[0].IP)
+	for _, addr := range addrs[1:] {
+		if ipFamily(addr.IP) != family {
+			return ""
+		}
+	}
+	return family
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return familyIPv4
+	}
+	return familyIPv6
+}
+
+// 本机可以使用的地址族
+type localNetwork struct {
+	IPv4 bool
+	IPv6 bool
+}
+
+// 检测本机是否有IPv4/IPv6路由
+//
+// 对UDP执行connect只会查找路由，不会发送数据包，没有对应地址族的路由时会立即失败。
+func detectLocalNetwork() localNetwork {
+	return localNetwork{
+		IPv4: hasRoute("udp4", "8.8.8.8:53"),
+		IPv6: hasRoute("udp6", "[2001:4860:4860::8888]:53"),
+	}
+}
+
+func hasRoute(network, addr string) bool {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// 判断本机能否使用该镜像源
+func (n localNetwork) usable(result CheckResult) bool {
+	switch result.Family {
+	case familyIPv4:
+		return n.IPv4
+	case familyIPv6:
+		return n.IPv6
+	}
+	return true
+}
+
+// 筛选出本机能够使用的镜像源
+func (n localNetwork) filter(results []CheckResult) []CheckResult {
+	var filtered []CheckResult
+	for _, result := range results {
+		if n.usable(result) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// 提示本机缺少对应地址族而无法使用的镜像源
+func (n localNetwork) warn(w io.Writer, results []CheckResult) {
+	var v4Only, v6Only []string
+	for _, result := range results {
+		if n.usable(result) {
+			continue
+		}
+		if result.Family == familyIPv4 {
+			v4Only = append(v4Only, result.Host)
+		} else {
+			v6Only = append(v6Only, result.Host)
+		}
+	}
+
+	if len(v6Only) > 0 {
+		fmt.Fprintf(w, "\n警告: 本机没有IPv6网络，以下镜像源只有IPv6地址，无法使用: %s\n", strings.Join(v6Only, ", "))
+	}
+	if len(v4Only) > 0 {
+		fmt.Fprintf(w, "\n警告: 本机没有IPv4网络，以下镜像源只有IPv4地址，无法使用: %s\n", strings.Join(v4Only, ", "))
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// 列表文件在GitHub上的地址前缀，文件名为各分类的列表文件 (如 docker.txt)
+const githubListBaseURL = "https://raw.githubusercontent.com/YMingPro/docker-register-check/main/"
+
+// 通过jsDelivr CDN访问同一个仓库
+const jsdelivrListBaseURL = "https://cdn.jsdelivr.net/gh/YMingPro/docker-register-check@main/"
+
+// 默认依次尝试的下载方式: 直连GitHub、jsDelivr
+//
+// ghproxy类的第三方加速前缀可以任意修改返回的列表，不默认使用，需要通过 -github-proxy 显式指定。
+var defaultGithubProxies = []string{"direct", "jsdelivr"}
+
+// 所有下载方式都失败后，-auto-update-list 至少间隔这么久 (不超过 -auto-update-list 本身) 才再次尝试，
+// 离线时不会每次运行都把每种方式试一遍
+const listRetryAfterFailure = time.Hour
+
+// 每种下载方式的超时时间，GitHub被屏蔽时连接常常没有任何响应，不设置超时会一直等待
+const githubDownloadTimeout = 15 * time.Second
+
+// 解析 -github-proxy 参数，逗号分隔，为空时使用默认列表
+//
+// direct 表示直连GitHub，jsdelivr 表示通过jsDelivr CDN下载，其余为 ghproxy 类的加速前缀，
+// 下载地址为前缀加上完整的GitHub地址，如 https://ghfast.top/https://raw.githubusercontent.com/...
+func parseGithubProxies(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return defaultGithubProxies, nil
+	}
+	var proxies []string
+	for _, proxy := range strings.Split(spec, ",") {
+		proxy = strings.TrimSpace(proxy)
+		switch {
+		case proxy == "":
+			continue
+		case proxy == "direct" || proxy == "jsdelivr":
+		case strings.HasPrefix(proxy, "http://") || strings.HasPrefix(proxy, "https://"):
+			if !strings.HasSuffix(proxy, "/") {
+				proxy += "/"
+			}
+		default:
+			return nil, fmt.Errorf("无效的GitHub代理: %q (应为 direct、jsdelivr 或 https://开头的前缀)", proxy)
+		}
+		if !containsString(proxies, proxy) {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if len(proxies) == 0 {
+		return defaultGithubProxies, nil
+	}
+	return proxies, nil
+}
+
+// 代理对应的列表文件name的下载地址
+func githubProxyURL(proxy, name string) string {
+	switch proxy {
+	case "direct":
+		return githubListBaseURL + name
+	case "jsdelivr":
+		return jsdelivrListBaseURL + name
+	default:
+		return proxy + githubListBaseURL + name
+	}
+}
+
+// 记录列表文件的来源和缓存校验信息的文件，与列表文件放在同一目录，如 .docker.txt.meta
+func listMetaPath(name string) string {
+	return "." + name + ".meta"
+}
+
+// 列表文件的下载记录，用于条件请求 (If-None-Match / If-Modified-Since) 和 -auto-update-list
+type listMeta struct {
+	// 下载时使用的地址，不同代理返回的ETag不同，只对同一地址发送条件请求
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// 最后一次成功检查更新的时间
+	Checked time.Time `json:"checked"`
+	// 最后一次所有下载方式都失败的时间，之后成功时清除
+	Failed time.Time `json:"failed,omitempty"`
+}
+
+// 读取下载记录，不存在或无法解析时返回nil
+func loadListMeta(name string) *listMeta {
+	data, err := os.ReadFile(listMetaPath(name))
+	if err != nil {
+		return nil
+	}
+	meta := &listMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
+func saveListMeta(name string, meta *listMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(listMetaPath(name), append(data, '\n'), nil)
+}
+
+// 列表文件是否超过maxAge没有检查更新，没有下载记录时 (如手动放置的文件) 按文件的修改时间计算
+//
+// 上一次检查更新失败时，距离失败超过 listRetryAfterFailure (或更短的maxAge) 才再次检查。
+func listStale(name string, maxAge time.Duration) bool {
+	meta := loadListMeta(name)
+	if meta != nil && meta.Failed.After(meta.Checked) {
+		retry := listRetryAfterFailure
+		if maxAge < retry {
+			retry = maxAge
+		}
+		return time.Since(meta.Failed) > retry
+	}
+	if meta != nil && !meta.Checked.IsZero() {
+		return time.Since(meta.Checked) > maxAge
+	}
+	info, err := os.Stat(name)
+	return err == nil && time.Since(info.ModTime()) > maxAge
+}
+
+// 从GitHub下载列表文件name (如 docker.txt)，按顺序尝试每个代理直到成功，返回内容是否有变化
+//
+// 本地已有该文件时发送条件请求，服务端返回304时不重新下载；force为true时 (显式指定 -update) 总是重新下载。
+// 所有方式都失败时记录失败的时间，见 listStale。
+// verifier不为nil时，下载的内容必须通过签名校验，校验失败时尝试下一种方式；
+// 签名与列表一起保存 (如 docker.txt.minisig)，之后每次读取本地列表时重新校验。
+func downloadFromGithub(name string, proxies []string, verifier *listVerifier, force bool) (bool, error) {
+	client := &http.Client{Timeout: githubDownloadTimeout}
+	recorded := loadListMeta(name)
+	previous := recorded
+	// 需要校验签名时总是重新下载，304时无法确认本地文件是否经过校验
+	if !fileExists(name) || verifier != nil || force {
+		previous = nil
+	}
+
+	var errs []string
+	for _, proxy := range proxies {
+		url := githubProxyURL(proxy, name)
+		cached := previous
+		if cached != nil && cached.URL != url {
+			cached = nil
+		}
+		data, meta, err := downloadList(client, url, cached)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", proxy, err))
+			if len(proxies) > 1 {
+				fmt.Fprintf(infoOut, "通过 %s 下载失败: %v\n", proxy, err)
+			}
+			continue
+		}
+		var signature []byte
+		if data != nil && verifier != nil {
+			if signature, err = verifier.verifyURL(client, url, data); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", proxy, err))
+				fmt.Fprintf(infoOut, "通过 %s 下载的%s没有通过签名校验: %v\n", proxy, name, err)
+				continue
+			}
+		}
+		// 强制下载时内容可能与本地相同
+		if data != nil && force {
+			if local, err := os.ReadFile(name); err == nil && bytes.Equal(local, data) && signature == nil {
+				data = nil
+			}
+		}
+		if data != nil {
+			// 下载中断时不留下不完整的列表文件
+			if err := atomicWriteFile(name, data, nil); err != nil {
+				return false, fmt.Errorf("保存文件失败: %v", err)
+			}
+		}
+		if signature != nil {
+			if err := atomicWriteFile(verifier.signaturePath(name), signature, nil); err != nil {
+				return false, fmt.Errorf("保存签名失败: %v", err)
+			}
+		}
+		// 下载记录只用于减少请求，写入失败不影响结果
+		if err := saveListMeta(name, meta); err != nil {
+			fmt.Fprintf(infoOut, "保存 %s 失败: %v\n", listMetaPath(name), err)
+		}
+		if proxy != "direct" && data != nil {
+			fmt.Fprintf(infoOut, "已通过 %s 下载\n", proxy)
+			if proxy != "jsdelivr" && verifier == nil {
+				fmt.Fprintf(infoOut, "注意: %s 由第三方代理提供且没有校验签名，内容可能被修改，可以用 -list-pubkey 校验\n", name)
+			}
+		}
+		return data != nil, nil
+	}
+	// 保留原来的校验信息，只记录失败的时间
+	failed := &listMeta{}
+	if recorded != nil {
+		failed = recorded
+	}
+	failed.Failed = time.Now()
+	if err := saveListMeta(name, failed); err != nil {
+		fmt.Fprintf(infoOut, "保存 %s 失败: %v\n", listMetaPath(name), err)
+	}
+	if len(errs) == 1 {
+		return false, fmt.Errorf("下载失败: %s", errs[0])
+	}
+	return false, fmt.Errorf("下载失败，已尝试 %d 种方式", len(errs))
+}
+
+// 下载一个列表文件并确认内容确实是列表，部分代理出错时会返回200和一个HTML页面
+//
+// cached不为nil时发送条件请求，内容没有变化 (304) 时返回的data为nil。
+func downloadList(client *http.Client, url string, cached *listMeta) ([]byte, *listMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	meta := &listMeta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Checked:      time.Now(),
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// 304响应可以不带校验信息，此时沿用之前的
+		if meta.ETag == "" {
+			meta.ETag = cached.ETag
+		}
+		if meta.LastModified == "" {
+			meta.LastModified = cached.LastModified
+		}
+		return nil, meta, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(trimmed) == 0 {
+		return nil, nil, fmt.Errorf("内容为空")
+	}
+	if trimmed[0] == '<' {
+		return nil, nil, fmt.Errorf("返回的是网页而不是列表文件")
+	}
+	if _, err := newListParser().parse(data, url); err != nil {
+		return nil, nil, fmt.Errorf("内容无法解析: %v", err)
+	}
+	return data, meta, nil
+}
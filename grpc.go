@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-registry-checker/api"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// 保留进度的检测轮数，更早的检测无法再通过 StreamResults 获取
+const maxTrackedRuns = 20
+
+// 一轮检测的进度
+type trackedRun struct {
+	id      string
+	results []CheckResult
+	done    bool
+	// 检测失败或被取消的原因
+	err error
+	// 有新结果或检测结束时关闭，唤醒等待中的 StreamResults；有新结果时替换为新的通道
+	changed chan struct{}
+}
+
+// 记录最近几轮检测的进度，供 StreamResults 按完成顺序推送结果。nil表示不记录 (没有开启gRPC接口)
+type runTracker struct {
+	mu   sync.Mutex
+	runs []*trackedRun
+	seq  int
+}
+
+func newRunTracker() *runTracker {
+	return &runTracker{}
+}
+
+// 开始记录一轮检测，返回其ID
+func (t *runTracker) begin(now time.Time) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	id := fmt.Sprintf("%s-%d", now.Format("20060102T150405"), t.seq)
+	t.runs = append(t.runs, &trackedRun{id: id, changed: make(chan struct{})})
+	if len(t.runs) > maxTrackedRuns {
+		t.runs = t.runs[len(t.runs)-maxTrackedRuns:]
+	}
+	return id
+}
+
+// 调用时持有锁
+func (t *runTracker) find(id string) *trackedRun {
+	for _, run := range t.runs {
+		if run.id == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// 记录一个完成的检测结果
+func (t *runTracker) add(id string, result CheckResult) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if run := t.find(id); run != nil && !run.done {
+		run.results = append(run.results, result)
+		close(run.changed)
+		run.changed = make(chan struct{})
+	}
+}
+
+// 一轮检测结束，err为检测失败或被取消的原因
+func (t *runTracker) finish(id string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if run := t.find(id); run != nil && !run.done {
+		run.done, run.err = true, err
+		close(run.changed)
+	}
+}
+
+// 一轮检测从第from个结果开始的进度
+type runProgress struct {
+	Results []CheckResult
+	Done    bool
+	Err     error
+	// 检测还没有结束时，有新结果或检测结束时关闭
+	Changed <-chan struct{}
+}
+
+// 查询一轮检测的进度，没有该检测 (ID错误或已经不在保留的范围内) 时ok为false
+func (t *runTracker) progress(id string, from int) (runProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run := t.find(id)
+	if run == nil {
+		return runProgress{}, false
+	}
+	progress := runProgress{Done: run.done, Err: run.err, Changed: run.changed}
+	if from < len(run.results) {
+		progress.Results = append([]CheckResult(nil), run.results[from:]...)
+	}
+	return progress, true
+}
+
+// 请求agent的检测循环开始一轮检测
+type agentRunRequest struct {
+	// 要检测的镜像源，为nil时检测agent配置中的列表
+	entries []listEntry
+	reply   chan agentRunReply
+}
+
+type agentRunReply struct {
+	id  string
+	err error
+}
+
+// agent 的 gRPC 接口 (api/checker.proto 中的 CheckService)
+//
+// 检测由agent的检测循环执行，与定时检测一样同一时间最多只有一轮；其余接口只读取检测循环同步过来的配置和结果。
+// nil表示没有开启gRPC接口。
+type checkServer struct {
+	api.UnimplementedCheckServiceServer
+
+	tracker *runTracker
+	runs    chan<- agentRunRequest
+	// 选择 ApplyConfig 写入的目标，与 -runtime 相同
+	selectTarget func(runtime string) (dockerTarget, error)
+
+	mu     sync.Mutex
+	config *AgentConfig
+	// 最近一轮按agent配置完成的检测结果
+	results []CheckResult
+	// 同一时间只执行一个 ApplyConfig
+	applyMu sync.Mutex
+}
+
+func newCheckServer(config *AgentConfig, tracker *runTracker, runs chan<- agentRunRequest) *checkServer {
+	return &checkServer{tracker: tracker, runs: runs, selectTarget: selectTarget, config: config}
+}
+
+// 重新加载配置后更新
+func (s *checkServer) setConfig(config *AgentConfig) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// 一轮按agent配置的检测完成后更新
+func (s *checkServer) setResults(results []CheckResult) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = results
+}
+
+func (s *checkServer) snapshot() (*AgentConfig, []CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config, s.results
+}
+
+func (s *checkServer) StartRun(ctx context.Context, req *api.StartRunRequest) (*api.StartRunResponse, error) {
+	var entries []listEntry
+	if len(req.Hosts) > 0 {
+		// - 在命令行中表示从标准输入读取
+		if containsString(req.Hosts, "-") {
+			return nil, status.Error(codes.InvalidArgument, "无效的镜像源: -")
+		}
+		var err error
+		if entries, err = readArgEntries(req.Hosts, nil); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if len(entries) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "hosts 中没有有效的镜像源")
+		}
+	}
+
+	reply := make(chan agentRunReply, 1)
+	select {
+	case s.runs <- agentRunRequest{entries: entries, reply: reply}:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	r := <-reply
+	if r.err != nil {
+		return nil, status.Error(codes.Unavailable, r.err.Error())
+	}
+	return &api.StartRunResponse{RunId: r.id}, nil
+}
+
+func (s *checkServer) StreamResults(req *api.StreamResultsRequest, stream api.CheckService_StreamResultsServer) error {
+	sent := 0
+	for {
+		progress, ok := s.tracker.progress(req.RunId, sent)
+		if !ok {
+			return status.Errorf(codes.NotFound, "没有找到检测 %q (只保留最近 %d 轮)", req.RunId, maxTrackedRuns)
+		}
+		for _, result := range progress.Results {
+			if err := stream.Send(protoCheckResult(result)); err != nil {
+				return err
+			}
+		}
+		sent += len(progress.Results)
+		if progress.Done {
+			if progress.Err != nil {
+				return status.Error(codes.Aborted, progress.Err.Error())
+			}
+			return nil
+		}
+
+		select {
+		case <-progress.Changed:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (s *checkServer) GetHistory(ctx context.Context, req *api.GetHistoryRequest) (*api.GetHistoryResponse, error) {
+	config, _ := s.snapshot()
+	if config.History == "" {
+		return nil, status.Error(codes.FailedPrecondition, "agent配置中没有设置 history")
+	}
+	// 还没有完成过检测时历史记录文件不存在
+	if _, err := os.Stat(config.History); os.IsNotExist(err) {
+		return &api.GetHistoryResponse{}, nil
+	}
+	var since time.Time
+	if req.Since != nil {
+		since = req.Since.AsTime()
+	}
+	runs, err := readHistory(config.History, since)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &api.GetHistoryResponse{Runs: make([]*api.HistoryRun, 0, len(runs))}
+	for _, run := range runs {
+		history := &api.HistoryRun{Time: timestamppb.New(run.Time), Results: make([]*api.HistoryResult, 0, len(run.Results))}
+		for _, result := range run.Results {
+			history.Results = append(history.Results, &api.HistoryResult{Host: result.Host, Ok: result.OK, Time: durationpb.New(result.Time)})
+		}
+		resp.Runs = append(resp.Runs, history)
+	}
+	return resp, nil
+}
+
+func (s *checkServer) ApplyConfig(ctx context.Context, req *api.ApplyConfigRequest) (*api.ApplyConfigResponse, error) {
+	if req.Count < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的镜像源数量: %d", req.Count)
+	}
+	// 与 -apply fastest 相同，不指定数量时写入1个
+	count := int(req.Count)
+	if count == 0 {
+		count = 1
+	}
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	_, results := s.snapshot()
+	successResults := filterSuccess(results)
+	if len(successResults) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "还没有完成的检测，或者最近一轮检测没有可用的镜像源")
+	}
+	target, err := s.selectTarget(req.Runtime)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if target.ConfigPath == "" {
+		return nil, status.Error(codes.FailedPrecondition, target.Note)
+	}
+
+	mirrors := fastestMirrors(successResults, count)
+	resp := &api.ApplyConfigResponse{Mirrors: mirrors, ConfigPath: target.ConfigPath}
+	if req.DryRun {
+		if resp.Diff, err = previewMirrors(target, mirrors); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return resp, nil
+	}
+
+	// 无法像命令行那样询问是否通过sudo重新运行
+	if target.NeedRoot && os.Geteuid() > 0 && !configWritable(target.ConfigPath) {
+		return nil, status.Errorf(codes.PermissionDenied, "agent没有写入 %s 的权限", target.ConfigPath)
+	}
+	if err := applyFastest(successResults, count, applyOptions{Target: target}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// 写入mirrors后配置文件的差异，不修改文件
+func previewMirrors(target dockerTarget, mirrors []string) (string, error) {
+	config, err := target.readConfig()
+	if err != nil {
+		return "", err
+	}
+	config.setMirrors(mirrors)
+	after, err := target.renderConfig(config)
+	if err != nil {
+		return "", err
+	}
+	before, err := os.ReadFile(target.ConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	var diff strings.Builder
+	writeDiff(&diff, target.ConfigPath, before, after)
+	return diff.String(), nil
+}
+
+func protoCheckResult(result CheckResult) *api.CheckResult {
+	r := &api.CheckResult{
+		Host:       result.Host,
+		Available:  result.Available,
+		Time:       durationpb.New(result.Time),
+		StatusCode: int32(result.StatusCode),
+		Timeout:    result.IsTimeout,
+		Protocol:   result.Protocol,
+		Upstream:   result.Upstream,
+		Family:     result.Family,
+		Attempts:   int32(result.Attempts),
+		Score:      result.Score,
+		Error:      result.Error,
+	}
+	if result.TTFB > 0 {
+		r.Ttfb = durationpb.New(result.TTFB)
+	}
+	return r
+}
+
+// gRPC接口的监听地址和认证方式
+type grpcOptions struct {
+	// TCP地址 (如 127.0.0.1:50051，不写主机时只监听本机) 或 unix:/path/to/agent.sock
+	Addr string
+	// 不为空时请求需要带上 authorization: Bearer <Token>
+	Token string
+	// TLS证书和私钥，都为空时不使用TLS
+	CertFile, KeyFile string
+}
+
+// 解析监听地址，返回网络类型、地址和是否只能从本机访问
+//
+// 只写端口 (如 :50051) 时监听 127.0.0.1，而不是所有网卡。
+func (o grpcOptions) listenAddress() (network, address string, local bool, err error) {
+	if path, ok := strings.CutPrefix(o.Addr, "unix:"); ok {
+		path = strings.TrimPrefix(path, "//")
+		if path == "" {
+			return "", "", false, fmt.Errorf("无效的gRPC监听地址: %s", o.Addr)
+		}
+		return "unix", path, true, nil
+	}
+	host, port, err := net.SplitHostPort(o.Addr)
+	if err != nil {
+		return "", "", false, fmt.Errorf("无效的gRPC监听地址: %v", err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	ip := net.ParseIP(host)
+	return "tcp", net.JoinHostPort(host, port), host == "localhost" || (ip != nil && ip.IsLoopback()), nil
+}
+
+// 在指定地址上提供gRPC接口，返回实际监听的地址
+//
+// ApplyConfig 会修改本机的镜像源配置，监听非本机地址时必须设置令牌；unix socket 只允许当前用户访问。
+// 监听失败 (如端口被占用) 时直接返回错误，不在后台静默失败。
+func serveGRPC(opts grpcOptions, server *checkServer, logger *log.Logger) (*grpc.Server, net.Addr, error) {
+	network, address, local, err := opts.listenAddress()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !local && opts.Token == "" {
+		return nil, nil, fmt.Errorf("gRPC接口监听在非本机地址 %s 时需要通过 -grpc-token-file 或环境变量 DRC_GRPC_TOKEN 设置令牌", address)
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, nil, fmt.Errorf("-grpc-tls-cert 和 -grpc-tls-key 需要同时指定")
+	}
+
+	var serverOpts []grpc.ServerOption
+	if opts.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("加载TLS证书失败: %v", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	if opts.Token != "" {
+		auth := grpcTokenAuth(opts.Token)
+		serverOpts = append(serverOpts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := auth(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := auth(stream.Context()); err != nil {
+					return err
+				}
+				return handler(srv, stream)
+			}))
+	}
+
+	if network == "unix" {
+		// 上次没有正常退出时留下的socket文件
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("监听 %s 失败: %v", address, err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			listener.Close()
+			return nil, nil, fmt.Errorf("设置 %s 的权限失败: %v", address, err)
+		}
+	}
+
+	grpcServer := grpc.NewServer(serverOpts...)
+	api.RegisterCheckServiceServer(grpcServer, server)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Printf("gRPC接口异常退出: %v", err)
+		}
+	}()
+	var features []string
+	if opts.CertFile != "" {
+		features = append(features, "TLS")
+	}
+	if opts.Token != "" {
+		features = append(features, "令牌认证")
+	}
+	if len(features) == 0 {
+		features = append(features, "无认证，仅本机")
+	}
+	logger.Printf("gRPC接口: %s (%s)", listener.Addr(), strings.Join(features, ", "))
+	if !local && opts.CertFile == "" {
+		logger.Printf("gRPC接口没有使用TLS，令牌以明文传输，建议通过 -grpc-tls-cert / -grpc-tls-key 开启TLS")
+	}
+	return grpcServer, listener.Addr(), nil
+}
+
+// 校验请求中的 authorization: Bearer <token>
+func grpcTokenAuth(token string) func(ctx context.Context) error {
+	want := []byte("Bearer " + token)
+	return func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(value), want) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "缺少或错误的令牌")
+	}
+}
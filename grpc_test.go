@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-registry-checker/api"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// 通过 serveGRPC 在临时目录的unix socket上启动gRPC接口，由一个简化的检测循环 (依次执行请求的检测) 代替agent的检测循环
+func startTestCheckServer(t *testing.T, config *AgentConfig) (api.CheckServiceClient, *checkServer) {
+	t.Helper()
+	tracker := newRunTracker()
+	runs := make(chan agentRunRequest)
+	server := newCheckServer(config, tracker, runs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		for {
+			select {
+			case req := <-runs:
+				id := tracker.begin(time.Now())
+				req.reply <- agentRunReply{id: id}
+				outcome := agentCheck(ctx, config, 1, req.entries, func(result CheckResult) {
+					tracker.add(id, result)
+				})
+				tracker.finish(id, outcome.Err)
+				if !outcome.Adhoc {
+					server.setResults(outcome.Results)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	grpcServer, _, err := serveGRPC(grpcOptions{Addr: "unix:" + socket}, server, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := dialTestGRPC(t, "unix:"+socket, insecure.NewCredentials())
+	t.Cleanup(func() {
+		conn.Close()
+		grpcServer.Stop()
+		cancel()
+		<-loopDone
+	})
+	return api.NewCheckServiceClient(conn), server
+}
+
+func dialTestGRPC(t *testing.T, target string, creds credentials.TransportCredentials, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial(target, append(opts, grpc.WithTransportCredentials(creds))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// 默认配置，列表和历史记录位于临时目录
+func testAgentConfig(t *testing.T) *AgentConfig {
+	t.Helper()
+	dir := t.TempDir()
+	config, err := loadAgentConfig(filepath.Join(dir, "agent.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.List = filepath.Join(dir, "list.txt")
+	config.History = filepath.Join(dir, "history.jsonl")
+	config.Timeout = 5 * time.Second
+	return config
+}
+
+func streamAll(t *testing.T, client api.CheckServiceClient, runID string) ([]*api.CheckResult, error) {
+	t.Helper()
+	stream, err := client.StreamResults(context.Background(), &api.StreamResultsRequest{RunId: runID})
+	if err != nil {
+		return nil, err
+	}
+	var results []*api.CheckResult
+	for {
+		result, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+}
+
+func TestCheckServerRun(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	config := testAgentConfig(t)
+	if err := os.WriteFile(config.List, []byte(registry.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, server := startTestCheckServer(t, config)
+	ctx := context.Background()
+
+	// 指定hosts的检测只通过 StreamResults 返回结果
+	resp, err := client.StartRun(ctx, &api.StartRunRequest{Hosts: []string{registry.URL + " region=cn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := streamAll(t, client, resp.RunId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Host != host || !results[0].Available || results[0].StatusCode != 200 || results[0].Time.AsDuration() <= 0 {
+		t.Fatalf("检测结果不正确: %v", results)
+	}
+	// 检测结束后仍然可以再次获取结果
+	if again, err := streamAll(t, client, resp.RunId); err != nil || len(again) != 1 {
+		t.Fatalf("再次获取结果: %v, %v", again, err)
+	}
+	if _, results := server.snapshot(); results != nil {
+		t.Fatalf("指定hosts的检测不应更新agent的结果: %v", results)
+	}
+
+	// 不指定hosts时检测配置中的列表，结果供 ApplyConfig 使用
+	resp, err = client.StartRun(ctx, &api.StartRunRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err := streamAll(t, client, resp.RunId); err != nil || len(results) != 1 {
+		t.Fatalf("按配置检测: %v, %v", results, err)
+	}
+	if _, results := server.snapshot(); len(results) != 1 || results[0].Host != host {
+		t.Fatalf("agent的结果没有更新: %v", results)
+	}
+
+	// 读取列表失败时 StreamResults 返回 Aborted
+	os.Remove(config.List)
+	resp, err = client.StartRun(ctx, &api.StartRunRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := streamAll(t, client, resp.RunId); status.Code(err) != codes.Aborted {
+		t.Errorf("列表不存在时应返回 Aborted，得到 %v", err)
+	}
+}
+
+func TestCheckServerErrors(t *testing.T) {
+	config := testAgentConfig(t)
+	config.History = ""
+	client, _ := startTestCheckServer(t, config)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"StartRun 标准输入", func() error {
+			_, err := client.StartRun(ctx, &api.StartRunRequest{Hosts: []string{"-"}})
+			return err
+		}, codes.InvalidArgument},
+		{"StartRun 无效的镜像源", func() error {
+			_, err := client.StartRun(ctx, &api.StartRunRequest{Hosts: []string{"a.example.com region"}})
+			return err
+		}, codes.InvalidArgument},
+		{"StartRun 只有注释", func() error {
+			_, err := client.StartRun(ctx, &api.StartRunRequest{Hosts: []string{"# 注释"}})
+			return err
+		}, codes.InvalidArgument},
+		{"StreamResults 未知的检测", func() error {
+			_, err := streamAll(t, client, "20060102T150405-1")
+			return err
+		}, codes.NotFound},
+		{"GetHistory 没有设置history", func() error {
+			_, err := client.GetHistory(ctx, &api.GetHistoryRequest{})
+			return err
+		}, codes.FailedPrecondition},
+		{"ApplyConfig 数量为负数", func() error {
+			_, err := client.ApplyConfig(ctx, &api.ApplyConfigRequest{Count: -1})
+			return err
+		}, codes.InvalidArgument},
+		{"ApplyConfig 没有检测结果", func() error {
+			_, err := client.ApplyConfig(ctx, &api.ApplyConfigRequest{DryRun: true})
+			return err
+		}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); status.Code(err) != tt.want {
+			t.Errorf("%s: 期望 %v，得到 %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestCheckServerGetHistory(t *testing.T) {
+	config := testAgentConfig(t)
+	client, _ := startTestCheckServer(t, config)
+	ctx := context.Background()
+
+	// 还没有历史记录
+	resp, err := client.GetHistory(ctx, &api.GetHistoryRequest{})
+	if err != nil || len(resp.Runs) != 0 {
+		t.Fatalf("GetHistory = %v, %v，期望空的结果", resp, err)
+	}
+
+	first := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	results := []CheckResult{
+		{Host: "a.example.com", Available: true, Time: 200 * time.Millisecond},
+		{Host: "b.example.com", StatusCode: 502, Time: time.Second},
+	}
+	for _, runAt := range []time.Time{first, second} {
+		if err := appendHistory(config.History, runAt, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err = client.GetHistory(ctx, &api.GetHistoryRequest{Since: timestamppb.New(second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Runs) != 1 || !resp.Runs[0].Time.AsTime().Equal(second) {
+		t.Fatalf("since之后的记录不正确: %v", resp.Runs)
+	}
+	got := resp.Runs[0].Results
+	if len(got) != 2 || got[0].Host != "a.example.com" || !got[0].Ok || got[0].Time.AsDuration() != 200*time.Millisecond ||
+		got[1].Host != "b.example.com" || got[1].Ok || got[1].Time.AsDuration() != 0 {
+		t.Errorf("记录中的结果不正确: %v", got)
+	}
+
+	if resp, err := client.GetHistory(ctx, &api.GetHistoryRequest{}); err != nil || len(resp.Runs) != 2 {
+		t.Errorf("不指定since时应返回全部记录: %v, %v", resp, err)
+	}
+}
+
+func TestCheckServerApplyConfig(t *testing.T) {
+	client, server := startTestCheckServer(t, testAgentConfig(t))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "daemon.json")
+	existing := `{"registry-mirrors": ["https://old.example.com"], "log-driver": "json-file"}`
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var runtime string
+	server.selectTarget = func(name string) (dockerTarget, error) {
+		runtime = name
+		// Binary为true时跳过Docker是否安装的检查，没有Reload时写入后不重新加载
+		return dockerTarget{Name: "Docker", ConfigPath: path, Binary: "true"}, nil
+	}
+	server.setResults([]CheckResult{
+		{Host: "a.example.com", Available: true, Time: 300 * time.Millisecond},
+		{Host: "b.example.com", Available: true, Time: 100 * time.Millisecond},
+		{Host: "c.example.com", StatusCode: 502, Time: 50 * time.Millisecond},
+	})
+
+	// dry_run 只返回差异，不修改文件
+	resp, err := client.ApplyConfig(ctx, &api.ApplyConfigRequest{Count: 2, Runtime: "docker", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://b.example.com", "https://a.example.com"}
+	if !reflect.DeepEqual(resp.Mirrors, want) || resp.ConfigPath != path || runtime != "docker" {
+		t.Fatalf("ApplyConfig = %v，期望写入 %v", resp, want)
+	}
+	if !strings.Contains(resp.Diff, "+") || !strings.Contains(resp.Diff, "b.example.com") || !strings.Contains(resp.Diff, "old.example.com") {
+		t.Errorf("差异不正确:\n%s", resp.Diff)
+	}
+	if data, _ := os.ReadFile(path); string(data) != existing {
+		t.Fatalf("dry_run 修改了配置文件: %s", data)
+	}
+
+	// 不指定数量时与 -apply fastest 相同，写入最快的1个
+	resp, err = client.ApplyConfig(ctx, &api.ApplyConfigRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Mirrors, want[:1]) || resp.Diff != "" {
+		t.Fatalf("ApplyConfig = %v，期望写入 %v", resp, want[:1])
+	}
+	config, err := readDaemonConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.RegistryMirrors, want[:1]) {
+		t.Errorf("registry-mirrors = %v，期望 %v", config.RegistryMirrors, want[:1])
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"log-driver"`) {
+		t.Errorf("原配置中的其他字段丢失: %s", data)
+	}
+}
+
+func TestRunTracker(t *testing.T) {
+	tracker := newRunTracker()
+	id := tracker.begin(time.Now())
+	progress, ok := tracker.progress(id, 0)
+	if !ok || progress.Done || len(progress.Results) != 0 {
+		t.Fatalf("新的检测进度不正确: %+v", progress)
+	}
+
+	tracker.add(id, CheckResult{Host: "a.example.com"})
+	select {
+	case <-progress.Changed:
+	default:
+		t.Fatal("有新结果时没有通知")
+	}
+	tracker.add(id, CheckResult{Host: "b.example.com"})
+	tracker.finish(id, nil)
+	// 结束后的结果不再记录
+	tracker.add(id, CheckResult{Host: "c.example.com"})
+	if progress, _ := tracker.progress(id, 1); !progress.Done || len(progress.Results) != 1 || progress.Results[0].Host != "b.example.com" {
+		t.Errorf("从第2个结果开始的进度不正确: %+v", progress)
+	}
+
+	// 只保留最近 maxTrackedRuns 轮
+	for i := 0; i < maxTrackedRuns; i++ {
+		tracker.begin(time.Now())
+	}
+	if _, ok := tracker.progress(id, 0); ok {
+		t.Error("超出保留范围的检测仍然可以查询")
+	}
+
+	// 没有开启gRPC接口时tracker为nil
+	var disabled *runTracker
+	disabled.add(disabled.begin(time.Now()), CheckResult{})
+	disabled.finish("", nil)
+}
+
+func TestAgentDrainAdhoc(t *testing.T) {
+	config := testAgentConfig(t)
+	config.Textfile = filepath.Join(t.TempDir(), "mirrors.prom")
+	logger := log.New(io.Discard, "", 0)
+	results := []CheckResult{{Host: "a.example.com", Available: true, Time: 100 * time.Millisecond}}
+
+	for _, adhoc := range []bool{true, false} {
+		state := &agentState{}
+		done := make(chan agentRunOutcome, 1)
+		done <- agentRunOutcome{Start: time.Now(), Results: results, Generation: 1, Adhoc: adhoc}
+		agentDrain(logger, config, state, done, make(chan os.Signal), func() {})
+
+		_, err := os.Stat(config.History)
+		if adhoc && (state.Runs != 0 || state.Results != nil || !os.IsNotExist(err)) {
+			t.Errorf("退出时完成的指定镜像源的检测更新了状态或历史记录: %+v, %v", state, err)
+		}
+		if !adhoc && (state.Runs != 1 || err != nil) {
+			t.Errorf("退出时完成的检测没有保存: %+v, %v", state, err)
+		}
+	}
+}
+
+func TestGRPCListenAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		local   bool
+	}{
+		{addr: "unix:/run/agent.sock", network: "unix", address: "/run/agent.sock", local: true},
+		{addr: "unix:///run/agent.sock", network: "unix", address: "/run/agent.sock", local: true},
+		{addr: ":50051", network: "tcp", address: "127.0.0.1:50051", local: true},
+		{addr: "[::1]:50051", network: "tcp", address: "[::1]:50051", local: true},
+		{addr: "localhost:50051", network: "tcp", address: "localhost:50051", local: true},
+		{addr: "0.0.0.0:50051", network: "tcp", address: "0.0.0.0:50051"},
+		{addr: "192.168.1.10:50051", network: "tcp", address: "192.168.1.10:50051"},
+	}
+	for _, tt := range tests {
+		network, address, local, err := grpcOptions{Addr: tt.addr}.listenAddress()
+		if err != nil || network != tt.network || address != tt.address || local != tt.local {
+			t.Errorf("%s: %s %s %v %v，期望 %s %s %v", tt.addr, network, address, local, err, tt.network, tt.address, tt.local)
+		}
+	}
+	for _, addr := range []string{"unix:", "50051"} {
+		if _, _, _, err := (grpcOptions{Addr: addr}).listenAddress(); err == nil {
+			t.Errorf("%s: 应返回错误", addr)
+		}
+	}
+}
+
+// 写入 127.0.0.1 的自签名证书，返回证书和私钥的路径
+func writeTestCert(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "docker-registry-checker"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+// 带令牌的请求
+type testToken string
+
+func (t testToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (testToken) RequireTransportSecurity() bool { return false }
+
+func TestServeGRPC(t *testing.T) {
+	config := testAgentConfig(t)
+	config.History = ""
+	server := newCheckServer(config, newRunTracker(), make(chan agentRunRequest))
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+
+	// 监听非本机地址时必须设置令牌
+	if _, _, err := serveGRPC(grpcOptions{Addr: "0.0.0.0:0"}, server, logger); err == nil || !strings.Contains(err.Error(), "令牌") {
+		t.Fatalf("没有令牌时应拒绝监听非本机地址，得到 %v", err)
+	}
+	if _, _, err := serveGRPC(grpcOptions{Addr: "127.0.0.1:0", CertFile: "cert.pem"}, server, logger); err == nil {
+		t.Error("只指定证书时应返回错误")
+	}
+
+	// 令牌认证和TLS，没有设置 history 的 GetHistory 返回 FailedPrecondition 表示请求到达了服务
+	certFile, keyFile, pool := writeTestCert(t)
+	grpcServer, addr, err := serveGRPC(grpcOptions{Addr: "127.0.0.1:0", Token: "s3cret", CertFile: certFile, KeyFile: keyFile}, server, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grpcServer.Stop()
+	creds := credentials.NewClientTLSFromCert(pool, "")
+
+	tests := []struct {
+		name  string
+		creds credentials.TransportCredentials
+		token string
+		want  codes.Code
+	}{
+		{name: "没有令牌", creds: creds, want: codes.Unauthenticated},
+		{name: "错误的令牌", creds: creds, token: "wrong", want: codes.Unauthenticated},
+		{name: "正确的令牌", creds: creds, token: "s3cret", want: codes.FailedPrecondition},
+		{name: "没有使用TLS", creds: insecure.NewCredentials(), token: "s3cret", want: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		var opts []grpc.DialOption
+		if tt.token != "" {
+			opts = append(opts, grpc.WithPerRPCCredentials(testToken(tt.token)))
+		}
+		conn := dialTestGRPC(t, addr.String(), tt.creds, opts...)
+		client := api.NewCheckServiceClient(conn)
+		if _, err := client.GetHistory(ctx, &api.GetHistoryRequest{}); status.Code(err) != tt.want {
+			t.Errorf("%s: GetHistory 期望 %v，得到 %v", tt.name, tt.want, err)
+		}
+		// 流式接口同样需要认证
+		if tt.want == codes.Unauthenticated {
+			if _, err := streamAll(t, client, "unknown"); status.Code(err) != codes.Unauthenticated {
+				t.Errorf("%s: StreamResults 期望 Unauthenticated，得到 %v", tt.name, err)
+			}
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 一轮检测超过该时间仍未结束，或者超过该时间没有开始下一轮时，/healthz 报告不健康
+//
+// 取检测间隔的2倍，至少5分钟，避免列表很大、一轮检测本来就很慢时被误判。
+func watchStallTimeout(interval time.Duration) time.Duration {
+	timeout := 2 * interval
+	if timeout < 5*time.Minute {
+		timeout = 5 * time.Minute
+	}
+	return timeout
+}
+
+// watch 的调度状态，检测循环更新，/healthz 和 /readyz 并发读取
+type watchScheduler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	started  time.Time
+	// 正在进行的一轮检测开始的时间，没有进行中的检测时为零值
+	roundStart time.Time
+	nextRun    time.Time
+	rounds     int
+	lastRun    time.Time
+	// 最近一轮检测失败的原因 (如读取列表失败)，成功时为空
+	lastErr string
+}
+
+func newWatchScheduler(interval time.Duration) *watchScheduler {
+	now := time.Now()
+	return &watchScheduler{interval: interval, started: now, nextRun: now}
+}
+
+// 一轮检测开始
+func (s *watchScheduler) begin(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roundStart = now
+}
+
+// 一轮检测结束，err为nil表示成功完成；被取消的检测不计入
+func (s *watchScheduler) end(now, next time.Time, err error, cancelled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roundStart = time.Time{}
+	s.nextRun = next
+	if cancelled {
+		return
+	}
+	if err != nil {
+		s.lastErr = err.Error()
+		return
+	}
+	s.rounds++
+	s.lastRun = now
+	s.lastErr = ""
+}
+
+// 调度状态，同时作为两个接口的响应
+type watchSchedulerStatus struct {
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+	Started   time.Time  `json:"started"`
+	Running   bool       `json:"running"`
+	Rounds    int        `json:"rounds"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// 检测循环是否还在正常运转，不健康时返回原因
+func (s *watchScheduler) liveness(now time.Time) (watchSchedulerStatus, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status()
+	stall := watchStallTimeout(s.interval)
+	if !s.roundStart.IsZero() && now.Sub(s.roundStart) > stall {
+		return status, fmt.Sprintf("本轮检测已进行 %s，超过 %s 仍未结束", now.Sub(s.roundStart).Round(time.Second), stall)
+	}
+	if s.roundStart.IsZero() && now.Sub(s.nextRun) > stall {
+		return status, fmt.Sprintf("下一轮检测应在 %s 开始，已超过 %s", s.nextRun.Format(time.RFC3339), stall)
+	}
+	return status, ""
+}
+
+// 是否已经有可用的检测结果，未就绪时返回原因
+func (s *watchScheduler) readiness() (watchSchedulerStatus, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status()
+	switch {
+	case s.lastErr != "":
+		return status, "最近一轮检测失败: " + s.lastErr
+	case s.rounds == 0:
+		return status, "第一轮检测尚未完成"
+	}
+	return status, ""
+}
+
+// 调用时持有锁
+func (s *watchScheduler) status() watchSchedulerStatus {
+	status := watchSchedulerStatus{
+		Started:   s.started,
+		Running:   !s.roundStart.IsZero(),
+		Rounds:    s.rounds,
+		LastError: s.lastErr,
+	}
+	if !s.lastRun.IsZero() {
+		lastRun := s.lastRun
+		status.LastRun = &lastRun
+	}
+	if !status.Running {
+		nextRun := s.nextRun
+		status.NextRun = &nextRun
+	}
+	return status
+}
+
+// /healthz (存活) 和 /readyz (就绪) 接口，正常时返回200，否则返回503，响应为JSON格式的调度状态
+func (s *watchScheduler) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status, reason := s.liveness(time.Now())
+		writeSchedulerStatus(w, status, reason)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, reason := s.readiness()
+		writeSchedulerStatus(w, status, reason)
+	})
+	return mux
+}
+
+func writeSchedulerStatus(w http.ResponseWriter, status watchSchedulerStatus, reason string) {
+	code := http.StatusOK
+	status.Status = "ok"
+	if reason != "" {
+		code = http.StatusServiceUnavailable
+		status.Status, status.Reason = "unavailable", reason
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// 在addr上提供健康检查接口，ctx取消时关闭
+//
+// 监听失败 (如端口被占用) 时直接返回错误，不在后台静默失败。
+func serveHealth(ctx context.Context, addr string, scheduler *watchScheduler, logger *log.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("监听 %s 失败: %v", addr, err)
+	}
+	server := &http.Server{Handler: scheduler.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Printf("健康检查接口异常退出: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	logger.Printf("健康检查接口: http://%s/healthz 和 /readyz", listener.Addr())
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// healthcheck 的退出码
+const (
+	healthOK        = 0
+	healthUnhealthy = 1
+	healthError     = 2
+)
+
+// healthcheck 子命令：检测本机配置的镜像源是否仍然可用，
+// 用作容器的 HEALTHCHECK 或 livenessProbe，只输出一行结果
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	configPath := fs.String("config", "", "读取镜像源的daemon.json路径 (默认为当前使用的Docker的配置)")
+	timeout := fs.Duration("timeout", 5*time.Second, "每个镜像源的超时时间")
+	all := fs.Bool("all", false, "所有镜像源都可用才算健康 (默认只要有一个可用)")
+	quiet := fs.Bool("q", false, "不输出任何内容，只返回退出码")
+	var mirrors stringsFlag
+	fs.Var(&mirrors, "mirror", "要检测的镜像源，可重复指定，指定后不读取daemon.json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker healthcheck [参数]")
+		fmt.Fprintln(fs.Output(), "退出码: 0 健康, 1 不健康, 2 配置错误")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	report := func(code int, format string, a ...interface{}) int {
+		if !*quiet {
+			fmt.Printf(format+"\n", a...)
+		}
+		return code
+	}
+
+	if len(mirrors) == 0 {
+		var config *DaemonConfig
+		var err error
+		if *configPath != "" {
+			config, err = readDaemonConfigFile(*configPath)
+		} else {
+			config, err = detectDockerTarget().readConfig()
+		}
+		if err != nil {
+			return report(healthError, "error: %v", err)
+		}
+		mirrors = config.RegistryMirrors
+	}
+	if len(mirrors) == 0 {
+		return report(healthError, "error: 没有配置镜像源")
+	}
+
+	entries := make([]listEntry, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		entries = append(entries, listEntry{Host: mirrorHost(mirror), Insecure: strings.HasPrefix(mirror, "http://"), Upstream: defaultUpstream})
+	}
+
+	criteria, _ := newSuccessCriteria("", "")
+	opts := checkOptions{Timeout: *timeout, Method: "GET", ProbePath: "/v2/", Criteria: criteria}
+	results := checkAll(context.Background(), entries, len(entries), opts, nil)
+
+	var failed []string
+	for _, result := range results {
+		if !isSuccess(result) {
+			failed = append(failed, result.Host)
+		}
+	}
+	ok := len(results) - len(failed)
+
+	if ok == 0 || (*all && len(failed) > 0) {
+		return report(healthUnhealthy, "unhealthy: %d/%d mirrors ok, failed: %s", ok, len(results), strings.Join(failed, ","))
+	}
+	return report(healthOK, "healthy: %d/%d mirrors ok", ok, len(results))
+}
+
+// 从镜像源地址中取出host，如 https://mirror.example.com/ -> mirror.example.com
+func mirrorHost(mirror string) string {
+	if u, err := url.Parse(mirror); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(mirror, "/")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 至少完成这么多次探测后才开始对冲，样本太少时p95不可靠
+const hedgeMinSamples = 10
+
+// 计算p95时只使用最近的这么多次探测，列表很长时网络状况可能已经变化
+const hedgeWindow = 200
+
+// 对冲请求: 探测超过已完成探测的p95响应时间仍未返回时，再发送一次相同的探测，使用先返回的结果
+//
+// 少数很慢的镜像源往往决定了整轮检测的总耗时，其中很多只是第一次连接碰上了丢包或慢节点，
+// 再发一次通常很快就能返回。nil表示不对冲。
+type hedger struct {
+	mu sync.Mutex
+	// 最近完成的探测的响应时间，环形缓冲区
+	samples []time.Duration
+	pos     int
+}
+
+func newHedger() *hedger {
+	return &hedger{}
+}
+
+// 记录一次完成的探测的响应时间
+func (h *hedger) record(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeWindow {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.pos] = d
+	h.pos = (h.pos + 1) % hedgeWindow
+}
+
+// 发送对冲请求前等待的时间，样本不足时返回0 (不对冲)
+func (h *hedger) delay() time.Duration {
+	h.mu.Lock()
+	sorted := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+	if len(sorted) < hedgeMinSamples {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)*95/100]
+}
+
+type hedgeAttempt struct {
+	result CheckResult
+	resp   *http.Response
+	// 是否是对冲发出的第二次探测
+	hedged bool
+}
+
+// 发送一次探测，开启对冲时超过p95仍未返回则再发送一次
+//
+// 两次探测中先收到响应的为准，另一次随即取消；两次都失败时返回第一次探测的结果。
+// 对冲的探测胜出时，响应时间从第一次探测开始计算，包括发送对冲请求前已经等待的时间，
+// 否则排序时会把实际要等更久的镜像源排到前面。
+func probeHedged(ctx context.Context, client *http.Client, host, url string, opts checkOptions) (CheckResult, *http.Response) {
+	h := opts.Hedge
+	if h == nil {
+		return probeHost(ctx, client, host, url, opts)
+	}
+	delay := h.delay()
+	if delay <= 0 {
+		result, resp := probeHost(ctx, client, host, url, opts)
+		if resp != nil {
+			h.record(result.Time)
+		}
+		return result, resp
+	}
+
+	// 缓冲区可以容纳两次探测的结果，落后的探测返回时不会阻塞
+	attempts := make(chan hedgeAttempt, 2)
+	start := time.Now()
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	defer cancelPrimary()
+	go func() {
+		result, resp := probeHost(primaryCtx, client, host, url, opts)
+		attempts <- hedgeAttempt{result: result, resp: resp}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var first hedgeAttempt
+	select {
+	case first = <-attempts:
+		if first.resp != nil {
+			h.record(first.result.Time)
+		}
+		return first.result, first.resp
+	case <-timer.C:
+	case <-ctx.Done():
+		first = <-attempts
+		return first.result, first.resp
+	}
+
+	hedgeCtx, cancelHedge := context.WithCancel(ctx)
+	defer cancelHedge()
+	go func() {
+		// 对冲请求同样受 -rate 限制
+		if !opts.Limiter.wait(hedgeCtx) {
+			attempts <- hedgeAttempt{result: CheckResult{Host: host, Error: hedgeCtx.Err().Error()}, hedged: true}
+			return
+		}
+		result, resp := probeHost(hedgeCtx, client, host, url, opts)
+		attempts <- hedgeAttempt{result: result, resp: resp, hedged: true}
+	}()
+
+	var primary hedgeAttempt
+	for i := 0; i < 2; i++ {
+		attempt := <-attempts
+		if attempt.resp != nil {
+			// 取消仍在进行的另一次探测
+			if attempt.hedged {
+				cancelPrimary()
+			} else {
+				cancelHedge()
+			}
+			// p95只统计单次探测的响应时间
+			h.record(attempt.result.Time)
+			attempt.result.Hedged = attempt.hedged
+			if attempt.hedged {
+				attempt.result.Time = time.Since(start)
+			}
+			return attempt.result, attempt.resp
+		}
+		if !attempt.hedged {
+			primary = attempt
+		}
+	}
+	return primary.result, primary.resp
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// 历史记录中的一次检测，每行一个JSON对象 (JSON Lines)，只保留汇总需要的字段
+type historyRun struct {
+	Time    time.Time       `json:"time"`
+	Results []historyResult `json:"results"`
+}
+
+type historyResult struct {
+	Host string        `json:"host"`
+	OK   bool          `json:"ok"`
+	Time time.Duration `json:"time,omitempty"`
+}
+
+// 将一次检测的结果追加到历史记录文件
+func appendHistory(path string, runAt time.Time, results []CheckResult) error {
+	run := historyRun{Time: runAt, Results: make([]historyResult, 0, len(results))}
+	for _, result := range results {
+		entry := historyResult{Host: result.Host, OK: isSuccess(result)}
+		if entry.OK {
+			entry.Time = result.Time
+		}
+		run.Results = append(run.Results, entry)
+	}
+
+	line, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("序列化历史记录失败: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开历史记录失败: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入历史记录失败: %v", err)
+	}
+	return file.Close()
+}
+
+// 读取since之后的历史记录，按时间排列
+func readHistory(path string, since time.Time) ([]historyRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开历史记录失败: %v", err)
+	}
+	defer file.Close()
+
+	var runs []historyRun
+	scanner := bufio.NewScanner(file)
+	// 列表很大时单行可能超过默认的64KB
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var run historyRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("%s:%d: 解析历史记录失败: %v", path, lineNo, err)
+		}
+		if !run.Time.Before(since) {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取历史记录失败: %v", err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// 黑名单、置顶列表这类每行一个host的文件，使用列表文件的格式，# 之后为原因和加入日期
+type hostFile struct {
+	Path string
+	// 在提示中显示的名称，如 黑名单
+	Name string
+	// 新建文件时写在第一行的说明
+	Header string
+	// 指定文件的参数名，子命令和检测时相同
+	Flag string
+}
+
+// 读取文件中的条目，文件不存在时返回空
+func (f hostFile) load() ([]listEntry, error) {
+	if !fileExists(f.Path) {
+		return nil, nil
+	}
+	entries, err := readList(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("读取%s失败: %v", f.Name, err)
+	}
+	return entries, nil
+}
+
+// 把host追加到文件末尾，已有的host不重复添加
+func (f hostFile) add(hosts []string, reason string) error {
+	entries, err := f.load()
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, host := range hosts {
+		if containsHost(entries, host) {
+			fmt.Printf("%s 已在%s中\n", host, f.Name)
+			continue
+		}
+		comment := time.Now().Format("2006-01-02")
+		if reason != "" {
+			comment = reason + " " + comment
+		}
+		fmt.Fprintf(&b, "%s  # %s\n", host, comment)
+		fmt.Printf("已将 %s 加入%s\n", host, f.Name)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("读取%s失败: %v", f.Name, err)
+	}
+	if len(data) == 0 {
+		data = []byte("# " + f.Header + "\n")
+	} else if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if err := atomicWriteFile(f.Path, append(data, b.String()...), nil); err != nil {
+		return fmt.Errorf("写入%s失败: %v", f.Name, err)
+	}
+	return nil
+}
+
+// 从文件中删除host，保留文件中的其他内容
+func (f hostFile) remove(hosts []string) error {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s %s 不存在", f.Name, f.Path)
+		}
+		return fmt.Errorf("读取%s失败: %v", f.Name, err)
+	}
+
+	var b strings.Builder
+	var removed []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		content, _, _ := strings.Cut(line, "#")
+		if fields := strings.Fields(content); len(fields) > 0 {
+			if host, _, err := normalizeListHost(fields[0]); err == nil && containsString(hosts, host) {
+				removed = append(removed, host)
+				continue
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取%s失败: %v", f.Name, err)
+	}
+
+	for _, host := range hosts {
+		if containsString(removed, host) {
+			fmt.Printf("已将 %s 移出%s\n", host, f.Name)
+		} else {
+			fmt.Printf("%s 不在%s中\n", host, f.Name)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := atomicWriteFile(f.Path, []byte(b.String()), nil); err != nil {
+		return fmt.Errorf("写入%s失败: %v", f.Name, err)
+	}
+	return nil
+}
+
+func (f hostFile) print() error {
+	entries, err := f.load()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("%s %s 为空\n", f.Name, f.Path)
+		return nil
+	}
+	fmt.Printf("%s (%s):\n", f.Name, f.Path)
+	for _, entry := range entries {
+		if entry.Comment != "" {
+			fmt.Printf("  %s  # %s\n", entry.Host, entry.Comment)
+		} else {
+			fmt.Printf("  %s\n", entry.Host)
+		}
+	}
+	return nil
+}
+
+func containsHost(entries []listEntry, host string) bool {
+	for _, entry := range entries {
+		if entry.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// 添加host的子命令 (block、pin)，不指定host时列出文件内容
+func runHostFileAdd(command string, file hostFile, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&file.Path, file.Flag, file.Path, file.Name+"文件")
+	reason := fs.String("reason", "", "原因，记录在host后面的注释中")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: docker-registry-checker %s [参数] [host...]\n", command)
+		fmt.Fprintf(fs.Output(), "不指定host时列出%s\n", file.Name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return file.print()
+	}
+	hosts, err := normalizeHostArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+	return file.add(hosts, *reason)
+}
+
+// 删除host的子命令 (unblock、unpin)
+func runHostFileRemove(command string, file hostFile, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&file.Path, file.Flag, file.Path, file.Name+"文件")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: docker-registry-checker %s [参数] host...\n", command)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("请指定要移出%s的host", file.Name)
+	}
+
+	hosts, err := normalizeHostArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+	return file.remove(hosts)
+}
+
+// 规范化命令行中的host，与列表文件中的写法一致
+func normalizeHostArgs(args []string) ([]string, error) {
+	var hosts []string
+	for _, arg := range args {
+		host, _, err := normalizeListHost(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
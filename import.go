@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 从其他工具的配置中导入镜像源作为检测列表，用于 -import
+//
+// 支持:
+//   - daemon.json 中的 registry-mirrors
+//   - containerd 的 certs.d 目录 (每个子目录为一个上游，其中的 hosts.toml) 或单个 hosts.toml
+//   - K3s/RKE2 的 registries.yaml
+//   - containers (Podman/CRI-O) 的 registries.conf
+//
+// 上游不在支持范围内的镜像源会被跳过。
+func importMirrors(path string) ([]listEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 %s: %v", path, err)
+	}
+
+	var mirrors map[string][]string
+	switch name := filepath.Base(path); {
+	case info.IsDir():
+		mirrors, err = importCertsDir(path)
+	case name == "hosts.toml":
+		mirrors, err = importHostsTOML(path)
+	case strings.HasSuffix(name, ".json"):
+		var config *DaemonConfig
+		if config, err = readDaemonConfigFile(path); err == nil {
+			mirrors = map[string][]string{defaultUpstream: config.RegistryMirrors}
+		}
+	case strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml"):
+		mirrors, err = importRegistriesYAML(path)
+	case strings.HasSuffix(name, ".conf"):
+		mirrors, err = importRegistriesConf(path)
+	default:
+		return nil, fmt.Errorf("无法识别 %s 的格式 (支持 daemon.json、certs.d目录、hosts.toml、registries.yaml、registries.conf)", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := make([]string, 0, len(mirrors))
+	for upstream := range mirrors {
+		upstreams = append(upstreams, upstream)
+	}
+	sort.Strings(upstreams)
+
+	var entries []listEntry
+	for _, name := range upstreams {
+		upstream, err := normalizeUpstream(name)
+		if err != nil {
+			fmt.Fprintf(infoOut, "跳过 %s 的镜像源: %v\n", name, err)
+			continue
+		}
+		for i, mirror := range mirrors[name] {
+			entries = append(entries, listEntry{Host: mirrorHost(mirror), Insecure: strings.HasPrefix(mirror, "http://"), Upstream: upstream, Source: path, Line: i + 1})
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s 中没有找到镜像源", path)
+	}
+	return entries, nil
+}
+
+// containerd 的 certs.d 目录，子目录名为上游，如 certs.d/docker.io/hosts.toml
+func importCertsDir(dir string) (map[string][]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "hosts.toml"))
+	if err != nil {
+		return nil, err
+	}
+	mirrors := map[string][]string{}
+	for _, file := range files {
+		imported, err := importHostsTOML(file)
+		if err != nil {
+			return nil, err
+		}
+		for upstream, hosts := range imported {
+			mirrors[upstream] = append(mirrors[upstream], hosts...)
+		}
+	}
+	return mirrors, nil
+}
+
+// 单个hosts.toml，上游取所在目录名 (_default 视为docker.io)
+func importHostsTOML(path string) (map[string][]string, error) {
+	config, err := readHostsTOML(path)
+	if err != nil {
+		return nil, err
+	}
+	upstream := filepath.Base(filepath.Dir(path))
+	if upstream == "_default" {
+		upstream = defaultUpstream
+	}
+	return map[string][]string{upstream: config.RegistryMirrors}, nil
+}
+
+// registries.yaml 中所有仓库的镜像源，"*" 视为docker.io
+func importRegistriesYAML(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取registries.yaml失败: %v", err)
+	}
+	var registries struct {
+		Mirrors map[string]struct {
+			Endpoint []string `yaml:"endpoint"`
+		} `yaml:"mirrors"`
+	}
+	if err := yaml.Unmarshal(data, &registries); err != nil {
+		return nil, fmt.Errorf("解析registries.yaml失败: %v", err)
+	}
+
+	mirrors := map[string][]string{}
+	for name, registry := range registries.Mirrors {
+		if name == "*" {
+			name = defaultUpstream
+		}
+		mirrors[name] = append(mirrors[name], registry.Endpoint...)
+	}
+	return mirrors, nil
+}
+
+// containers-registries.conf (v2格式) 中的 [[registry.mirror]]:
+//
+//	[[registry]]
+//	prefix = "docker.io"
+//	location = "registry-1.docker.io"
+//
+//	[[registry.mirror]]
+//	location = "mirror.example.com"
+func importRegistriesConf(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取registries.conf失败: %v", err)
+	}
+
+	mirrors := map[string][]string{}
+	var section, prefix, location string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[] ")
+			if section == "registry" {
+				prefix, location = "", ""
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value, err := strconv.Unquote(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch {
+		case section == "registry" && key == "prefix":
+			prefix = value
+		case section == "registry" && key == "location":
+			location = value
+		case section == "registry.mirror" && key == "location":
+			upstream := prefix
+			if upstream == "" {
+				upstream = location
+			}
+			mirrors[upstream] = append(mirrors[upstream], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取registries.conf失败: %v", err)
+	}
+	return mirrors, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Docker Hub 的registry地址，作为内容比对的基准
+const dockerHubRegistry = "registry-1.docker.io"
+
+// 比对时读取blob的最大字节数，基准镜像应选择层较小的镜像
+const maxIntegrityBlobSize = 64 << 20
+
+// 内容一致性比对结果
+type IntegrityResult struct {
+	// 比对的blob
+	Digest string `json:"digest" yaml:"digest"`
+	// 是否成功取到镜像源返回的内容并完成比对
+	Compared bool `json:"compared" yaml:"compared"`
+	// 镜像源返回的内容与Docker Hub是否一致
+	Match bool   `json:"match" yaml:"match"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// 从Docker Hub直接取得的基准blob
+type integrityReference struct {
+	Repo   string
+	Digest string
+	// 内容的sha256
+	Sum string
+}
+
+// 从Docker Hub取得镜像中最小的一层作为比对基准
+//
+// 按digest而不是tag比对，镜像源缓存的tag比Docker Hub旧时也不会误判。
+func fetchIntegrityReference(ctx context.Context, client *http.Client, image string) (*integrityReference, error) {
+	repo, ref := splitImageRef(image)
+	base := "https://" + dockerHubRegistry + "/v2/" + repo
+
+	accept := strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerV2}, ", ")
+	var manifest struct {
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+		Layers []struct {
+			Digest string `json:"digest"`
+			Size   int64  `json:"size"`
+		} `json:"layers"`
+	}
+	if err := getJSON(ctx, client, base+"/manifests/"+ref, accept, &manifest); err != nil {
+		return nil, fmt.Errorf("获取 %s 的清单失败: %v", image, err)
+	}
+
+	// 多架构镜像取linux/amd64的清单
+	if len(manifest.Manifests) > 0 {
+		digest := manifest.Manifests[0].Digest
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				digest = m.Digest
+				break
+			}
+		}
+		if err := getJSON(ctx, client, base+"/manifests/"+digest, accept, &manifest); err != nil {
+			return nil, fmt.Errorf("获取 %s 的清单失败: %v", image, err)
+		}
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("%s 的清单中没有镜像层", image)
+	}
+
+	smallest := manifest.Layers[0]
+	for _, layer := range manifest.Layers[1:] {
+		if layer.Size < smallest.Size {
+			smallest = layer
+		}
+	}
+
+	sum, err := fetchBlobSum(ctx, client, base, smallest.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("从Docker Hub获取 %s 失败: %v", smallest.Digest, err)
+	}
+	if "sha256:"+sum != smallest.Digest {
+		return nil, fmt.Errorf("Docker Hub返回的 %s 校验失败", smallest.Digest)
+	}
+	return &integrityReference{Repo: repo, Digest: smallest.Digest, Sum: sum}, nil
+}
+
+// 通过镜像源获取同一个blob，与Docker Hub的内容比对，base为镜像源地址
+func probeIntegrity(ctx context.Context, client *http.Client, base string, reference *integrityReference) *IntegrityResult {
+	result := &IntegrityResult{Digest: reference.Digest}
+
+	sum, err := fetchBlobSum(ctx, client, base+"/v2/"+reference.Repo, reference.Digest)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Compared = true
+	result.Match = sum == reference.Sum
+	if !result.Match {
+		result.Error = fmt.Sprintf("内容与Docker Hub不一致 (sha256:%s)", sum)
+	}
+	return result
+}
+
+// 下载blob并计算sha256
+func fetchBlobSum(ctx context.Context, client *http.Client, base, digest string) (string, error) {
+	resp, err := registryGet(ctx, client, base+"/blobs/"+digest, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("获取blob失败，状态码: %d", resp.StatusCode)
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(resp.Body, maxIntegrityBlobSize+1))
+	if err != nil {
+		return "", fmt.Errorf("读取blob失败: %v", err)
+	}
+	if n > maxIntegrityBlobSize {
+		return "", fmt.Errorf("blob超过%dMB", maxIntegrityBlobSize>>20)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, rawURL, accept string, v interface{}) error {
+	resp, err := registryGet(ctx, client, rawURL, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
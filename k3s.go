@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"gopkg.in/yaml.v3"
+)
+
+// K3s/RKE2 节点的镜像源配置，写入 registries.yaml 后需要重启服务才会生效
+func rancherTarget(dist string) dockerTarget {
+	server, agent := "k3s", "k3s-agent"
+	if dist == "rke2" {
+		server, agent = "rke2-server", "rke2-agent"
+	}
+	// 工作节点上只有agent服务
+	service := server
+	if !systemdUnitExists(server) && systemdUnitExists(agent) {
+		service = agent
+	}
+
+	restart := "systemctl restart " + service
+	return dockerTarget{
+		Name:       dist,
+		ConfigPath: "/etc/rancher/" + dist + "/registries.yaml",
+		Format:     configRegistriesYAML,
+		Binary:     dist,
+		Reload:     restart,
+		Restart:    restart,
+		NeedRoot:   true,
+	}
+}
+
+func systemdUnitExists(unit string) bool {
+	return exec.Command("systemctl", "cat", unit).Run() == nil
+}
+
+// 读取registries.yaml中docker.io的镜像源
+func readRegistriesYAML(path string) (*DaemonConfig, error) {
+	config := &DaemonConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取registries.yaml失败: %v", err)
+	}
+
+	var registries struct {
+		Mirrors map[string]struct {
+			Endpoint []string `yaml:"endpoint"`
+		} `yaml:"mirrors"`
+	}
+	if err := yaml.Unmarshal(data, &registries); err != nil {
+		return nil, fmt.Errorf("解析registries.yaml失败: %v", err)
+	}
+	config.RegistryMirrors = registries.Mirrors[defaultUpstream].Endpoint
+	return config, nil
+}
+
+// 修改registries.yaml中docker.io的endpoint，保留其他仓库的镜像和认证配置
+func renderRegistriesYAML(path string, config *DaemonConfig) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取registries.yaml失败: %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析registries.yaml失败: %v", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("registries.yaml格式不正确: %s", path)
+	}
+
+	mirrors := yamlMapValue(root, "mirrors")
+	if mirrors == nil || mirrors.Kind != yaml.MappingNode {
+		mirrors = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		yamlMapSet(root, "mirrors", mirrors)
+	}
+	registry := yamlMapValue(mirrors, defaultUpstream)
+	if registry == nil || registry.Kind != yaml.MappingNode {
+		registry = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		yamlMapSet(mirrors, defaultUpstream, registry)
+	}
+
+	endpoints := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, mirror := range config.RegistryMirrors {
+		endpoints.Content = append(endpoints.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: mirror, Style: yaml.DoubleQuotedStyle})
+	}
+	yamlMapSet(registry, "endpoint", endpoints)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("序列化registries.yaml失败: %v", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限制本轮检测下载镜像清单和镜像层的连接合计的下载速度，避免在生产机器上运行时 (特别是 -integrity 下载镜像层时) 占满出口带宽
+//
+// nil表示不限制。
+type bandwidthLimiter struct {
+	mu sync.Mutex
+	// 每秒字节数
+	rate float64
+	// 已读取的数据按限速折算后，下一次读取最早可以开始的时间
+	next time.Time
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(rate)}
+}
+
+// 单次读取的最大字节数，使限速更平滑
+func (l *bandwidthLimiter) chunk() int {
+	n := int(l.rate / 10)
+	if n < 1024 {
+		n = 1024
+	}
+	if n > 32*1024 {
+		n = 32 * 1024
+	}
+	return n
+}
+
+// 读取n字节后调用，超过限速时等待，ctx取消时立即返回错误
+func (l *bandwidthLimiter) consume(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 限制本轮检测同时打开的连接数，nil表示不限制
+type connLimiter struct {
+	slots chan struct{}
+	// 连接数已满时调用，关闭连接池中空闲的连接以腾出名额
+	closeIdle func()
+}
+
+func newConnLimiter(max int) *connLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &connLimiter{slots: make(chan struct{}, max)}
+}
+
+// 等待到可以打开新的连接，ctx取消时返回错误
+func (l *connLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	// 空闲的连接也占用名额，连接数已满时先关闭它们，否则可能一直等到空闲连接超时
+	if l.closeIdle != nil {
+		l.closeIdle()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *connLimiter) release() {
+	<-l.slots
+}
+
+// 受限速和连接数限制的连接，关闭时归还名额
+type limitedConn struct {
+	net.Conn
+	bandwidth *bandwidthLimiter
+	conns     *connLimiter
+	closeOnce sync.Once
+	// 连接关闭 (包括请求被取消时transport关闭连接) 后不再等待限速
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if c.bandwidth == nil {
+		return c.Conn.Read(p)
+	}
+	if max := c.bandwidth.chunk(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if waitErr := c.bandwidth.consume(c.ctx, n); waitErr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		if c.conns != nil {
+			c.conns.release()
+		}
+	})
+	return err
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// 为dial建立的连接加上 -max-conns 限制，bandwidth不为nil时同时限制下载速度，都没有设置时原样返回
+//
+// -max-bandwidth 只用于下载镜像清单和镜像层的连接 (深度检测和内容比对的基准)，
+// 计时的探测和TLS握手不限速，否则测出的响应时间取决于限速而不是镜像源。
+func (o checkOptions) limitDial(dial dialFunc, bandwidth *bandwidthLimiter) dialFunc {
+	if bandwidth == nil && o.Conns == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if o.Conns != nil {
+			if err := o.Conns.acquire(ctx); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			if o.Conns != nil {
+				o.Conns.release()
+			}
+			return nil, err
+		}
+		limited := &limitedConn{Conn: conn, bandwidth: bandwidth, conns: o.Conns}
+		if bandwidth != nil {
+			limited.ctx, limited.cancel = context.WithCancel(context.Background())
+		}
+		return limited, nil
+	}
+}
+
+// 解析 -max-bandwidth 的速度，如 512K、10M、1.5M (每秒字节数，按1024换算)
+func parseBandwidth(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "/S")
+	value = strings.TrimSuffix(value, "B")
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(value, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("无效的速度: %q (如 512K、10M)", s)
+	}
+	return int64(n * unit), nil
+}
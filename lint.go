@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查列表时每个域名解析的超时时间
+const lintResolveTimeout = 5 * time.Second
+
+// 列表文件中发现的一个问题
+type listProblem struct {
+	Line int
+	// 为true时是警告，不影响列表的使用
+	Warning bool
+	Msg     string
+}
+
+// list 子命令：管理列表文件，目前只有 lint
+func runList(args []string) int {
+	if len(args) == 0 || args[0] != "lint" {
+		fmt.Println("用法: docker-registry-checker list lint [参数] [列表文件]...")
+		return validateError
+	}
+	return runListLint(args[1:])
+}
+
+// list lint 子命令：检查列表文件的语法、重复的镜像源、解析到相同IP的镜像源和带有协议前缀或路径的地址，不访问镜像源
+func runListLint(args []string) int {
+	fs := flag.NewFlagSet("list lint", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "有警告时也以非0状态码退出")
+	noResolve := fs.Bool("no-resolve", false, "不解析域名，跳过相同IP的检查 (离线时使用)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker list lint [参数] [列表文件]... (默认: docker.txt)")
+		fmt.Fprintln(fs.Output(), "退出码: 0 通过, 1 有错误 (或 -strict 时有警告), 2 无法读取文件")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"docker.txt"}
+	}
+
+	code := validateOK
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			code = validateError
+			continue
+		}
+
+		problems := lintList(data, !*noResolve)
+		for _, problem := range problems {
+			level := "错误"
+			if problem.Warning {
+				level = "警告"
+			}
+			fmt.Printf("%s:%d: %s: %s\n", path, problem.Line, level, problem.Msg)
+			if (!problem.Warning || *strict) && code == validateOK {
+				code = validateInvalid
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	return code
+}
+
+// 检查列表内容，按行号排序返回发现的问题
+//
+// 与解析列表不同，遇到错误时继续检查后面的行，@include 和 @url 只检查语法，不读取引入的列表。
+// resolve为true时解析每个域名，报告无法解析和解析到相同IP的镜像源。
+func lintList(data []byte, resolve bool) []listProblem {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var problems []listProblem
+	addf := func(line int, warning bool, format string, a ...interface{}) {
+		problems = append(problems, listProblem{Line: line, Warning: warning, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	// 每个host (和上游) 第一次出现的行号
+	seen := map[string]int{}
+	hostLines := map[string]int{}
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		content, _, _ := strings.Cut(line, "#")
+		fields := strings.Fields(content)
+
+		if strings.HasPrefix(fields[0], "@") {
+			if err := lintDirective(fields); err != nil {
+				addf(lineNo, false, "%v", err)
+			}
+			continue
+		}
+
+		raw := fields[0]
+		host, insecure, err := normalizeListHost(raw)
+		if err != nil {
+			addf(lineNo, false, "%v", err)
+			continue
+		}
+		canonical := host
+		if insecure {
+			canonical = "http://" + host
+		}
+		if raw != canonical {
+			if strings.Contains(raw, "://") && !insecure {
+				addf(lineNo, true, "%q 不需要协议前缀，应写为 %s", raw, canonical)
+			} else {
+				addf(lineNo, true, "%q 应写为 %s", raw, canonical)
+			}
+		}
+
+		entry := listEntry{Host: host, Upstream: defaultUpstream}
+		for _, field := range fields[1:] {
+			if err := entry.annotate(field); err != nil {
+				addf(lineNo, false, "%v", err)
+			}
+		}
+
+		key := host + "\x00" + entry.Upstream
+		if first, ok := seen[key]; ok {
+			addf(lineNo, true, "%s 与第%d行重复", host, first)
+			continue
+		}
+		seen[key] = lineNo
+		if _, ok := hostLines[host]; !ok {
+			hostLines[host] = lineNo
+			hosts = append(hosts, host)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		addf(lineNo, false, "读取出错: %v", err)
+	}
+
+	if resolve {
+		problems = append(problems, lintSharedIPs(hosts, hostLines)...)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// 检查 @include / @url 指令的语法
+func lintDirective(fields []string) error {
+	name := fields[0]
+	if name != "@include" && name != "@url" {
+		return fmt.Errorf("未知的指令: %s", name)
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("%s 需要一个参数", name)
+	}
+	if name == "@url" {
+		u, err := url.Parse(fields[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("无效的URL: %q", fields[1])
+		}
+	}
+	return nil
+}
+
+// 解析所有域名，报告无法解析的域名和解析到相同IP的镜像源 (通常是同一个服务的多个域名，只需保留一个)
+func lintSharedIPs(hosts []string, lines map[string]int) []listProblem {
+	addrs := make([][]string, len(hosts))
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	// 限制同时进行的DNS查询数量
+	sem := make(chan struct{}, 16)
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			hostname := hostnameOf(host)
+			if ip := net.ParseIP(hostname); ip != nil {
+				addrs[i] = []string{ip.String()}
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), lintResolveTimeout)
+			defer cancel()
+			addrs[i], errs[i] = net.DefaultResolver.LookupHost(ctx, hostname)
+		}(i, host)
+	}
+	wg.Wait()
+
+	var problems []listProblem
+	// 每个IP第一次出现时所属的host
+	owner := map[string]string{}
+	for i, host := range hosts {
+		if errs[i] != nil {
+			problems = append(problems, listProblem{Line: lines[host], Warning: true, Msg: fmt.Sprintf("无法解析 %s: %v", host, errs[i])})
+			continue
+		}
+		// 与之前每个host共有的IP，按出现顺序输出
+		var others []string
+		shared := map[string][]string{}
+		for _, addr := range addrs[i] {
+			other, ok := owner[addr]
+			if !ok {
+				owner[addr] = host
+				continue
+			}
+			// 同一个域名的不同端口本来就是不同的registry
+			if hostnameOf(other) == hostnameOf(host) {
+				continue
+			}
+			if len(shared[other]) == 0 {
+				others = append(others, other)
+			}
+			shared[other] = append(shared[other], addr)
+		}
+		for _, other := range others {
+			problems = append(problems, listProblem{Line: lines[host], Warning: true,
+				Msg: fmt.Sprintf("%s 与第%d行的 %s 解析到相同的IP (%s)，可能是同一个服务", host, lines[other], other, strings.Join(shared[other], ", "))})
+		}
+	}
+	return problems
+}
+
+// 去掉host中的端口
+func hostnameOf(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 列表文件中的一个host
+type listEntry struct {
+	Host string
+	// 镜像源代理的上游，如 docker.io、ghcr.io
+	Upstream string
+	// 写成 http://host 的镜像源，通过HTTP检测 (没有TLS的内网镜像源)
+	Insecure bool
+	// 可选的标注: 所在地区 (如 cn)、提供方 (如 aliyun)、是否需要登录 (required / none)
+	Region   string
+	Provider string
+	Auth     string
+	// 行尾 # 之后的注释
+	Comment string
+	// 来源文件或URL，以及所在行号
+	Source string
+	Line   int
+}
+
+// 列表解析错误，带有来源和行号
+type listError struct {
+	Source string
+	Line   int
+	Msg    string
+}
+
+func (e *listError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Source, e.Line, e.Msg)
+}
+
+// include 的最大嵌套层数
+const maxIncludeDepth = 8
+
+// 列表解析器，支持以下指令:
+//
+//	@include other-list.txt    引入另一个列表文件 (相对路径相对于当前文件)
+//	@url https://example.com/x 引入远程列表
+//
+// host前面可以加 http:// 表示通过HTTP访问的镜像源；host后面可以添加 key=value 形式的标注:
+// upstream=ghcr.io 指定镜像源代理的上游，region、provider 和 auth 为描述信息，会记录在结果中并可以用 -filter 筛选。
+// 每行可以在host后面用 # 添加注释，会保留在结果中。
+type listParser struct {
+	client *http.Client
+	// 正在解析的来源，用于检测循环引用
+	active map[string]bool
+	// 远程列表需要通过的签名校验，为nil时不校验
+	verifier *listVerifier
+	// 没有标注 upstream= 的镜像源使用的上游，为空时为Docker Hub
+	upstream string
+}
+
+func newListParser() *listParser {
+	return &listParser{
+		client: &http.Client{Timeout: 30 * time.Second},
+		active: map[string]bool{},
+	}
+}
+
+// 内置列表在结果和来源统计中显示的名称
+const builtinListSource = "内置列表"
+
+// 读取列表文件，忽略空行和#开头的注释
+func readList(path string) ([]listEntry, error) {
+	return newListParser().parseFile(path)
+}
+
+// 依次读取多个列表，每个来源可以是文件路径或 http/https URL
+//
+// verifier不为nil时，远程列表 (包括 @url 引入的) 都需要通过签名校验。
+func readLists(sources []string, verifier *listVerifier) ([]listEntry, error) {
+	p := newListParser()
+	p.verifier = verifier
+	var entries []listEntry
+	for _, source := range sources {
+		var list []listEntry
+		var err error
+		if isListURL(source) {
+			if list, err = p.parseURL(source); err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+		} else if list, err = p.parseFile(source); err != nil {
+			return nil, err
+		}
+		entries = append(entries, list...)
+	}
+	return entries, nil
+}
+
+// 命令行参数中的host在结果和来源统计中显示的来源
+const argsListSource = "命令行参数"
+
+// 读取命令行中直接给出的host，参数为 - 时从标准输入读取列表
+//
+// 每个参数按列表文件中的一行解析，因此也可以带标注，如 "mirror.example.com region=cn"。
+func readArgEntries(args []string, stdin io.Reader) ([]listEntry, error) {
+	p := newListParser()
+	var entries []listEntry
+	var hosts []string
+	for _, arg := range args {
+		if arg != "-" {
+			hosts = append(hosts, arg)
+			continue
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("读取标准输入失败: %v", err)
+		}
+		list, err := p.parse(data, "stdin")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, list...)
+	}
+	if len(hosts) > 0 {
+		list, err := p.parse([]byte(strings.Join(hosts, "\n")), argsListSource)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, list...)
+	}
+	return entries, nil
+}
+
+func isListURL(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// 按host和上游去重，保留第一次出现的条目，避免多个列表中相同的镜像源被重复检测
+//
+// 去重后的条目用于检测，来源统计仍然使用去重前的条目，以便记录镜像源出现在哪些列表中。
+// 同一个列表中的重复条目 (如 https://Mirror.com/ 和 mirror.com) 通常是维护时的疏忽，会作为警告输出到warn，
+// 不同列表之间的重复是正常的，不输出警告。warn为nil时不输出。
+func dedupeEntries(entries []listEntry, warn io.Writer) []listEntry {
+	first := map[string]listEntry{}
+	unique := make([]listEntry, 0, len(entries))
+	for _, entry := range entries {
+		key := entry.Host + "\x00" + entry.Upstream
+		if prev, ok := first[key]; ok {
+			if warn != nil && prev.Source == entry.Source && entry.Line > 0 {
+				fmt.Fprintf(warn, "警告: %s:%d: %s 与第%d行重复，只检测一次\n", entry.Source, entry.Line, entry.Host, prev.Line)
+			}
+			continue
+		}
+		first[key] = entry
+		unique = append(unique, entry)
+	}
+	return unique
+}
+
+// 规范化列表中的镜像源地址: 去掉 https:// 和末尾的 /，host转为小写，去掉默认端口
+//
+// 写成 http:// 的返回insecure为true。镜像源只能是registry的根地址，带有路径时返回错误。
+func normalizeListHost(raw string) (host string, insecure bool, err error) {
+	host = raw
+	if scheme, rest, ok := strings.Cut(host, "://"); ok {
+		switch strings.ToLower(scheme) {
+		case "http":
+			insecure = true
+		case "https":
+		default:
+			return "", false, fmt.Errorf("不支持的协议: %q", raw)
+		}
+		host = rest
+	}
+	host = strings.ToLower(strings.TrimRight(host, "/"))
+	if host == "" {
+		return "", false, fmt.Errorf("无效的地址: %q", raw)
+	}
+	if strings.Contains(host, "/") {
+		return "", false, fmt.Errorf("镜像源地址不能包含路径: %q", raw)
+	}
+	if insecure {
+		host = strings.TrimSuffix(host, ":80")
+	} else {
+		host = strings.TrimSuffix(host, ":443")
+	}
+	return host, insecure, nil
+}
+
+func (p *listParser) parseFile(path string) ([]listEntry, error) {
+	path = filepath.Clean(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开%s文件: %v", path, err)
+	}
+	return p.parse(data, path)
+}
+
+func (p *listParser) parseURL(rawURL string) ([]listEntry, error) {
+	resp, err := p.client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("下载列表失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载列表失败，状态码: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("下载列表失败: %v", err)
+	}
+	if p.verifier != nil {
+		if _, err := p.verifier.verifyURL(p.client, rawURL, data); err != nil {
+			return nil, err
+		}
+	}
+	return p.parse(data, rawURL)
+}
+
+func (p *listParser) defaultUpstream() string {
+	if p.upstream == "" {
+		return defaultUpstream
+	}
+	return p.upstream
+}
+
+// 解析列表内容，source为文件路径或URL
+func (p *listParser) parse(data []byte, source string) ([]listEntry, error) {
+	if p.active[source] {
+		return nil, fmt.Errorf("列表存在循环引用: %s", source)
+	}
+	if len(p.active) >= maxIncludeDepth {
+		return nil, fmt.Errorf("列表嵌套层数超过%d层: %s", maxIncludeDepth, source)
+	}
+	p.active[source] = true
+	defer delete(p.active, source)
+
+	// 去掉UTF-8 BOM，Windows记事本保存的文件常带有BOM
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var entries []listEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		// TrimSpace 同时去掉CRLF换行留下的\r
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		content, comment, _ := strings.Cut(line, "#")
+		fields := strings.Fields(content)
+		comment = strings.TrimSpace(comment)
+
+		if strings.HasPrefix(fields[0], "@") {
+			included, err := p.directive(fields, source)
+			if le, ok := err.(*listError); ok {
+				// 引入的列表中的错误直接指向出错的文件和行
+				return nil, le
+			} else if err != nil {
+				return nil, &listError{Source: source, Line: lineNo, Msg: err.Error()}
+			}
+			entries = append(entries, included...)
+			continue
+		}
+
+		host, insecure, err := normalizeListHost(fields[0])
+		if err != nil {
+			return nil, &listError{Source: source, Line: lineNo, Msg: err.Error()}
+		}
+		entry := listEntry{
+			Host:     host,
+			Insecure: insecure,
+			Upstream: p.defaultUpstream(),
+			Comment:  comment,
+			Source:   source,
+			Line:     lineNo,
+		}
+		for _, field := range fields[1:] {
+			if err := entry.annotate(field); err != nil {
+				return nil, &listError{Source: source, Line: lineNo, Msg: err.Error()}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取%s出错: %v", source, err)
+	}
+	return entries, nil
+}
+
+// 处理host后面的 key=value 标注
+func (e *listEntry) annotate(field string) error {
+	key, value, ok := strings.Cut(field, "=")
+	if !ok {
+		return fmt.Errorf("无法解析的内容: %q", field)
+	}
+
+	switch key {
+	case "upstream":
+		upstream, err := normalizeUpstream(value)
+		if err != nil {
+			return err
+		}
+		e.Upstream = upstream
+	case "region", "provider":
+		if value == "" {
+			return fmt.Errorf("%s 不能为空", key)
+		}
+		if key == "region" {
+			e.Region = strings.ToLower(value)
+		} else {
+			e.Provider = strings.ToLower(value)
+		}
+	case "auth":
+		value = strings.ToLower(value)
+		if value != "required" && value != "none" {
+			return fmt.Errorf("auth 只能为 required 或 none: %q", value)
+		}
+		e.Auth = value
+	default:
+		return fmt.Errorf("未知的标注: %s", key)
+	}
+	return nil
+}
+
+// 可以用 -filter 筛选的标注
+var filterKeys = []string{"upstream", "region", "provider", "auth"}
+
+// 按标注筛选列表，如 region=cn 或 provider=aliyun,tencent (逗号分隔的值满足其一即可)
+//
+// 多个条件需要同时满足；没有该标注的条目不满足条件。
+type entryFilter map[string][]string
+
+func parseEntryFilters(specs []string) (entryFilter, error) {
+	filter := entryFilter{}
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || value == "" {
+			return nil, fmt.Errorf("无效的筛选条件: %q (应为 key=value，如 region=cn)", spec)
+		}
+		if !containsString(filterKeys, key) {
+			return nil, fmt.Errorf("不支持按 %s 筛选 (支持 %s)", key, strings.Join(filterKeys, " / "))
+		}
+		for _, v := range strings.Split(value, ",") {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if key == "upstream" {
+				upstream, err := normalizeUpstream(v)
+				if err != nil {
+					return nil, err
+				}
+				v = upstream
+			}
+			filter[key] = append(filter[key], v)
+		}
+	}
+	return filter, nil
+}
+
+func (f entryFilter) match(entry listEntry) bool {
+	values := map[string]string{
+		"upstream": entry.Upstream,
+		"region":   entry.Region,
+		"provider": entry.Provider,
+		"auth":     entry.Auth,
+	}
+	for key, allowed := range f {
+		if !containsString(allowed, values[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 返回满足筛选条件的条目
+func (f entryFilter) apply(entries []listEntry) []listEntry {
+	if len(f) == 0 {
+		return entries
+	}
+	var matched []listEntry
+	for _, entry := range entries {
+		if f.match(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// 编译 -include / -exclude 的正则表达式，name为参数名，用于错误提示
+func compileHostPatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	regexps := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s 的正则表达式无效: %v", name, err)
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+// 按host筛选条目: 指定了include时只保留匹配其中任意一个的条目，再去掉匹配任意一个exclude的条目
+func filterHosts(entries []listEntry, include, exclude []*regexp.Regexp) []listEntry {
+	matchAny := func(regexps []*regexp.Regexp, host string) bool {
+		for _, re := range regexps {
+			if re.MatchString(host) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var kept []listEntry
+	for _, entry := range entries {
+		if len(include) > 0 && !matchAny(include, entry.Host) {
+			continue
+		}
+		if matchAny(exclude, entry.Host) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// 打乱条目的顺序，n大于0时只保留前n个，用于在很大的列表中随机抽样检测
+func sampleEntries(entries []listEntry, n int, rng *rand.Rand) []listEntry {
+	sampled := append([]listEntry(nil), entries...)
+	rng.Shuffle(len(sampled), func(i, j int) { sampled[i], sampled[j] = sampled[j], sampled[i] })
+	if n > 0 && n < len(sampled) {
+		sampled = sampled[:n]
+	}
+	return sampled
+}
+
+// 只保留与kept中的镜像源相同 (host和上游) 的条目，用于抽样后同步更新来源统计使用的条目
+func keepEntries(entries, kept []listEntry) []listEntry {
+	keys := map[string]bool{}
+	for _, entry := range kept {
+		keys[entry.Host+"\x00"+entry.Upstream] = true
+	}
+	var result []listEntry
+	for _, entry := range entries {
+		if keys[entry.Host+"\x00"+entry.Upstream] {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// 处理 @include / @url 指令
+func (p *listParser) directive(fields []string, source string) ([]listEntry, error) {
+	name := fields[0]
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s 需要一个参数", name)
+	}
+	arg := fields[1]
+
+	switch name {
+	case "@include":
+		// 远程列表中的相对路径相对于该URL解析
+		if base, err := url.Parse(source); err == nil && (base.Scheme == "http" || base.Scheme == "https") {
+			ref, err := url.Parse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("无效的路径: %v", err)
+			}
+			return p.parseURL(base.ResolveReference(ref).String())
+		}
+		if !filepath.IsAbs(arg) {
+			arg = filepath.Join(filepath.Dir(source), arg)
+		}
+		return p.parseFile(arg)
+	case "@url":
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("无效的URL: %q", arg)
+		}
+		return p.parseURL(arg)
+	default:
+		return nil, fmt.Errorf("未知的指令: %s", name)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListParse(t *testing.T) {
+	// 嵌套 maxIncludeDepth+1 层的列表，最深的一层无法再引入
+	deep := map[string]string{}
+	for i := 0; i <= maxIncludeDepth; i++ {
+		deep[fmt.Sprintf("l%d.txt", i)] = fmt.Sprintf("m%d.example.com\n@include l%d.txt\n", i, i+1)
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		// 列表文件为 files 中的 list.txt (或 l0.txt)
+		want []listEntry
+		// 期望的错误所在的文件和行号，errFile为空时期望解析成功
+		errFile string
+		errLine int
+	}{
+		{
+			name:  "BOM",
+			files: map[string]string{"list.txt": "\xef\xbb\xbfmirror.example.com\n"},
+			want:  []listEntry{{Host: "mirror.example.com", Line: 1}},
+		},
+		{
+			name:  "CRLF",
+			files: map[string]string{"list.txt": "a.example.com\r\n\r\nhttps://B.example.com/ region=CN\r\n"},
+			want: []listEntry{
+				{Host: "a.example.com", Line: 1},
+				{Host: "b.example.com", Region: "cn", Line: 3},
+			},
+		},
+		{
+			name:  "行尾注释",
+			files: map[string]string{"list.txt": "# 整行注释\nmirror.example.com provider=aliyun # 备用 #2\n"},
+			want:  []listEntry{{Host: "mirror.example.com", Provider: "aliyun", Comment: "备用 #2", Line: 2}},
+		},
+		{
+			name:    "未知的指令",
+			files:   map[string]string{"list.txt": "a.example.com\n@import other.txt\n"},
+			errFile: "list.txt",
+			errLine: 2,
+		},
+		{
+			name:    "无效的标注",
+			files:   map[string]string{"list.txt": "\na.example.com region\n"},
+			errFile: "list.txt",
+			errLine: 2,
+		},
+		{
+			name: "循环引用",
+			files: map[string]string{
+				"list.txt":  "a.example.com\n@include sub/b.txt\n",
+				"sub/b.txt": "# b\nb.example.com\n@include ../list.txt\n",
+			},
+			errFile: "sub/b.txt",
+			errLine: 3,
+		},
+		{
+			name:    "嵌套层数",
+			files:   deep,
+			errFile: fmt.Sprintf("l%d.txt", maxIncludeDepth-1),
+			errLine: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			list := filepath.Join(dir, "list.txt")
+			if _, ok := tt.files["list.txt"]; !ok {
+				list = filepath.Join(dir, "l0.txt")
+			}
+
+			entries, err := readList(list)
+			if tt.errFile != "" {
+				var le *listError
+				if !errors.As(err, &le) {
+					t.Fatalf("期望 listError，得到 %v", err)
+				}
+				if want := filepath.Join(dir, tt.errFile); le.Source != want || le.Line != tt.errLine {
+					t.Fatalf("错误位置为 %s:%d，期望 %s:%d (%v)", le.Source, le.Line, want, tt.errLine, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("得到 %d 个条目，期望 %d 个: %+v", len(entries), len(tt.want), entries)
+			}
+			for i, want := range tt.want {
+				want.Upstream = defaultUpstream
+				want.Source = list
+				if entries[i] != want {
+					t.Errorf("第%d个条目为 %+v，期望 %+v", i, entries[i], want)
+				}
+			}
+		})
+	}
+}
+
+// 不会发出请求的Transport，避免 @url 在测试中访问网络
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("测试中不访问网络")
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("\xef\xbb\xbfmirror.example.com\r\nhttp://10.0.0.1:5000 upstream=ghcr.io region=cn # 内网\n"))
+	f.Add([]byte("# 注释\n\n@url https://example.com/list.txt\n"))
+	f.Add([]byte("a.example.com auth=required provider=aliyun\nb.example.com:443/\n"))
+	f.Add([]byte("ftp://a.example.com\n@url\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// @include 会读取任意路径 (如 /dev/zero)，只在表格测试中覆盖
+		if strings.Contains(string(data), "@include") {
+			t.Skip()
+		}
+		p := newListParser()
+		p.client = &http.Client{Transport: failingTransport{}}
+		entries, err := p.parse(data, "fuzz.txt")
+		if err != nil {
+			var le *listError
+			if errors.As(err, &le) && (le.Source != "fuzz.txt" || le.Line < 1) {
+				t.Fatalf("错误位置无效: %v", err)
+			}
+			return
+		}
+		line := 0
+		for _, entry := range entries {
+			if entry.Host == "" || strings.ContainsAny(entry.Host, "/ \t") || entry.Upstream == "" {
+				t.Fatalf("无效的条目: %+v", entry)
+			}
+			if entry.Source != "fuzz.txt" || entry.Line <= line {
+				t.Fatalf("条目的来源或行号无效: %+v", entry)
+			}
+			line = entry.Line
+		}
+		if len(p.active) != 0 {
+			t.Fatalf("解析结束后仍有正在解析的来源: %v", p.active)
+		}
+	})
+}
+
+// 检查更新失败后，-auto-update-list 不会每次运行都重新尝试
+func TestListStaleAfterFailure(t *testing.T) {
+	chdirTemp(t)
+	if err := os.WriteFile("docker.txt", []byte("mirror.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		name    string
+		checked time.Duration
+		failed  time.Duration
+		maxAge  time.Duration
+		want    bool
+	}{
+		{"刚刚失败", 48 * time.Hour, 10 * time.Minute, 24 * time.Hour, false},
+		{"失败超过1小时", 48 * time.Hour, 2 * time.Hour, 24 * time.Hour, true},
+		{"maxAge短于1小时", 48 * time.Hour, 20 * time.Minute, 10 * time.Minute, true},
+		{"失败后又成功", time.Hour, 2 * time.Hour, 24 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		meta := &listMeta{URL: githubProxyURL("direct", "docker.txt"), Checked: now.Add(-tt.checked), Failed: now.Add(-tt.failed)}
+		if err := saveListMeta("docker.txt", meta); err != nil {
+			t.Fatal(err)
+		}
+		if got := listStale("docker.txt", tt.maxAge); got != tt.want {
+			t.Errorf("%s: listStale = %v，期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
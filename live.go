@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 实时表格两次刷新之间的最短间隔，避免结果很多时频繁重绘导致闪烁
+const liveRefreshInterval = 100 * time.Millisecond
+
+// -live 模式: 检测过程中在终端中原地刷新按响应时间排序的结果表格
+//
+// 最快的镜像源总是显示在最上面，终端高度不够时只显示前面的部分。
+// 表格只在检测过程中显示，检测结束后清除，之后与普通模式一样输出完整的结果。
+type liveTable struct {
+	mu      sync.Mutex
+	out     *os.File
+	sortKey string
+	start   time.Time
+	total   int
+	results []CheckResult
+	// 上一次输出的行数，下一次刷新时先回到这些行的开头
+	lines    int
+	rendered time.Time
+}
+
+// 标准输出不是终端或不支持ANSI控制序列时返回nil
+func newLiveTable(total int, sortKey string) *liveTable {
+	if _, _, ok := terminalSize(os.Stdout); !ok || !enableVirtualTerminal(os.Stdout) {
+		return nil
+	}
+	return &liveTable{out: os.Stdout, sortKey: sortKey, start: time.Now(), total: total}
+}
+
+// 记录一个完成的检测，距离上一次刷新超过liveRefreshInterval或全部完成时重绘表格
+func (t *liveTable) add(result CheckResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.results = append(t.results, result)
+	if len(t.results) < t.total && time.Since(t.rendered) < liveRefreshInterval {
+		return
+	}
+	t.render()
+}
+
+// 清除实时表格
+func (t *liveTable) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rewind()
+}
+
+// 回到上一次输出的第一行并清除到屏幕末尾 (调用时持有锁)
+func (t *liveTable) rewind() {
+	if t.lines > 0 {
+		fmt.Fprintf(t.out, "\033[%dA\r\033[J", t.lines)
+		t.lines = 0
+	}
+}
+
+// 调用时持有锁
+func (t *liveTable) render() {
+	cols, rows, ok := terminalSize(t.out)
+	if !ok {
+		return
+	}
+
+	sorted := append([]CheckResult(nil), t.results...)
+	sortResults(sorted, t.sortKey)
+	pinFirst(sorted)
+
+	success := 0
+	for _, result := range t.results {
+		if isSuccess(result) {
+			success++
+		}
+	}
+	status := fmt.Sprintf("已检测 %d/%d 个，成功 %d，已用 %s", len(t.results), t.total, success, formatClock(time.Since(t.start)))
+
+	// 表头、分隔线、状态行和可能的省略提示各占一行，最后留一行给光标
+	limit := rows - 5
+	if limit < 1 {
+		limit = 1
+	}
+	if len(sorted) > limit {
+		status += fmt.Sprintf("，只显示前 %d 个", limit)
+		sorted = sorted[:limit]
+	}
+
+	var buf bytes.Buffer
+	writeTable(&buf, sorted)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	lines = append(lines, "", status)
+
+	t.rewind()
+	for _, line := range lines {
+		// 超过终端宽度的行会折行，回退的行数就不对了
+		fmt.Fprintln(t.out, truncateDisplay(line, cols-1))
+	}
+	t.lines = len(lines)
+	t.rendered = time.Now()
+}
+
+// 截断到指定的显示宽度
+func truncateDisplay(s string, width int) string {
+	used := 0
+	for i, r := range s {
+		w := displayWidth(string(r))
+		if used+w > width {
+			return s[:i]
+		}
+		used += w
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// macOS上的Docker运行环境，按当前的docker context判断
+func detectMacTarget() dockerTarget {
+	home, _ := os.UserHomeDir()
+
+	context := dockerContext()
+	switch {
+	case context == "orbstack":
+		return orbstackTarget(home)
+	case context == "colima":
+		return colimaTarget(home, "default")
+	case strings.HasPrefix(context, "colima-"):
+		return colimaTarget(home, strings.TrimPrefix(context, "colima-"))
+	case context == "desktop-linux":
+		return dockerDesktopTarget(home)
+	}
+
+	// 没有docker命令或使用默认context时，按DOCKER_HOST和socket文件判断
+	host := os.Getenv("DOCKER_HOST")
+	switch {
+	case strings.Contains(host, "/.orbstack/"):
+		return orbstackTarget(home)
+	case strings.Contains(host, "/.colima/"):
+		return colimaTarget(home, "default")
+	}
+	if _, err := os.Stat(filepath.Join(home, ".orbstack", "run", "docker.sock")); err == nil {
+		return orbstackTarget(home)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".colima", "default", "docker.sock")); err == nil {
+		return colimaTarget(home, "default")
+	}
+	return dockerDesktopTarget(home)
+}
+
+// 当前使用的docker context名称
+func dockerContext() string {
+	out, err := exec.Command("docker", "context", "show").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Docker Desktop 在退出时保存设置，重新打开后读取 ~/.docker/daemon.json
+func dockerDesktopTarget(home string) dockerTarget {
+	restart := `osascript -e 'quit app "Docker"' && sleep 5 && open -a Docker`
+	return dockerTarget{
+		Name:       "Docker Desktop",
+		ConfigPath: filepath.Join(home, ".docker", "daemon.json"),
+		Reload:     restart,
+		Restart:    restart,
+	}
+}
+
+func orbstackTarget(home string) dockerTarget {
+	return dockerTarget{
+		Name:       "OrbStack",
+		ConfigPath: filepath.Join(home, ".orbstack", "config", "docker.json"),
+		Reload:     "orb restart docker",
+		Restart:    "orb restart docker",
+	}
+}
+
+// Colima 的daemon配置位于 colima.yaml 的 docker 字段中
+func colimaTarget(home, profile string) dockerTarget {
+	colimaHome := os.Getenv("COLIMA_HOME")
+	if colimaHome == "" {
+		colimaHome = filepath.Join(home, ".colima")
+	}
+	restart := "colima restart"
+	if profile != "default" {
+		restart += " --profile " + profile
+	}
+	return dockerTarget{
+		Name:       "Colima",
+		ConfigPath: filepath.Join(colimaHome, profile, "colima.yaml"),
+		Format:     configColima,
+		Reload:     restart,
+		Restart:    restart,
+	}
+}
+
+// 读取colima.yaml中docker字段的registry-mirrors
+func readColimaConfig(path string) (*DaemonConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取Colima配置失败 (请先运行一次 colima start): %v", err)
+	}
+
+	var colima struct {
+		Docker struct {
+			RegistryMirrors []string `yaml:"registry-mirrors"`
+		} `yaml:"docker"`
+	}
+	if err := yaml.Unmarshal(data, &colima); err != nil {
+		return nil, fmt.Errorf("解析Colima配置失败: %v", err)
+	}
+	return &DaemonConfig{RegistryMirrors: colima.Docker.RegistryMirrors}, nil
+}
+
+// 修改colima.yaml中docker字段的registry-mirrors，保留其他配置和注释
+func renderColimaConfig(path string, config *DaemonConfig) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取Colima配置失败 (请先运行一次 colima start): %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析Colima配置失败: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Colima配置格式不正确: %s", path)
+	}
+
+	docker := yamlMapValue(doc.Content[0], "docker")
+	if docker == nil || docker.Kind != yaml.MappingNode {
+		docker = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		yamlMapSet(doc.Content[0], "docker", docker)
+	}
+	// colima默认写入的是 docker: {}，改为块格式
+	docker.Style = 0
+
+	mirrors := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, mirror := range config.RegistryMirrors {
+		mirrors.Content = append(mirrors.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: mirror})
+	}
+	yamlMapSet(docker, "registry-mirrors", mirrors)
+	if len(config.InsecureRegistries) > 0 {
+		insecure := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, host := range config.InsecureRegistries {
+			insecure.Content = append(insecure.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: host})
+		}
+		yamlMapSet(docker, "insecure-registries", insecure)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("序列化Colima配置失败: %v", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+func yamlMapValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func yamlMapSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
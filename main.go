@@ -1,54 +1,130 @@
 package main
 
 import (
-	"bufio"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
-// 定义检查结果的结构体
-type CheckResult struct {
-	Host       string
-	Available  bool
-	Time       time.Duration
-	StatusCode int
-	IsTimeout  bool
-}
-
 // Docker daemon.json 配置结构
 type DaemonConfig struct {
 	RegistryMirrors []string `json:"registry-mirrors,omitempty"`
-	// 其他配置项...
+	// 允许通过HTTP访问的仓库，HTTP镜像源需要同时加入这里
+	InsecureRegistries []string `json:"insecure-registries,omitempty"`
+	// 其他配置项，写入时原样保留
+	others map[string]json.RawMessage
 }
 
-// 检查docker是否已安装
-func checkDockerInstalled() bool {
-	cmd := exec.Command("docker", "--version")
-	return cmd.Run() == nil
+func (c *DaemonConfig) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.others); err != nil {
+		return err
+	}
+	if raw, ok := c.others["registry-mirrors"]; ok {
+		if err := json.Unmarshal(raw, &c.RegistryMirrors); err != nil {
+			return err
+		}
+		delete(c.others, "registry-mirrors")
+	}
+	if raw, ok := c.others["insecure-registries"]; ok {
+		if err := json.Unmarshal(raw, &c.InsecureRegistries); err != nil {
+			return err
+		}
+		delete(c.others, "insecure-registries")
+	}
+	return nil
+}
+
+func (c DaemonConfig) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(c.others)+2)
+	for key, value := range c.others {
+		fields[key] = value
+	}
+	if len(c.RegistryMirrors) > 0 {
+		fields["registry-mirrors"] = c.RegistryMirrors
+	}
+	if len(c.InsecureRegistries) > 0 {
+		fields["insecure-registries"] = c.InsecureRegistries
+	}
+	return json.Marshal(fields)
+}
+
+// 设置镜像源，其中的HTTP镜像源同时加入insecure-registries，否则Docker不会通过HTTP访问
+func (c *DaemonConfig) setMirrors(mirrors []string) {
+	c.RegistryMirrors = mirrors
+	for _, mirror := range mirrors {
+		if !strings.HasPrefix(mirror, "http://") {
+			continue
+		}
+		if host := mirrorHost(mirror); !containsString(c.InsecureRegistries, host) {
+			c.InsecureRegistries = append(c.InsecureRegistries, host)
+		}
+	}
+}
+
+// 写入配置的镜像源地址，HTTP镜像源为 http://host
+func mirrorURL(result CheckResult) string {
+	if result.Insecure {
+		return "http://" + result.Host
+	}
+	return "https://" + result.Host
+}
+
+// 把选择的镜像源合并到现有的镜像源中
+//
+// 按host去重，同一镜像源以本次检测的地址为准；本次检测可用的镜像源按响应时间排在前面，
+// 其余 (未检测或不可用的) 现有镜像源保持原有顺序排在后面，不会被删除。
+func mergeMirrors(existing, selected []string, results []CheckResult) []string {
+	times := make(map[string]time.Duration, len(results))
+	for _, result := range results {
+		times[result.Host] = result.Time
+	}
+
+	var merged []string
+	seen := map[string]bool{}
+	for _, mirror := range append(append([]string(nil), selected...), existing...) {
+		host := mirrorHost(mirror)
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		merged = append(merged, mirror)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		ti, okI := times[mirrorHost(merged[i])]
+		tj, okJ := times[mirrorHost(merged[j])]
+		if okI && okJ {
+			return ti < tj
+		}
+		return okI && !okJ
+	})
+	return merged
 }
 
 // 检查并读取daemon.json
-func readDaemonConfig() (*DaemonConfig, error) {
+func readDaemonConfigFile(path string) (*DaemonConfig, error) {
 	config := &DaemonConfig{}
 
-	configPath := "/etc/docker/daemon.json"
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
 		// 文件不存在，返回空配置
 		return config, nil
 	}
 
-	data, err := os.ReadFile(configPath)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("读取daemon.json失败: %v", err)
 	}
@@ -64,22 +140,69 @@ func readDaemonConfig() (*DaemonConfig, error) {
 	return config, nil
 }
 
-// 写入daemon.json
-func writeDaemonConfig(config *DaemonConfig) error {
-	data, err := json.MarshalIndent(config, "", "    ")
+// 写入配置文件，返回写入的内容和原配置的备份路径 (原来没有配置文件时为空)
+func writeDaemonConfig(target dockerTarget, config *DaemonConfig) ([]byte, string, error) {
+	data, err := target.renderConfig(config)
 	if err != nil {
-		return fmt.Errorf("序列化配置失败: %v", err)
+		return nil, "", err
 	}
+	path := target.ConfigPath
 
-	if err := os.MkdirAll("/etc/docker", 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %v", err)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, "", fmt.Errorf("创建目录失败: %v", err)
 	}
 
-	if err := os.WriteFile("/etc/docker/daemon.json", data, 0644); err != nil {
-		return fmt.Errorf("写入配置文件失败: %v", err)
+	// 按daemon.json的schema检查，不写入dockerd无法启动的配置
+	if target.Format == configDaemonJSON {
+		before, _ := os.ReadFile(path)
+		if err := checkDaemonSchema(before, data); err != nil {
+			return nil, "", err
+		}
 	}
 
-	return nil
+	// 写入前备份原有配置，可以通过 restore 子命令恢复
+	backup, err := backupDaemonConfig(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if backup != "" {
+		fmt.Printf("原配置已备份到 %s\n", backup)
+	}
+
+	if err := atomicWriteFile(path, data, validateTargetConfig(target, config)); err != nil {
+		if os.IsPermission(err) {
+			return nil, "", fmt.Errorf("写入配置文件失败: %v (请使用sudo运行)", err)
+		}
+		return nil, "", fmt.Errorf("写入配置文件失败: %v", err)
+	}
+
+	return data, backup, nil
+}
+
+func marshalDaemonConfig(config *DaemonConfig) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %v", err)
+	}
+	return data, nil
+}
+
+// 配置镜像源的选项
+type applyOptions struct {
+	// 重启后拉取该镜像验证配置，为空时不验证
+	VerifyImage string
+	// 配置生效后预先拉取的镜像，用于预热镜像源缓存
+	WarmImages []string
+	// 只显示将要进行的修改，不写入文件也不执行命令
+	DryRun bool
+	// 写入配置的目标，系统级或rootless的Docker
+	Target dockerTarget
+	// 本次运行的命令行参数，没有sudo或拒绝通过sudo写入时提示用户以sudo重新运行
+	Args []string
+	// 把选出的镜像源合并到现有的镜像源中，而不是替换
+	Merge bool
+	// 黑名单中的镜像源，合并时从现有的镜像源中去掉
+	Blocked map[string]string
 }
 
 // 执行系统命令
@@ -90,350 +213,1419 @@ func execCommand(command string) error {
 	return cmd.Run()
 }
 
-// Linux系统下的特殊处理
-func handleLinuxSystem(successResults []CheckResult) error {
+// 交互式选择镜像源并写入配置
+func handleInteractiveApply(successResults []CheckResult, opts applyOptions) error {
 	// 检查docker是否安装
-	if !checkDockerInstalled() {
-		return fmt.Errorf("未检测到Docker，请先安装Docker")
+	if !opts.Target.installed() {
+		return fmt.Errorf("未检测到%s，请先安装%s", opts.Target.Name, opts.Target.Name)
 	}
-
-	// 读取当前配置
-	config, err := readDaemonConfig()
+	// 无法确定配置文件的位置时 (如WSL2中找不到Windows用户目录)，不写入一个不会生效的文件
+	if opts.Target.ConfigPath == "" {
+		return fmt.Errorf("%s", opts.Target.Note)
+	}
+	if !opts.Target.NeedRoot {
+		fmt.Printf("\n检测到%s，配置将写入 %s\n", opts.Target.Name, opts.Target.ConfigPath)
+	}
+	// 在做出选择之前确认有写入权限
+	sudo, err := ensurePrivileges(opts)
 	if err != nil {
 		return err
 	}
 
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("\n请选择操作：")
-	fmt.Println("1. 替换全部镜像源")
-	fmt.Println("2. 选择单个镜像源")
-	fmt.Print("请输入选项 (1/2): ")
-
-	choice, _ := reader.ReadString('\n')
-	choice = strings.TrimSpace(choice)
+	mode, err := choose("mode", "请输入选项 (1/2/3): ", []string{"替换全部镜像源", "选择镜像源", "合并到现有镜像源"})
+	if err != nil {
+		return err
+	}
 
 	var newMirrors []string
 
-	switch choice {
-	case "1":
+	switch mode {
+	case 0:
 		// 替换全部镜像源
 		for _, result := range successResults {
-			newMirrors = append(newMirrors, "https://"+result.Host)
+			newMirrors = append(newMirrors, mirrorURL(result))
 		}
-	case "2":
+	case 1, 2:
 		// 显示可选项
 		fmt.Println("\n可用的镜像源：")
-		for i, result := range successResults {
-			fmt.Printf("%d. %s (响应时间: %.2fs)\n", i+1, result.Host, result.Time.Seconds())
+		options := make([]string, 0, len(successResults))
+		for _, result := range successResults {
+			options = append(options, fmt.Sprintf("%s (响应时间: %.2fs)", result.Host, result.Time.Seconds()))
 		}
 
-		fmt.Print("请选择镜像源编号: ")
-		var index int
-		fmt.Scanln(&index)
+		selected, err := multiSelect("mirrors", "请选择镜像源编号，多个用逗号分隔: ", options)
+		if err != nil {
+			return err
+		}
+		for _, index := range selected {
+			newMirrors = append(newMirrors, mirrorURL(successResults[index]))
+		}
+	}
+
+	// 合并时保留现有的镜像源，去重后按响应时间排序
+	req := newApplyRequest(newMirrors, successResults, opts)
+	req.Merge = mode == 2
 
-		if index < 1 || index > len(successResults) {
-			return fmt.Errorf("无效的选择")
+	if opts.DryRun {
+		config, err := req.config()
+		if err != nil {
+			return err
 		}
+		return previewApply(config, successResults, opts)
+	}
 
-		newMirrors = append(newMirrors, "https://"+successResults[index-1].Host)
+	// 写入之前询问是否重启docker，通过sudo写入时不再需要交互；没有服务需要重启时 (如buildx) 只写入配置
+	switch {
+	case opts.Target.Restart == "":
+		req.VerifyImage, req.WarmImages = "", nil
+	case opts.Target.ReloadOnly:
+		// 无法重启服务时 (如Docker-in-Docker) 只能通过SIGHUP重新加载
+		if confirm("restart", fmt.Sprintf("\n无法重启%s服务，是否在写入后发送SIGHUP让其重新加载配置? (y/n): ", opts.Target.Name)) {
+			req.Activate = opts.Target.Reload
+		} else if opts.pullsAfterApply() {
+			fmt.Println("不重新加载Docker配置，跳过拉取验证和缓存预热")
+		}
 	default:
-		return fmt.Errorf("无效的选择")
+		if confirm("restart", fmt.Sprintf("\n是否在写入后重启%s服务? (y/n): ", opts.Target.Name)) {
+			req.Activate, req.Restart = opts.Target.Restart, true
+		} else if opts.pullsAfterApply() {
+			fmt.Println("不重启Docker服务，跳过拉取验证和缓存预热")
+		}
+	}
+	if req.Activate == "" {
+		req.VerifyImage, req.WarmImages = "", nil
 	}
 
-	// 更新配置
-	config.RegistryMirrors = newMirrors
+	return applyMirrors(req, sudo)
+}
 
-	// 写入新配置
-	if err := writeDaemonConfig(config); err != nil {
-		return err
-	}
+// 写入配置的请求: 选好的镜像源和写入后要执行的步骤，需要root权限时通过 apply-config 子命令以sudo执行
+type applyRequest struct {
+	Target dockerTarget
+	// 选出的镜像源
+	Mirrors []string
+	// 合并到现有的镜像源中，合并时去掉黑名单中的镜像源
+	Merge   bool
+	Blocked map[string]string
+	// 本次检测可用的镜像源，用于合并时排序和写入备用列表
+	Results []CheckResult
+	// 写入后让配置生效的命令，为空时不执行；Restart表示该命令重启服务而不是重新加载配置
+	Activate string
+	Restart  bool
+	// 配置生效后拉取验证和预热缓存的镜像
+	VerifyImage string
+	WarmImages  []string
+}
 
-	fmt.Println("\n新的daemon.json配置：")
-	configData, _ := json.MarshalIndent(config, "", "    ")
-	fmt.Println(string(configData))
+func newApplyRequest(mirrors []string, results []CheckResult, opts applyOptions) applyRequest {
+	return applyRequest{
+		Target:      opts.Target,
+		Mirrors:     mirrors,
+		Merge:       opts.Merge,
+		Blocked:     opts.Blocked,
+		Results:     results,
+		VerifyImage: opts.VerifyImage,
+		WarmImages:  opts.WarmImages,
+	}
+}
 
-	// 重载daemon
-	fmt.Println("\n正在重载Docker daemon...")
-	if err := execCommand("systemctl daemon-reload"); err != nil {
-		return fmt.Errorf("重载Docker daemon失败: %v", err)
+// 读取当前配置并设置镜像源
+func (r applyRequest) config() (*DaemonConfig, error) {
+	config, err := r.Target.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	mirrors := r.Mirrors
+	if r.Merge {
+		mirrors = mergeMirrors(withoutBlocked(config.RegistryMirrors, r.Blocked), mirrors, r.Results)
 	}
+	config.setMirrors(mirrors)
+	return config, nil
+}
 
-	// 询问是否重启docker
-	fmt.Print("\n是否重启Docker服务? (y/n): ")
-	restart, _ := reader.ReadString('\n')
-	restart = strings.TrimSpace(strings.ToLower(restart))
+// 写入配置，执行让配置生效的命令，失败时恢复原配置；然后拉取验证和预热缓存
+func executeApply(req applyRequest) error {
+	config, err := req.config()
+	if err != nil {
+		return err
+	}
+	backup, err := writeMirrors(req.Target, config, req.Results)
+	if err != nil {
+		return err
+	}
 
-	if restart == "y" || restart == "yes" {
-		fmt.Println("正在重启Docker服务...")
-		if err := execCommand("systemctl restart docker"); err != nil {
-			return fmt.Errorf("重启Docker服务失败: %v", err)
+	if req.Activate != "" {
+		action := fmt.Sprintf("重新加载%s配置", req.Target.Name)
+		if req.Restart {
+			action = fmt.Sprintf("重启%s服务", req.Target.Name)
+		}
+		fmt.Printf("正在%s...\n", action)
+		if err := execCommand(req.Activate); err != nil {
+			return rollbackConfig(req.Target, backup, req.Activate, fmt.Errorf("%s失败: %v", action, err))
+		}
+		if req.Restart {
+			fmt.Printf("%s服务已重启\n", req.Target.Name)
+		} else {
+			fmt.Printf("%s已重新加载配置\n", req.Target.Name)
 		}
-		fmt.Println("Docker服务已重启")
 	}
 
-	return nil
+	opts := applyOptions{Target: req.Target, VerifyImage: req.VerifyImage, WarmImages: req.WarmImages}
+	return afterApply(opts, config.RegistryMirrors, req.Activate, backup)
 }
 
-// 从GitHub下载docker.txt
-func downloadFromGithub() error {
-	url := "https://raw.githubusercontent.com/YMingPro/docker-register-check/main/docker.txt"
+// 配置生效后是否需要通过Docker拉取镜像
+func (o applyOptions) pullsAfterApply() bool {
+	return o.VerifyImage != "" || len(o.WarmImages) > 0
+}
 
-	resp, err := http.Get(url)
+// 配置生效后确认Docker正常运行并拉取镜像验证配置，失败时恢复原配置；然后预热镜像源缓存
+//
+// activate为让配置生效时执行的命令 (重启或重新加载)，恢复原配置后会再次执行。
+func afterApply(opts applyOptions, mirrors []string, activate, backup string) error {
+	if activate != "" && opts.Target.isDockerd() {
+		if err := waitDockerUp(dockerStartTimeout); err != nil {
+			return rollbackConfig(opts.Target, backup, activate, fmt.Errorf("%s未能正常运行: %v", opts.Target.Name, err))
+		}
+	}
+	if opts.VerifyImage != "" {
+		fmt.Println("\n正在通过Docker拉取镜像验证配置...")
+		if err := verifyPull(opts.VerifyImage, mirrors); err != nil {
+			return rollbackConfig(opts.Target, backup, activate, fmt.Errorf("拉取验证失败: %v", err))
+		}
+	}
+	if len(opts.WarmImages) > 0 {
+		warmCache(opts.WarmImages)
+	}
+	return nil
+}
+
+// 写入新配置并重载systemd，同时把其余可用的镜像源写入备用列表，返回原配置的备份路径
+func writeMirrors(target dockerTarget, config *DaemonConfig, results []CheckResult) (string, error) {
+	data, backup, err := writeDaemonConfig(target, config)
 	if err != nil {
-		return fmt.Errorf("下载失败: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("下载失败，状态码: %d", resp.StatusCode)
+	fmt.Printf("\n新的配置 (%s)：\n", target.ConfigPath)
+	fmt.Println(strings.TrimRight(string(data), "\n"))
+
+	if target.Format == configHostsTOML {
+		checkContainerdConfigPath()
+	}
+	if target.Note != "" {
+		fmt.Println("\n" + target.Note)
 	}
 
-	file, err := os.Create("docker.txt")
-	if err != nil {
-		return fmt.Errorf("创建文件失败: %v", err)
+	// 备用列表只是辅助信息，写入失败不影响配置
+	if path, err := writeStandby(target, results, config.RegistryMirrors, time.Now()); err != nil {
+		fmt.Println(err)
+	} else if path != "" {
+		fmt.Printf("其余可用的镜像源已写入备用列表 %s\n", path)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	// 没有systemd时 (macOS、WSL2、OpenRC等) 在重启或重新加载时读取新配置
+	if target.DaemonReload == "" {
+		return backup, nil
+	}
+	fmt.Println("\n正在重载Docker daemon...")
+	if err := execCommand(target.DaemonReload); err != nil {
+		return backup, fmt.Errorf("重载Docker daemon失败: %v", err)
+	}
+	return backup, nil
+}
+
+// 非交互式配置: 选出响应最快的count个镜像源写入daemon.json，并让Docker重新加载配置
+//
+// registry-mirrors支持热加载，这里使用 systemctl reload (没有systemd时发送SIGHUP) 而不是重启，
+// 避免无人值守时重启Docker导致容器中断。
+func applyFastest(successResults []CheckResult, count int, opts applyOptions) error {
+	if len(successResults) == 0 {
+		return fmt.Errorf("没有可用的镜像源")
+	}
+	if !opts.Target.installed() {
+		return fmt.Errorf("未检测到%s，请先安装%s", opts.Target.Name, opts.Target.Name)
+	}
+	// 无法确定配置文件的位置时 (如WSL2中找不到Windows用户目录)，不写入一个不会生效的文件
+	if opts.Target.ConfigPath == "" {
+		return fmt.Errorf("%s", opts.Target.Note)
+	}
+	if !opts.Target.NeedRoot {
+		fmt.Printf("\n检测到%s，配置将写入 %s\n", opts.Target.Name, opts.Target.ConfigPath)
+	}
+	sudo, err := ensurePrivileges(opts)
 	if err != nil {
-		return fmt.Errorf("保存文件失败: %v", err)
+		return err
 	}
 
-	return nil
+	// hosts.toml 这类即时生效的配置不需要重新加载
+	req := newApplyRequest(fastestMirrors(successResults, count), successResults, opts)
+	req.Activate = opts.Target.Reload
+
+	if opts.DryRun {
+		config, err := req.config()
+		if err != nil {
+			return err
+		}
+		return previewApply(config, successResults, opts)
+	}
+	return applyMirrors(req, sudo)
 }
 
-// 定义worker池来处理检查任务
-func worker(id int, jobs <-chan string, results chan<- CheckResult, timeout time.Duration, wg *sync.WaitGroup) {
-	defer wg.Done()
+// 响应最快的count个镜像源的地址
+func fastestMirrors(results []CheckResult, count int) []string {
+	sorted := append([]CheckResult(nil), results...)
+	sortResults(sorted, "time")
+	// 置顶的镜像源优先于响应时间
+	pinFirst(sorted)
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	mirrors := make([]string, 0, len(sorted))
+	for _, result := range sorted {
+		mirrors = append(mirrors, mirrorURL(result))
+	}
+	return mirrors
+}
 
-	client := &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+// 解析 -apply 参数，如 fastest、fastest:3，返回镜像源数量
+func parseApplySpec(spec string) (int, error) {
+	name, n, hasN := strings.Cut(spec, ":")
+	if name != "fastest" {
+		return 0, fmt.Errorf("不支持的 -apply 参数: %s (格式为 fastest[:N])", spec)
 	}
+	if !hasN {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("无效的镜像源数量: %s", n)
+	}
+	return count, nil
+}
 
-	for host := range jobs {
-		start := time.Now()
-		result := CheckResult{
-			Host: host,
-		}
+// 预览配置修改: 输出daemon.json的差异和将要执行的命令
+func previewApply(config *DaemonConfig, results []CheckResult, opts applyOptions) error {
+	after, err := opts.Target.renderConfig(config)
+	if err != nil {
+		return err
+	}
+	path := opts.Target.ConfigPath
+	before, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
 
-		url := fmt.Sprintf("https://%s/v2/", host)
-		resp, err := client.Get(url)
+	fmt.Println("\n[dry-run] 以下为预览，不会修改任何文件，也不会执行任何命令")
+	if before != nil {
+		fmt.Printf("\n将备份原配置到 %s.bak.<时间>\n", path)
+	}
+	fmt.Printf("\n将写入 %s:\n", path)
+	writeDiff(os.Stdout, path, before, after)
 
-		if err != nil {
-			result.Available = false
-			if os.IsTimeout(err) || strings.Contains(err.Error(), "timeout") {
-				result.IsTimeout = true
-			}
-			results <- result
-			continue
+	if standby := standbyMirrors(results, config.RegistryMirrors); len(standby) > 0 {
+		fmt.Printf("\n将写入备用镜像源列表 %s:\n", standbyPath(opts.Target))
+		for _, result := range standby {
+			fmt.Printf("  %s (%.2fs)\n", result.Host, result.Time.Seconds())
 		}
+	}
 
-		result.StatusCode = resp.StatusCode
-		result.Time = time.Since(start)
-		result.Available = (resp.StatusCode >= 200 && resp.StatusCode < 400) || resp.StatusCode == 401
-
-		resp.Body.Close()
-		results <- result
+	fmt.Println("\n将执行的命令:")
+	if opts.Target.DaemonReload != "" {
+		fmt.Println("  " + opts.Target.DaemonReload)
+	}
+	if opts.Target.Restart != "" {
+		fmt.Println("  " + opts.Target.Restart + "   (确认后执行)")
 	}
+	if opts.VerifyImage != "" {
+		fmt.Printf("  docker pull %s\n", opts.VerifyImage)
+	}
+	for _, image := range opts.WarmImages {
+		fmt.Printf("  docker pull -q %s\n", image)
+	}
+	return nil
 }
 
+// 提示信息的输出位置，非表格输出时改为stderr，避免混入结果
+var infoOut io.Writer = os.Stdout
+
+// 为true时不等待按键，用于脚本调用
+var noWait bool
+
+// 为true时不输出进度条、分隔线和✓/✗等符号，便于屏幕阅读器朗读
+var plainOutput bool
+
 // 等待用户按键
 func waitForKeyPress() {
-	fmt.Println("\n按回车键退出...")
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if noWait {
+		return
+	}
+	prompter.Ask("exit", "\n按回车键退出...\n")
 }
 
-// 显示进度条
-func showProgress(current, total int) {
-	width := 40 // 进度条宽度
-	percentage := float64(current) / float64(total)
-	filled := int(float64(width) * percentage)
+func main() {
+	// 子命令
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "analyze":
+			err = runAnalyze(os.Args[2:])
+		case "diff":
+			err = runDiff(os.Args[2:])
+		case "share":
+			err = runShare(os.Args[2:])
+		case "agent":
+			err = runAgent(os.Args[2:])
+		case "watch":
+			err = runWatch(os.Args[2:])
+		case "install-service":
+			err = runInstallService(os.Args[2:])
+		case "uninstall-service":
+			err = runUninstallService(os.Args[2:])
+		case "restore":
+			err = runRestore(os.Args[2:])
+		case "report":
+			err = runReport(os.Args[2:])
+		case "healthcheck":
+			os.Exit(runHealthcheck(os.Args[2:]))
+		case "validate-config":
+			os.Exit(runValidateConfig(os.Args[2:]))
+		case "support-bundle":
+			err = runSupportBundle(os.Args[2:])
+		case "block":
+			err = runBlock(os.Args[2:])
+		case "unblock":
+			err = runUnblock(os.Args[2:])
+		case "pin":
+			err = runPin(os.Args[2:])
+		case "unpin":
+			err = runUnpin(os.Args[2:])
+		case "list":
+			os.Exit(runList(os.Args[2:]))
+		case "discover":
+			err = runDiscover(os.Args[2:])
+		case "apply-config":
+			// 通过sudo写入配置时内部使用
+			err = runApplyConfig(os.Args[2:])
+		case "check":
+			// 与默认模式相同，参数之后可以直接列出要检测的host
+			runCheck(os.Args[2:])
+			return
+		default:
+			runCheck(os.Args[1:])
+			return
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 双击启动时显示菜单，方便不熟悉命令行参数的用户
+	if launchedByDoubleClick() {
+		runMenu()
+		return
+	}
 
-	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
-	fmt.Printf("\r检测进度: [%s] %d/%d (%.1f%%)", bar, current, total, percentage*100)
+	runCheck(nil)
 }
 
-func main() {
+// 默认模式：检测docker.txt中的所有registry
+func runCheck(args []string) {
 	// 定义命令行参数
-	timeoutPtr := flag.Float64("timeout", 10.0, "请求超时时间（秒）")
-	workersPtr := flag.Int("workers", runtime.NumCPU()*2, "并发worker数量")
-	updatePtr := flag.Bool("update", false, "强制从GitHub更新docker.txt")
-	listSuccessPtr := flag.Bool("l", false, "只显示成功的结果")
-	flag.Parse()
+	fs := flag.NewFlagSet("docker-registry-checker", flag.ExitOnError)
+	timeoutPtr := fs.Float64("timeout", 10.0, "请求超时时间（秒）")
+	connectTimeoutPtr := fs.Float64("connect-timeout", 0, "建立TCP连接 (包括DNS解析) 的超时时间（秒），默认使用 -timeout")
+	tlsTimeoutPtr := fs.Float64("tls-timeout", 0, "TLS握手的超时时间（秒），默认使用 -timeout")
+	responseTimeoutPtr := fs.Float64("response-timeout", 0, "连接建立后等待响应头的超时时间（秒），默认使用 -timeout")
+	workersPtr := fs.Int("workers", 0, "并发worker数量，默认从4开始根据超时和连接错误的比例自动调整 (2~64)")
+	updatePtr := fs.Bool("update", false, "强制从GitHub更新列表文件 (docker.txt 或 -category 选择的分类)")
+	autoUpdatePtr := fs.Duration("auto-update-list", 0, "列表文件超过该时间没有更新时自动检查更新 (如 24h)，内容没有变化时不会重新下载，默认不自动更新")
+	listPubkeyPtr := fs.String("list-pubkey", "", "下载的列表必须通过签名校验: minisign公钥 (RWQ...) 或公钥文件，PEM格式的公钥按cosign签名校验")
+	githubProxyPtr := fs.String("github-proxy", "", "下载列表文件时依次尝试的方式，逗号分隔: direct (直连GitHub)、jsdelivr 或第三方加速前缀 (如 https://ghfast.top/，需要显式指定) (默认: direct,jsdelivr)")
+	var filters stringsFlag
+	fs.Var(&filters, "filter", "只检测标注满足条件的镜像源，如 region=cn 或 provider=aliyun,tencent，可重复指定 (支持 upstream/region/provider/auth)")
+	var includes, excludes stringsFlag
+	fs.Var(&includes, "include", "只检测host匹配该正则表达式的镜像源，可重复指定，匹配其中任意一个即可")
+	fs.Var(&excludes, "exclude", "跳过host匹配该正则表达式的镜像源，可重复指定")
+	shufflePtr := fs.Bool("shuffle", false, "打乱检测顺序")
+	samplePtr := fs.Int("sample", 0, "只随机检测其中N个镜像源，用于在很大的列表中快速粗略地检测一遍")
+	ratePtr := fs.Float64("rate", 0, "所有worker合计每秒最多发出的探测请求数 (如 5 或 0.5)，请求间隔带有随机抖动，默认不限制")
+	livePtr := fs.Bool("live", false, "检测过程中在终端中实时刷新按响应时间排序的结果表格，最快的镜像源显示在最上面")
+	maxBandwidthPtr := fs.String("max-bandwidth", "", "深度检测 (-oci-image、-integrity) 下载镜像清单和镜像层合计的最大速度 (每秒字节数，如 512K、10M)，计时的探测不限速，默认不限制")
+	maxConnsPtr := fs.Int("max-conns", 0, "同时打开的最大连接数，默认不限制")
+	hedgePtr := fs.Bool("hedge", false, "探测超过已完成探测的p95响应时间仍未返回时再发送一次，使用先返回的结果，减少少数很慢的镜像源拖慢整轮检测")
+	maxDurationPtr := fs.Duration("max-duration", 0, "整个检测的最长时间 (如 2m)，到时取消尚未完成的检测，只使用已完成的结果，不包括之后的配置、拉取验证和缓存预热，默认不限制")
+	streamThresholdPtr := fs.Int("stream-threshold", defaultStreamThreshold, "检测的镜像源超过该数量时，结果写入临时文件而不在内存中保存，只显示可用的镜像源 (0表示总是保存在内存中)")
+	checkpointPtr := fs.String("checkpoint", defaultCheckpointPath, "检测过程中保存进度的检查点文件，检测没有全部完成时保留，用于 -resume (为空时不保存)")
+	resumePtr := fs.Bool("resume", false, "从检查点文件恢复上次中断的检测，只检测还没有结果的镜像源")
+	cachePtr := fs.Duration("cache", 0, "使用该时间内 (如 1h) 缓存的检测结果，不再重新检测这些镜像源，默认不使用缓存")
+	cacheFilePtr := fs.String("cache-file", defaultCachePath, "-cache 使用的结果缓存文件")
+	seedPtr := fs.Int64("seed", 0, "-shuffle / -sample 使用的随机数种子，相同的种子抽取相同的镜像源 (默认随机)")
+	blocklistPtr := fs.String("blocklist", defaultBlocklistPath, "黑名单文件，其中的镜像源不会被检测、推荐或写入配置 (通过 block / unblock 子命令管理)")
+	pinnedPtr := fs.String("pinned", defaultPinnedPath, "置顶列表文件，其中的镜像源总是显示在结果最前面，-apply fastest 时优先选择 (通过 pin / unpin 子命令管理)")
+	var lists stringsFlag
+	fs.Var(&lists, "list", "检测的列表文件或URL，可重复指定，多个列表中相同的镜像源只检测一次 (默认: docker.txt)")
+	categoryPtr := fs.String("category", "", "检测的镜像源分类，逗号分隔: docker-hub (docker.txt)、ghcr、k8s、gcr、quay 或 all，每个分类按对应的上游检测 (默认: docker-hub)")
+	importPtr := fs.String("import", "", "从已有配置导入镜像源作为检测列表 (daemon.json、containerd的certs.d目录、registries.yaml、registries.conf)")
+	currentPtr := fs.Bool("current", false, "只检测当前已配置的镜像源 (配置文件和docker info)，判断现有配置是否仍然可用")
+	listSuccessPtr := fs.Bool("l", false, "只显示成功的结果")
+	retriesPtr := fs.Int("retries", 0, "失败后的重试次数 (指数退避)")
+	noBreakerPtr := fs.Bool("no-breaker", false, "重试时不跳过连续连接失败的镜像源，也不限制本轮的重试总次数")
+	methodPtr := fs.String("method", "GET", "探测请求方法 (GET/HEAD)，HEAD被拒绝时自动回退为GET")
+	probePathPtr := fs.String("probe-path", "/v2/", "探测路径")
+	expectStatusPtr := fs.String("expect-status", "", "视为可用的状态码，逗号分隔，支持 2xx 和 200-299 形式 (默认: 2xx,3xx,401)")
+	rejectRedirectPtr := fs.String("reject-redirect", "", "被重定向到匹配该正则的地址时视为不可用 (如 \"login|signin\")")
+	http3Ptr := fs.Bool("http3", false, "额外通过UDP探测HTTP/3 (QUIC) 支持")
+	warmupPtr := fs.Bool("warmup", false, "计时的探测之前先对每个镜像源 (-per-ip 时对每个IP) 发送一次不计时的预热请求，排除DNS缓存和首次TLS握手对响应时间的影响")
+	warmPtr := fs.Bool("warm", false, "额外测量复用连接 (keep-alive) 时的响应时间")
+	ociPtr := fs.Bool("oci", false, "探测OCI清单和referrers API支持情况")
+	ociImagePtr := fs.String("oci-image", "library/alpine:latest", "探测OCI能力时使用的镜像")
+	deepWorkersPtr := fs.Int("deep-workers", defaultDeepWorkers, "-oci / -integrity 深度检测的并发数，只检测通过快速检测的镜像源，与 -workers 分别设置")
+	integrityPtr := fs.Bool("integrity", false, "通过镜像源和Docker Hub分别下载同一个镜像层并比对内容，不一致的镜像源视为不可用")
+	integrityImagePtr := fs.String("integrity-image", "library/hello-world:latest", "内容比对使用的镜像，取其中最小的一层")
+	perIPPtr := fs.Bool("per-ip", false, "解析域名的所有A/AAAA记录并分别检测每个IP")
+	sortPtr := fs.String("sort", "host", "排序字段 (host/time/ttfb/warm/status/score)")
+	outputPtr := fs.String("output", "table", "输出格式 (table/json/csv/yaml)")
+	plainPtr := fs.Bool("plain", os.Getenv("TERM") == "dumb", "逐行输出进度和结果，不使用进度条、分隔线和符号，适合屏幕阅读器和简单终端")
+	savePtr := fs.String("save", "", "将检测结果保存到文件 (.json/.csv/.yaml)")
+	historyPtr := fs.String("history", "", "将本次检测结果追加到历史记录文件 (JSON Lines)，供 report 子命令汇总")
+	textfilePtr := fs.String("textfile", "", "检测完成后写入node_exporter textfile collector格式的指标文件 (.prom)")
+	policyPtr := fs.String("policy", "", "镜像源选择策略文件 (YAML)")
+	printConfigPtr := fs.Bool("print-config", false, "检测完成后输出可直接使用的daemon.json配置")
+	emitPtr := fs.String("emit", "", "检测完成后输出写入所选镜像源的配置片段: ansible / cloud-init / shell / k8s-daemonset / docker-config / skopeo / crane")
+	emitFilePtr := fs.String("emit-file", "", "将 -emit 的配置片段写入文件，默认输出到标准输出")
+	verifyPullPtr := fs.Bool("verify-pull", false, "配置镜像源并重启Docker后，实际拉取一个镜像验证配置")
+	verifyImagePtr := fs.String("verify-image", "hello-world:latest", "拉取验证使用的镜像")
+	warmCachePtr := fs.String("warm-cache", "", "配置生效后通过新镜像源预先拉取的镜像，逗号分隔 (如 alpine:latest,nginx:latest)，或 @文件 (每行一个镜像)")
+	applyPtr := fs.String("apply", "", "非交互式配置镜像源，如 fastest 或 fastest:3 (写入最快的N个镜像源)")
+	mergePtr := fs.Bool("merge", false, "与 -apply 一起使用，把选出的镜像源合并到现有的镜像源中 (去重并按响应时间排序)，而不是替换")
+	sshPtr := fs.String("ssh", "", "与 -apply 一起使用，通过ssh把镜像源写入远程主机而不是本机，逗号分隔，如 root@host1,admin@host2:2222")
+	yesPtr := fs.Bool("yes", false, "跳过所有确认提示，与 -apply 一起用于无人值守的场景")
+	runtimePtr := fs.String("runtime", "docker", "配置镜像源的容器运行时 (docker/containerd/k3s/rke2/buildkit/buildx)")
+	answersPtr := fs.String("answers", "", "从YAML应答文件读取交互式提问的回答，用于自动化脚本")
+	dryRunPtr := fs.Bool("dry-run", false, "配置镜像源时只显示daemon.json的修改和将要执行的命令，不做任何修改")
+	recordPtr := fs.String("record", "", "将本次运行的所有网络交互录制到文件，用于问题复现")
+	replayPtr := fs.String("replay", "", "回放录制文件，不访问网络")
+	netnsPtr := fs.String("netns", "", "在指定的网络命名空间中检测，如 /proc/<pid>/ns/net (仅Linux，需要root权限)")
+	inContainerPtr := fs.String("in-container", "", "在指定容器的网络命名空间中检测，容器ID或名称 (仅Linux，需要root权限)")
+	var plugins stringsFlag
+	fs.Var(&plugins, "plugin", "外部探测插件的路径，可重复指定")
+	fs.Parse(args)
+	// -max-duration 从启动时开始计算，包括下载列表的时间
+	startTime := time.Now()
+	plainOutput = *plainPtr
+	// 参数之后的host直接作为检测列表，- 表示从标准输入读取
+	hostArgs := fs.Args()
+
+	// 在容器的网络环境 (DNS、代理、CNI) 中检测，结果可能与宿主机差别很大
+	netns := *netnsPtr
+	if *inContainerPtr != "" {
+		if netns != "" {
+			fmt.Fprintln(os.Stderr, "-netns 和 -in-container 不能同时使用")
+			os.Exit(2)
+		}
+		path, err := containerNetns(*inContainerPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		netns = path
+	}
+	if netns != "" {
+		code, err := runInNetns(netns, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if code >= 0 {
+			os.Exit(code)
+		}
+	}
 
 	timeout := time.Duration(*timeoutPtr * float64(time.Second))
 	numWorkers := *workersPtr
+	opts := checkOptions{
+		Timeout:   timeout,
+		Method:    strings.ToUpper(*methodPtr),
+		ProbePath: *probePathPtr,
+		HTTP3:     *http3Ptr,
+		Warm:      *warmPtr,
+		Warmup:    *warmupPtr,
+		Retries:   *retriesPtr,
+		PerIP:     *perIPPtr,
+		Plugins:   plugins,
+		Limiter:   newRateLimiter(*ratePtr),
+		Conns:     newConnLimiter(*maxConnsPtr),
+
+		ConnectTimeout:  time.Duration(*connectTimeoutPtr * float64(time.Second)),
+		TLSTimeout:      time.Duration(*tlsTimeoutPtr * float64(time.Second)),
+		ResponseTimeout: time.Duration(*responseTimeoutPtr * float64(time.Second)),
+		DeepWorkers:     *deepWorkersPtr,
+	}
+	if *ociPtr {
+		opts.OCIImage = *ociImagePtr
+	}
+	if *hedgePtr {
+		opts.Hedge = newHedger()
+	}
+	if *maxBandwidthPtr != "" {
+		rate, err := parseBandwidth(*maxBandwidthPtr)
+		if err != nil {
+			fmt.Fprintln(infoOut, err)
+			os.Exit(2)
+		}
+		opts.Bandwidth = newBandwidthLimiter(rate)
+	}
+	// 非表格输出时只输出结果本身，便于其他程序处理
+	interactive := *outputPtr == "table"
+	if !interactive {
+		infoOut = os.Stderr
+		noWait = true
+	}
+
+	if opts.Method != http.MethodGet && opts.Method != http.MethodHead {
+		fmt.Fprintf(infoOut, "不支持的请求方法: %s\n", *methodPtr)
+		os.Exit(2)
+	}
+	if !strings.HasPrefix(opts.ProbePath, "/") {
+		opts.ProbePath = "/" + opts.ProbePath
+	}
+	criteria, err := newSuccessCriteria(*expectStatusPtr, *rejectRedirectPtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	opts.Criteria = criteria
+	if err := sortResults(nil, *sortPtr); err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	if err := writeResults(io.Discard, nil, *outputPtr); err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
 
-	fmt.Printf("启动检测 (并发数: %d, 超时: %.1fs)\n", numWorkers, timeout.Seconds())
+	listFilter, err := parseEntryFilters(filters)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	includePatterns, err := compileHostPatterns("-include", includes)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	excludePatterns, err := compileHostPatterns("-exclude", excludes)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	blocklist, err := loadBlocklist(*blocklistPtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	pinned, err := pinnedFile(*pinnedPtr).load()
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	verifier, err := loadListVerifier(*listPubkeyPtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	githubProxies, err := parseGithubProxies(*githubProxyPtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	categories, err := parseCategories(*categoryPtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
 
-	// 处理文件更新逻辑
-	if *updatePtr {
-		fmt.Println("正在从GitHub更新docker.txt...")
-		if err := downloadFromGithub(); err != nil {
-			fmt.Printf("更新失败: %v\n", err)
-			waitForKeyPress()
-			return
+	var policy *Policy
+	if *policyPtr != "" {
+		var err error
+		if policy, err = loadPolicy(*policyPtr); err != nil {
+			fmt.Fprintln(infoOut, err)
+			os.Exit(2)
 		}
-		fmt.Println("更新成功!")
-	} else if _, err := os.Stat("docker.txt"); os.IsNotExist(err) {
-		fmt.Println("本地未找到docker.txt，正在从GitHub下载...")
-		if err := downloadFromGithub(); err != nil {
-			fmt.Printf("下载失败: %v\n", err)
-			waitForKeyPress()
-			return
+	}
+
+	switch {
+	case *recordPtr != "" && *replayPtr != "":
+		fmt.Fprintln(infoOut, "-record 和 -replay 不能同时使用")
+		os.Exit(2)
+	case *resumePtr && (*checkpointPtr == "" || *recordPtr != "" || *replayPtr != ""):
+		fmt.Fprintln(infoOut, "-resume 需要 -checkpoint 文件，不能与 -record 或 -replay 同时使用")
+		os.Exit(2)
+	case *cachePtr < 0:
+		fmt.Fprintln(infoOut, "-cache 不能为负数")
+		os.Exit(2)
+	case *cachePtr > 0 && (*recordPtr != "" || *replayPtr != ""):
+		fmt.Fprintln(infoOut, "-cache 不能与 -record 或 -replay 同时使用")
+		os.Exit(2)
+	case *livePtr && (*plainPtr || *outputPtr != "table"):
+		fmt.Fprintln(infoOut, "-live 只支持表格输出，不能与 -plain 或 -output 同时使用")
+		os.Exit(2)
+	case *hedgePtr && (*recordPtr != "" || *replayPtr != ""):
+		// 对冲请求是否发出取决于实时的响应时间，录制的交互无法按原样回放
+		fmt.Fprintln(infoOut, "-hedge 不能与 -record 或 -replay 同时使用")
+		os.Exit(2)
+	case *currentPtr && (*replayPtr != "" || *applyPtr != ""):
+		fmt.Fprintln(infoOut, "-current 不能与 -replay 或 -apply 同时使用")
+		os.Exit(2)
+	case *sshPtr != "" && *applyPtr == "":
+		fmt.Fprintln(infoOut, "-ssh 需要与 -apply 一起使用")
+		os.Exit(2)
+	case *sshPtr != "" && (*runtimePtr != "docker" || *verifyPullPtr || *warmCachePtr != ""):
+		fmt.Fprintln(infoOut, "-ssh 只支持配置Docker，不能与 -runtime、-verify-pull 或 -warm-cache 同时使用")
+		os.Exit(2)
+	case *emitFilePtr != "" && *emitPtr == "":
+		fmt.Fprintln(infoOut, "-emit-file 需要与 -emit 一起使用")
+		os.Exit(2)
+	case *ratePtr < 0:
+		fmt.Fprintln(infoOut, "-rate 不能为负数")
+		os.Exit(2)
+	case *maxConnsPtr < 0:
+		fmt.Fprintln(infoOut, "-max-conns 不能为负数")
+		os.Exit(2)
+	case *samplePtr < 0:
+		fmt.Fprintln(infoOut, "-sample 不能为负数")
+		os.Exit(2)
+	case *connectTimeoutPtr < 0 || *tlsTimeoutPtr < 0 || *responseTimeoutPtr < 0:
+		fmt.Fprintln(infoOut, "-connect-timeout、-tls-timeout 和 -response-timeout 不能为负数")
+		os.Exit(2)
+	case *mergePtr && *applyPtr == "":
+		fmt.Fprintln(infoOut, "-merge 需要与 -apply 一起使用")
+		os.Exit(2)
+	case *importPtr != "" && (*currentPtr || *replayPtr != ""):
+		fmt.Fprintln(infoOut, "-import 不能与 -current 或 -replay 同时使用")
+		os.Exit(2)
+	case len(lists) > 0 && (*importPtr != "" || *currentPtr || *replayPtr != "" || *updatePtr || *autoUpdatePtr > 0):
+		fmt.Fprintln(infoOut, "-list 不能与 -import、-current、-replay、-update 或 -auto-update-list 同时使用")
+		os.Exit(2)
+	case *categoryPtr != "" && (len(lists) > 0 || len(hostArgs) > 0 || *importPtr != "" || *currentPtr || *replayPtr != ""):
+		fmt.Fprintln(infoOut, "-category 不能与 -list、-import、-current、-replay 或直接指定的host同时使用")
+		os.Exit(2)
+	case len(hostArgs) > 0 && (len(lists) > 0 || *importPtr != "" || *currentPtr || *replayPtr != "" || *updatePtr || *autoUpdatePtr > 0):
+		fmt.Fprintln(infoOut, "直接指定host时不能再使用 -list、-import、-current、-replay、-update 或 -auto-update-list")
+		os.Exit(2)
+	case *recordPtr != "":
+		opts.Tape = newRecordTape(args)
+	case *replayPtr != "":
+		replay, err := loadTape(*replayPtr)
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			os.Exit(2)
 		}
-		fmt.Println("下载成功!")
+		opts.Tape = replay
 	}
 
-	// 打开docker.txt文件
-	file, err := os.Open("docker.txt")
+	applyCount := 0
+	if *applyPtr != "" {
+		count, err := parseApplySpec(*applyPtr)
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			os.Exit(2)
+		}
+		if !canApply() && *sshPtr == "" {
+			fmt.Fprintln(infoOut, "-apply 目前只支持Linux和macOS")
+			os.Exit(2)
+		}
+		applyCount = count
+	}
+	if *emitPtr != "" {
+		if err := validateEmitFormat(*emitPtr, *runtimePtr); err != nil {
+			fmt.Fprintln(infoOut, err)
+			os.Exit(2)
+		}
+	}
+	var sshHosts []string
+	if *sshPtr != "" {
+		sshHosts, err = parseSSHHosts(*sshPtr)
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *yesPtr {
+		noWait = true
+	}
+	target, err := selectTarget(*runtimePtr)
 	if err != nil {
-		fmt.Printf("无法打开docker.txt文件: %v\n", err)
-		waitForKeyPress()
-		return
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	if *verifyPullPtr && !target.isDockerd() {
+		fmt.Fprintf(infoOut, "-verify-pull 只支持Docker，不支持%s\n", target.Name)
+		os.Exit(2)
+	}
+	warmImages, err := parseWarmImages(*warmCachePtr)
+	if err != nil {
+		fmt.Fprintln(infoOut, err)
+		os.Exit(2)
+	}
+	if len(warmImages) > 0 && !target.isDockerd() {
+		fmt.Fprintf(infoOut, "-warm-cache 只支持Docker，不支持%s\n", target.Name)
+		os.Exit(2)
+	}
+	if err := useAnswersFile(*answersPtr); err != nil {
+		fmt.Fprintf(infoOut, "%v\n", err)
+		os.Exit(2)
 	}
-	defer file.Close()
 
-	// 读取所有hosts
-	var hosts []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		host := strings.TrimSpace(scanner.Text())
-		if host != "" && !strings.HasPrefix(host, "#") {
-			hosts = append(hosts, host)
+	// 内存中的检测结果，结果写入临时文件时只有可用的镜像源
+	var allResults []CheckResult
+	// 完成检测的镜像源数量
+	checked := 0
+	applied := false
+	// -apply 模式下没有成功配置时以非0状态码退出
+	exitCode := 0
+	if applyCount > 0 {
+		exitCode = 1
+	}
+	// 无论检测是否成功，最后都输出一行汇总供日志采集，然后等待按键；
+	// JSON/CSV/YAML输出时汇总行写入stderr，stdout只有结果本身
+	defer func() {
+		printResultLine(infoOut, allResults, checked, applied)
+		waitForKeyPress()
+		if exitCode != 0 {
+			os.Exit(exitCode)
 		}
+	}()
+
+	if *ratePtr > 0 {
+		fmt.Fprintf(infoOut, "启动检测 (并发数: %s, 超时: %.1fs, 每秒最多 %g 个请求)\n", workersLabel(numWorkers), timeout.Seconds(), *ratePtr)
+	} else {
+		fmt.Fprintf(infoOut, "启动检测 (并发数: %s, 超时: %.1fs)\n", workersLabel(numWorkers), timeout.Seconds())
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("读取文件出错: %v\n", err)
-		waitForKeyPress()
-		return
+	var entries []listEntry
+	if opts.Tape != nil && opts.Tape.replay {
+		fmt.Fprintf(infoOut, "正在回放 %s (录制时的参数: %s)\n", *replayPtr, strings.Join(opts.Tape.Args, " "))
+		entries = opts.Tape.Entries
+	} else if len(hostArgs) > 0 {
+		var err error
+		if entries, err = readArgEntries(hostArgs, os.Stdin); err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			return
+		}
+		// 标准输入已经被读完，无法再等待按键
+		if containsString(hostArgs, "-") {
+			noWait = true
+		}
+	} else if *importPtr != "" {
+		var err error
+		if entries, err = importMirrors(*importPtr); err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			return
+		}
+	} else if *currentPtr {
+		var err error
+		if entries, err = currentMirrorEntries(target); err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			return
+		}
+	} else {
+		var err error
+		sourceOpts := listSourceOptions{
+			Lists:         lists,
+			Categories:    categories,
+			Update:        *updatePtr,
+			MaxAge:        *autoUpdatePtr,
+			GithubProxies: githubProxies,
+			Verifier:      verifier,
+		}
+		if entries, err = loadCheckList(sourceOpts); err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			return
+		}
+		// 置顶的镜像源 (如公司内部的镜像源) 不在列表中时也要检测
+		entries = append(entries, pinned...)
 	}
 
-	if len(hosts) == 0 {
-		fmt.Println("docker.txt 文件为空或没有有效的主机地址")
-		waitForKeyPress()
+	if len(entries) == 0 {
+		fmt.Fprintln(infoOut, "检测列表为空或没有有效的主机地址")
 		return
 	}
-
-	// 创建任务和结果通道
-	jobs := make(chan string, len(hosts))
-	results := make(chan CheckResult, len(hosts))
-
-	// 启动worker池
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go worker(i, jobs, results, timeout, &wg)
+	if len(listFilter) > 0 {
+		if entries = listFilter.apply(entries); len(entries) == 0 {
+			fmt.Fprintln(infoOut, "没有满足 -filter 条件的镜像源")
+			return
+		}
+	}
+	if len(blocklist) > 0 {
+		var skipped []string
+		if entries, skipped = filterBlocked(entries, blocklist); len(skipped) > 0 {
+			fmt.Fprintf(infoOut, "跳过黑名单 (%s) 中的 %d 个镜像源: %s\n", *blocklistPtr, len(skipped), strings.Join(skipped, ", "))
+		}
+		if len(entries) == 0 {
+			fmt.Fprintln(infoOut, "所有镜像源都在黑名单中")
+			return
+		}
+	}
+	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		total := len(entries)
+		if entries = filterHosts(entries, includePatterns, excludePatterns); len(entries) == 0 {
+			fmt.Fprintln(infoOut, "没有满足 -include / -exclude 条件的镜像源")
+			return
+		}
+		if skipped := total - len(entries); skipped > 0 {
+			fmt.Fprintf(infoOut, "按 -include / -exclude 跳过了 %d 个镜像源\n", skipped)
+		}
 	}
 
-	// 发送所有任务
-	for _, host := range hosts {
-		jobs <- host
+	checkEntries := dedupeEntries(entries, infoOut)
+	if *shufflePtr || *samplePtr > 0 {
+		seed := *seedPtr
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		total := len(checkEntries)
+		checkEntries = sampleEntries(checkEntries, *samplePtr, rand.New(rand.NewSource(seed)))
+		if len(checkEntries) < total {
+			// 录制和来源统计只包含抽取的镜像源
+			entries = keepEntries(entries, checkEntries)
+			fmt.Fprintf(infoOut, "随机抽取 %d/%d 个镜像源 (-seed %d 可以重复这次抽样)\n", len(checkEntries), total, seed)
+		}
 	}
-	close(jobs)
 
-	// 收集结果
-	allResults := make([]CheckResult, 0, len(hosts))
-	resultCount := 0
+	// 已经有结果的镜像源不再检测
+	pendingEntries := checkEntries
+	var resumed []CheckResult
+	progressHeader := newCheckpointHeader(opts, checkEntries)
+	if *resumePtr {
+		header, checkpointed, err := loadCheckpoint(*checkpointPtr)
+		if err == nil {
+			if err = header.match(progressHeader); err != nil {
+				err = fmt.Errorf("无法从检查点 %s 恢复，%v\n请使用与上次相同的参数和列表，或去掉 -resume 重新检测", *checkpointPtr, err)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v\n", err)
+			// 与其他无效参数一样以2退出，脚本不会误以为检测成功
+			exitCode = 2
+			return
+		}
+		resumed, pendingEntries = resumeEntries(checkEntries, checkpointed)
+		fmt.Fprintf(infoOut, "从检查点 %s 恢复了 %d 个结果，还需检测 %d 个镜像源\n", *checkpointPtr, len(resumed), len(pendingEntries))
+	}
+	// 回放时不访问网络，很快就能完成，不需要检查点
+	var progressFile *checkpoint
+	if *checkpointPtr != "" && *replayPtr == "" {
+		var err error
+		// 不使用 -resume 时保留上次没有完成的检查点，而不是覆盖
+		if !*resumePtr {
+			var old string
+			if old, err = rotateCheckpoint(*checkpointPtr); err == nil && old != "" {
+				fmt.Fprintf(infoOut, "上次没有完成的检查点已移动到 %s，需要时可以用 -checkpoint %s -resume 继续\n", old, shellQuote([]string{old}))
+			}
+		}
+		if err == nil {
+			progressFile, err = createCheckpoint(*checkpointPtr, progressHeader, resumed)
+		}
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v，不保存检测进度\n", err)
+		}
+	}
 
-	// 在后台等待所有worker完成并关闭results通道
+	// Ctrl+C 时取消进行中的检测并显示已完成的结果，检测结束后恢复默认处理 (再次按Ctrl+C立即退出)
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	go func() {
-		wg.Wait()
-		close(results)
+		<-signalCtx.Done()
+		stopSignals()
 	}()
+	ctx := signalCtx
+	if *maxDurationPtr > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(signalCtx, startTime.Add(*maxDurationPtr))
+		defer cancel()
+	}
+
+	// 比对基准只从Docker Hub获取一次，获取失败时跳过比对
+	if *integrityPtr {
+		// 从Docker Hub下载基准镜像层同样受 -max-bandwidth 和 -max-conns 限制
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = opts.limitDial(transport.DialContext, opts.Bandwidth)
+		client := &http.Client{Timeout: timeout, Transport: opts.Tape.wrap(transport, "integrity")}
+		reference, err := fetchIntegrityReference(ctx, client, *integrityImagePtr)
+		// 空闲的连接会一直占用 -max-conns 的名额
+		transport.CloseIdleConnections()
+		if err != nil {
+			fmt.Fprintf(infoOut, "%v，跳过内容比对\n", err)
+		} else {
+			opts.Integrity = reference
+		}
+	}
+
+	// 缓存中仍然有效的结果不再检测 (需要在确定比对基准之后，内容比对是否开启会影响结果)
+	var cache *resultCache
+	var cached []CheckResult
+	cacheOptions := opts.cacheOptions()
+	if *cachePtr > 0 {
+		var err error
+		if cache, err = loadResultCache(*cacheFilePtr); err != nil {
+			fmt.Fprintf(infoOut, "%v，不使用缓存\n", err)
+		} else if cached, pendingEntries = cache.lookup(pendingEntries, cacheOptions, *cachePtr, time.Now()); len(cached) > 0 {
+			fmt.Fprintf(infoOut, "使用 %d 个 %s 内缓存的结果，还需检测 %d 个镜像源\n", len(cached), *cachePtr, len(pendingEntries))
+		}
+	}
 
 	// 显示进度并收集结果
-	fmt.Println() // 为进度条留出空行
+	if interactive && !plainOutput {
+		fmt.Println() // 为进度条留出空行
+	}
 
-	for result := range results {
-		resultCount++
-		allResults = append(allResults, result)
-		showProgress(resultCount, len(hosts))
+	// 置顶和当前已配置的镜像源最先检测，完成后立即显示
+	var configured []string
+	if config, err := target.readConfig(); err == nil {
+		configured = config.RegistryMirrors
+	}
+	opts.Priority = newJobPriority(pinned, configured)
+	// 重试时跳过连续连接失败的镜像源，并限制本轮的重试总次数
+	if opts.Retries > 0 && !*noBreakerPtr {
+		opts.Breaker = newHostBreaker(len(pendingEntries))
 	}
 
-	// 根据-l参数过滤结果
-	var displayResults []CheckResult
-	if *listSuccessPtr {
-		for _, result := range allResults {
-			if result.Available && !result.IsTimeout {
-				displayResults = append(displayResults, result)
+	sources := sourcesByHost(entries)
+	priority := pinPriority(pinned)
+	spool := newResultSpool(*streamThresholdPtr)
+	defer spool.close()
+	progress := newProgressBar(len(checkEntries), plainOutput)
+	var live *liveTable
+	if *livePtr {
+		// 默认按host排序，实时表格要让最快的镜像源显示在最上面
+		liveSort := *sortPtr
+		if liveSort == "host" {
+			liveSort = "time"
+		}
+		if live = newLiveTable(len(checkEntries), liveSort); live == nil {
+			fmt.Fprintln(infoOut, "标准输出不是终端，不使用 -live")
+		}
+	}
+	collect := func(result CheckResult) {
+		result.Sources = sources[result.Host]
+		result.Pinned = priority[result.Host]
+		spool.add(result)
+		if live != nil {
+			live.add(result)
+		} else if interactive {
+			progress.add(result)
+		}
+	}
+	for _, result := range resumed {
+		collect(result)
+	}
+	for _, result := range cached {
+		collect(result)
+	}
+	checkEach(ctx, pendingEntries, numWorkers, opts, func(result CheckResult) {
+		progressFile.add(result)
+		// 只检测已配置的镜像源时所有结果都是优先的，不需要提前显示；实时表格中已经包含
+		if !*currentPtr && live == nil {
+			if interactive {
+				progress.clear()
+				opts.Priority.announce(os.Stdout, result)
+			} else {
+				opts.Priority.announce(infoOut, result)
 			}
 		}
+		collect(result)
+	})
+	if live != nil {
+		live.finish()
+	}
+	allResults = spool.results
+	checked = spool.count
+	interrupted := signalCtx.Err() != nil
+	// 超过 -max-duration 时已完成的结果仍然有效，照常推荐和配置
+	timedOut := !interrupted && ctx.Err() != nil
+	stopSignals()
+
+	// 中断时已完成的结果同样有效
+	if cache != nil {
+		now := time.Now()
+		err := spool.each(func(result CheckResult) error {
+			cache.store(result, cacheOptions, now)
+			return nil
+		})
+		if err == nil {
+			err = cache.save(*cacheFilePtr, *cachePtr, now)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		}
+	}
+
+	if progressFile != nil && progressFile.err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", progressFile.err)
+	}
+	// 没有全部完成时保留检查点，之后可以继续检测剩余的镜像源
+	if interrupted || timedOut {
+		progressFile.close()
+		if progressFile != nil && progressFile.err == nil {
+			fmt.Fprintf(infoOut, "\n检测进度已保存到 %s，使用 -resume 继续检测剩余的镜像源", *checkpointPtr)
+		}
 	} else {
-		displayResults = allResults
+		progressFile.remove()
 	}
 
-	// 按主机名排序结果
-	sort.Slice(displayResults, func(i, j int) bool {
-		return displayResults[i].Host < displayResults[j].Host
-	})
+	if timedOut {
+		fmt.Fprintf(infoOut, "\n已达到 -max-duration %s，取消了剩余的 %d 个检测\n", *maxDurationPtr, len(checkEntries)-checked)
+	}
 
-	// 清除进度条并显示结果
-	fmt.Println("\n\nRegistry                        状态       状态码     响应时间")
-	fmt.Println(strings.Repeat("-", 65))
+	if interrupted {
+		fmt.Fprintf(infoOut, "\n检测已中断，已完成 %d/%d 个镜像源，只显示已完成的结果\n", checked, len(checkEntries))
+		// 与被SIGINT终止时的退出码一致
+		exitCode = 130
+		noWait = true
+	}
+
+	// 策略用到的ASN查询也需要录制，回放时不再访问网络
+	if policy != nil && !interrupted && !timedOut {
+		policy.resolveASN(allResults, opts.Tape)
+	}
 
-	for _, result := range displayResults {
-		status := "✓"
-		if !result.Available {
-			status = "✗"
+	// 中断时的录制和历史记录不完整，不保存；超时的录制回放时缺少未完成的镜像源，同样不保存
+	if *recordPtr != "" && (interrupted || timedOut) {
+		fmt.Fprintf(infoOut, "\n检测没有全部完成，不保存录制文件 %s\n", *recordPtr)
+	} else if *recordPtr != "" {
+		opts.Tape.Entries = entries
+		if err := opts.Tape.save(*recordPtr); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		} else {
+			fmt.Fprintf(infoOut, "\n网络交互已录制到 %s\n", *recordPtr)
 		}
+	}
 
-		statusCode := fmt.Sprintf("%d", result.StatusCode)
-		if result.StatusCode == 0 {
-			statusCode = "-"
+	// 结果太多时不在内存中保存全部结果，只输出和保存可用的镜像源
+	if spool.spilled() {
+		fmt.Fprintf(infoOut, "\n检测的镜像源超过 -stream-threshold %d，完整结果暂存在临时文件中，内存中只保留 %d 个可用的镜像源\n", *streamThresholdPtr, len(allResults))
+		if spool.err != nil {
+			fmt.Fprintf(os.Stderr, "%v，之后的结果只保留可用的镜像源\n", spool.err)
 		}
+		if *historyPtr != "" || *textfilePtr != "" {
+			fmt.Fprintln(infoOut, "不写入 -history 和 -textfile")
+		}
+	}
 
-		timeStr := "超时"
-		if !result.IsTimeout {
-			timeStr = fmt.Sprintf("%.2fs", result.Time.Seconds())
+	if *historyPtr != "" && *replayPtr == "" && !interrupted && !spool.spilled() {
+		if err := appendHistory(*historyPtr, time.Now(), allResults); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
 		}
+	}
 
-		fmt.Printf("%-30s %-10s %-10s %-15s\n",
-			result.Host,
-			status,
-			statusCode,
-			timeStr,
-		)
+	if *textfilePtr != "" && !spool.spilled() {
+		if err := writeTextfile(*textfilePtr, allResults, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "\n写入指标文件失败: %v\n", err)
+		}
+	}
+
+	if *savePtr != "" {
+		save := saveResults
+		if spool.spilled() {
+			// 按完成顺序从临时文件写入，不排序
+			save = func(path string, _ []CheckResult) error { return saveSpool(path, spool) }
+		}
+		if err := save(*savePtr, allResults); err != nil {
+			fmt.Fprintf(os.Stderr, "\n保存结果失败: %v\n", err)
+		} else if interactive {
+			fmt.Printf("\n结果已保存到 %s", *savePtr)
+		}
+	}
+
+	// 根据-l参数过滤结果
+	var displayResults []CheckResult
+	if *listSuccessPtr {
+		displayResults = filterSuccess(allResults)
+	} else {
+		displayResults = append([]CheckResult(nil), allResults...)
+	}
+
+	sortResults(displayResults, *sortPtr)
+	pinFirst(displayResults)
+
+	if !interactive && spool.spilled() {
+		if err := writeSpool(os.Stdout, spool, *outputPtr, *listSuccessPtr); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if !interactive {
+		if err := writeResults(os.Stdout, displayResults, *outputPtr); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 清除进度条并显示结果
+	fmt.Print("\n\n")
+	writeTable(os.Stdout, displayResults)
+	writeDetails(os.Stdout, displayResults)
+	if !spool.spilled() {
+		writeSourceStats(os.Stdout, allResults)
+	}
+
+	// 只检测已配置的镜像源时不再重新配置
+	if *currentPtr {
+		writeCurrentSummary(os.Stdout, allResults)
+		return
 	}
 
 	// 显示统计信息
-	totalCount := len(allResults)
-	successCount := 0
-	for _, result := range allResults {
-		if result.Available && !result.IsTimeout {
-			successCount++
+	successResults := filterSuccess(allResults)
+	if interrupted {
+		// 只检测了部分镜像源，不推荐也不修改配置
+		fmt.Printf("\n检测已中断! (成功: %d, 已完成: %d/%d)\n", len(successResults), checked, len(checkEntries))
+		return
+	}
+	fmt.Printf("\n检测完成! (成功: %d, 总计: %d)\n", len(successResults), checked)
+
+	// 其他上游的镜像源不能写入registry-mirrors
+	if others := len(successResults) - len(filterDockerHub(successResults)); others > 0 {
+		fmt.Printf("其中 %d 个为非Docker Hub上游的镜像源，不会写入daemon.json\n", others)
+		successResults = filterDockerHub(successResults)
+	}
+
+	// 本机缺少对应地址族时，只有单一地址族的镜像源配置了也用不上
+	network := detectLocalNetwork()
+	network.warn(os.Stdout, allResults)
+	successResults = network.filter(successResults)
+
+	// 按策略筛选要应用的镜像源
+	if policy != nil {
+		successResults = policy.Select(successResults, opts.Tape)
+		fmt.Printf("\n按策略选出的镜像源 (%d 个):\n", len(successResults))
+		for i, result := range successResults {
+			fmt.Printf("%d. %s (响应时间: %.2fs)\n", i+1, result.Host, result.Time.Seconds())
 		}
 	}
-	var successResults []CheckResult
-	for _, result := range allResults {
-		if result.Available && !result.IsTimeout {
-			successResults = append(successResults, result)
+
+	writeRecommended(os.Stdout, successResults)
+
+	if *printConfigPtr {
+		printSuggestedConfig(successResults)
+	}
+
+	// 使用 -apply 时与写入的镜像源一致，否则为推荐的镜像源
+	if *emitPtr != "" {
+		var mirrors []string
+		if applyCount > 0 {
+			mirrors = fastestMirrors(successResults, applyCount)
+		} else {
+			for _, result := range recommend(successResults, recommendCount) {
+				mirrors = append(mirrors, mirrorURL(result))
+			}
+		}
+		if err := emitSnippet(*emitPtr, *emitFilePtr, mirrors, *runtimePtr); err != nil {
+			fmt.Println(err)
 		}
 	}
 
-	fmt.Printf("\n检测完成! (成功: %d, 总计: %d)\n", successCount, totalCount)
+	applyOpts := applyOptions{DryRun: *dryRunPtr, Target: target, WarmImages: warmImages, Args: args, Merge: *mergePtr, Blocked: blocklist}
+	if *verifyPullPtr {
+		applyOpts.VerifyImage = *verifyImagePtr
+	}
 
-	// Linux系统特殊处理
-	if runtime.GOOS == "linux" {
-		fmt.Println("\n检测到Linux系统，是否进行镜像源配置？(y/n)")
-		reader := bufio.NewReader(os.Stdin)
-		answer, _ := reader.ReadString('\n')
-		answer = strings.TrimSpace(strings.ToLower(answer))
+	// 非交互式配置
+	if applyCount > 0 {
+		if *replayPtr != "" {
+			fmt.Println("\n回放时不修改本机配置")
+			return
+		}
+		// 只配置远程主机，不修改本机
+		if len(sshHosts) > 0 {
+			if !*yesPtr && !confirm("apply", fmt.Sprintf("\n将把最快的 %d 个镜像源写入 %d 台远程主机的 %s 并重新加载Docker，是否继续? (y/n): ", applyCount, len(sshHosts), daemonConfigPath)) {
+				return
+			}
+			if len(successResults) == 0 {
+				fmt.Println("配置失败: 没有可用的镜像源")
+				return
+			}
+			if applyRemoteHosts(sshHosts, fastestMirrors(successResults, applyCount), successResults, applyOpts) == 0 {
+				applied = !*dryRunPtr
+				exitCode = 0
+			}
+			return
+		}
+		action := "写入"
+		if *mergePtr {
+			action = "合并到"
+		}
+		if !*yesPtr && !confirm("apply", fmt.Sprintf("\n将把最快的 %d 个镜像源%s %s 并重新加载%s，是否继续? (y/n): ", applyCount, action, target.ConfigPath, target.Name)) {
+			return
+		}
+		if err := applyFastest(successResults, applyCount, applyOpts); err != nil {
+			fmt.Printf("配置失败: %v\n", err)
+			return
+		}
+		applied = !*dryRunPtr
+		exitCode = 0
+		return
+	}
 
-		if answer == "y" || answer == "yes" {
-			if err := handleLinuxSystem(successResults); err != nil {
+	// Linux和macOS上可以直接配置镜像源，回放时不修改本机配置；-yes 表示无人值守，没有 -apply 时不配置
+	if canApply() && *replayPtr == "" && !*yesPtr {
+		if confirm("configure", fmt.Sprintf("\n检测到%s，是否进行镜像源配置？(y/n)\n", systemName(applyOpts.Target))) {
+			if err := handleInteractiveApply(successResults, applyOpts); err != nil {
 				fmt.Printf("配置失败: %v\n", err)
+			} else {
+				applied = !*dryRunPtr
+			}
+		}
+	}
+}
+
+// 检测列表的来源
+type listSourceOptions struct {
+	// -list 指定的列表，为空时使用各分类的列表文件
+	Lists []string
+	// -category 选择的分类，为空时只检测 docker-hub (docker.txt)
+	Categories []listCategory
+	// 强制更新列表文件
+	Update bool
+	// 列表文件超过该时间没有检查更新时自动检查，0表示不自动更新
+	MaxAge time.Duration
+	// 下载列表文件时依次尝试的方式
+	GithubProxies []string
+	// 下载的列表需要通过的签名校验，为nil时不校验
+	Verifier *listVerifier
+}
+
+// 读取 -list 指定的列表；没有指定时读取所选分类的列表文件 (默认为docker.txt)，需要时先从GitHub下载
+//
+// MaxAge大于0时，列表文件超过MaxAge没有检查更新就自动检查一次。
+// 无法访问GitHub时使用本地已有的列表文件，本地也没有时使用程序内置的列表。
+// 存在本地补充列表 (如 docker.local.txt) 时合并在下载的列表之前。
+func loadCheckList(opts listSourceOptions) ([]listEntry, error) {
+	if len(opts.Lists) > 0 {
+		return readLists(opts.Lists, opts.Verifier)
+	}
+	categories := opts.Categories
+	if len(categories) == 0 {
+		categories = listCategories[:1]
+	}
+	var entries []listEntry
+	for _, category := range categories {
+		list, err := loadCategoryList(category, opts)
+		if err != nil {
+			return nil, err
+		}
+		local, err := category.readLocal()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, local...)
+		entries = append(entries, list...)
+	}
+	return entries, nil
+}
+
+// 读取一个分类的列表文件，需要时先从GitHub下载
+func loadCategoryList(category listCategory, opts listSourceOptions) ([]listEntry, error) {
+	name := category.File
+	update, force := opts.Update, opts.Update
+	exists := fileExists(name)
+	if !update && exists && opts.MaxAge > 0 && listStale(name, opts.MaxAge) {
+		fmt.Fprintf(infoOut, "%s超过%s没有更新，", name, opts.MaxAge)
+		update = true
+	}
+	if update {
+		fmt.Fprintf(infoOut, "正在从GitHub更新%s...\n", name)
+		if changed, err := downloadFromGithub(name, opts.GithubProxies, opts.Verifier, force); err == nil {
+			if changed {
+				fmt.Fprintln(infoOut, "更新成功!")
+			} else {
+				fmt.Fprintf(infoOut, "%s已是最新\n", name)
+			}
+		} else if exists {
+			fmt.Fprintf(infoOut, "更新失败: %v，继续使用本地的%s\n", err, name)
+		} else {
+			fmt.Fprintf(infoOut, "更新失败: %v\n", err)
+			return category.readBuiltin()
+		}
+	} else if !exists {
+		fmt.Fprintf(infoOut, "本地未找到%s，正在从GitHub下载...\n", name)
+		if _, err := downloadFromGithub(name, opts.GithubProxies, opts.Verifier, false); err != nil {
+			fmt.Fprintln(infoOut, err)
+			return category.readBuiltin()
+		}
+		fmt.Fprintln(infoOut, "下载成功!")
+	}
+
+	// 没有下载或下载失败时使用的本地列表同样需要通过签名校验
+	if opts.Verifier != nil {
+		data, err := opts.Verifier.readFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("本地的%s没有通过签名校验，拒绝使用: %v (请使用 -update 重新下载)", name, err)
+		}
+		return newCategoryParser(category).parse(data, filepath.Clean(name))
+	}
+	return category.read()
+}
+
+// 按响应时间输出可直接写入daemon.json的镜像源配置
+func printSuggestedConfig(successResults []CheckResult) {
+	if len(successResults) == 0 {
+		fmt.Println("\n没有可用的镜像源")
+		return
+	}
+
+	sorted := append([]CheckResult(nil), successResults...)
+	sortResults(sorted, "time")
+
+	config := DaemonConfig{}
+	var mirrors []string
+	for _, result := range sorted {
+		mirrors = append(mirrors, mirrorURL(result))
+	}
+	config.setMirrors(mirrors)
+	data, _ := json.MarshalIndent(config, "", "    ")
+
+	fmt.Println("\n推荐的daemon.json配置 (按响应时间排序):")
+	fmt.Println(string(data))
+	target := detectDockerTarget()
+	switch {
+	case runtime.GOOS == "windows" || target.Name == "Docker Desktop":
+		fmt.Println("\n打开 Docker Desktop -> Settings -> Docker Engine，将 registry-mirrors 字段替换为以上内容后点击 Apply & restart")
+	case target.Format == configColima:
+		fmt.Printf("\n将 registry-mirrors 加入 %s 的 docker 字段后执行 %s\n", target.ConfigPath, target.Restart)
+	case target.DaemonReload == "":
+		fmt.Printf("\n将以上内容写入 %s 后执行 %s\n", target.ConfigPath, target.Restart)
+	default:
+		fmt.Printf("\n将以上内容写入 %s 后执行 %s && %s\n", target.ConfigPath, target.DaemonReload, target.Restart)
+	}
+}
+
+// 输出固定格式的单行汇总，便于日志采集程序解析，如:
+//
+//	RESULT ok=12 fail=30 best=mirror.x.com latency=0.42s applied=false
+//
+// total为完成检测的镜像源数量，results可能只包含其中可用的镜像源。
+func printResultLine(w io.Writer, results []CheckResult, total int, applied bool) {
+	successResults := filterSuccess(results)
+
+	best, latency := "-", "-"
+	if len(successResults) > 0 {
+		fastest := successResults[0]
+		for _, result := range successResults[1:] {
+			if result.Time < fastest.Time {
+				fastest = result
 			}
 		}
+		best = fastest.Host
+		latency = fmt.Sprintf("%.2fs", fastest.Time.Seconds())
 	}
 
-	waitForKeyPress()
+	fmt.Fprintf(w, "RESULT ok=%d fail=%d best=%s latency=%s applied=%t\n",
+		len(successResults), total-len(successResults), best, latency, applied)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// 交互式菜单，双击启动时使用
+func runMenu() {
+	for {
+		fmt.Println("\nDocker Registry Checker")
+		fmt.Println("1. 快速检测 (只显示可用的镜像源)")
+		fmt.Println("2. 全面检测 (失败重试、复用连接测速、HTTP/3和OCI能力探测)")
+		fmt.Println("3. 检测并生成镜像源配置")
+		fmt.Println("4. 退出")
+
+		choice, err := prompter.Ask("menu", "请输入选项 (1-4): ")
+		if err != nil {
+			return
+		}
+
+		var args []string
+		switch choice {
+		case "1":
+			args = []string{"-method", "HEAD", "-timeout", "5", "-l", "-sort", "time"}
+		case "2":
+			args = []string{"-retries", "2", "-warm", "-http3", "-oci", "-sort", "time"}
+		case "3":
+			args = []string{"-l", "-sort", "time", "-print-config"}
+		case "4", "q", "exit":
+			return
+		default:
+			fmt.Println("无效的选项")
+			continue
+		}
+
+		// 执行完后回到菜单，而不是等待按键退出
+		noWait = true
+		runCheck(args)
+		noWait = false
+
+		if _, err := prompter.Ask("menu", "\n按回车键返回菜单..."); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 以Prometheus文本格式输出检测结果
+func writeMetrics(w io.Writer, results []CheckResult, runAt time.Time) {
+	fmt.Fprintln(w, "# HELP docker_registry_mirror_up Whether the registry mirror is available (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE docker_registry_mirror_up gauge")
+	for _, result := range results {
+		up := 0
+		if isSuccess(result) {
+			up = 1
+		}
+		fmt.Fprintf(w, "docker_registry_mirror_up{host=\"%s\"} %d\n", escapeLabel(result.Host), up)
+	}
+
+	fmt.Fprintln(w, "# HELP docker_registry_mirror_response_seconds Response time of the probe request.")
+	fmt.Fprintln(w, "# TYPE docker_registry_mirror_response_seconds gauge")
+	for _, result := range results {
+		if result.IsTimeout || result.StatusCode == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "docker_registry_mirror_response_seconds{host=\"%s\"} %.6f\n", escapeLabel(result.Host), result.Time.Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP docker_registry_mirror_ttfb_seconds Time to first byte of the probe response.")
+	fmt.Fprintln(w, "# TYPE docker_registry_mirror_ttfb_seconds gauge")
+	for _, result := range results {
+		if result.TTFB == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "docker_registry_mirror_ttfb_seconds{host=\"%s\"} %.6f\n", escapeLabel(result.Host), result.TTFB.Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP docker_registry_mirror_status_code HTTP status code of the probe request, 0 if no response.")
+	fmt.Fprintln(w, "# TYPE docker_registry_mirror_status_code gauge")
+	for _, result := range results {
+		fmt.Fprintf(w, "docker_registry_mirror_status_code{host=\"%s\"} %d\n", escapeLabel(result.Host), result.StatusCode)
+	}
+
+	fmt.Fprintln(w, "# HELP docker_registry_checker_last_run_timestamp_seconds Unix time of the last completed check run.")
+	fmt.Fprintln(w, "# TYPE docker_registry_checker_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(w, "docker_registry_checker_last_run_timestamp_seconds %d\n", runAt.Unix())
+}
+
+// 按Prometheus文本格式转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// 写入node_exporter textfile collector使用的.prom文件
+//
+// 先写入同目录下的临时文件再重命名，避免node_exporter读到写了一半的文件。
+func writeTextfile(path string, results []CheckResult, runAt time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".docker-registry-checker-*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writeMetrics(tmp, results, runAt)
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入指标文件失败: %v", err)
+	}
+	// node_exporter 需要能读取该文件
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("设置文件权限失败: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("写入指标文件失败: %v", err)
+	}
+	return nil
+}
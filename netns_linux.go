@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 子进程通过环境变量得知已经在目标网络命名空间中运行，避免再次进入
+const (
+	netnsEnv      = "DRC_NETNS"
+	resolvConfEnv = "DRC_RESOLV_CONF"
+)
+
+// 在指定的网络命名空间中重新运行检测，返回子进程的退出码
+//
+// Go程序运行后有多个线程，无法可靠地让整个进程切换命名空间，这里通过 nsenter 重新执行自身。
+// 已经在该命名空间中时返回 -1，调用方继续在当前进程中检测。
+func runInNetns(netns string, args []string) (int, error) {
+	if os.Getenv(netnsEnv) == netns {
+		if resolvConf := os.Getenv(resolvConfEnv); resolvConf != "" {
+			if err := useResolvConf(resolvConf); err != nil {
+				return 0, err
+			}
+		}
+		return -1, nil
+	}
+
+	if _, err := os.Stat(netns); err != nil {
+		return 0, fmt.Errorf("无法访问网络命名空间: %v", err)
+	}
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return 0, fmt.Errorf("未找到nsenter (util-linux): %v", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(nsenter, append([]string{"--net=" + netns, "--", self}, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), netnsEnv+"="+netns)
+	if resolvConf := netnsResolvConf(netns); resolvConf != "" {
+		cmd.Env = append(cmd.Env, resolvConfEnv+"="+resolvConf)
+	}
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, fmt.Errorf("进入网络命名空间失败 (需要root权限): %v", err)
+	}
+	return 0, nil
+}
+
+// 容器的网络命名空间路径，id为容器ID或名称
+func containerNetns(id string) (string, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Pid}}", id).Output()
+	if err != nil {
+		return "", fmt.Errorf("获取容器信息失败: %v", err)
+	}
+	pid := strings.TrimSpace(string(out))
+	if pid == "" || pid == "0" {
+		return "", fmt.Errorf("容器 %s 没有在运行", id)
+	}
+	return "/proc/" + pid + "/ns/net", nil
+}
+
+// 命名空间对应的resolv.conf，容器使用的DNS通常与宿主机不同
+//
+//	/proc/<pid>/ns/net      -> /proc/<pid>/root/etc/resolv.conf (容器内的文件)
+//	/var/run/netns/<name>   -> /etc/netns/<name>/resolv.conf (与 ip netns exec 相同)
+func netnsResolvConf(netns string) string {
+	var path string
+	if strings.HasPrefix(netns, "/proc/") && strings.HasSuffix(netns, "/ns/net") {
+		path = strings.TrimSuffix(netns, "/ns/net") + "/root/etc/resolv.conf"
+	} else {
+		path = filepath.Join("/etc/netns", filepath.Base(netns), "resolv.conf")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// 使用指定resolv.conf中的nameserver进行域名解析
+func useResolvConf(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("读取resolv.conf失败: %v", err)
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, servers[0])
+		},
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// 网络命名空间只在Linux上可用
+func runInNetns(netns string, args []string) (int, error) {
+	return 0, fmt.Errorf("-netns 和 -in-container 只支持Linux")
+}
+
+func containerNetns(id string) (string, error) {
+	return "", fmt.Errorf("-netns 和 -in-container 只支持Linux")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerV2    = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// OCI相关能力的探测结果
+type OCIResult struct {
+	// 能否以OCI媒体类型返回清单
+	Manifest bool `json:"manifest" yaml:"manifest"`
+	// 是否支持OCI 1.1的referrers API (用于签名、SBOM等附属制品)
+	Referrers bool   `json:"referrers" yaml:"referrers"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// 探测镜像源对OCI清单和referrers API的支持情况，base为镜像源地址，如 https://mirror.example.com
+func probeOCI(ctx context.Context, client *http.Client, base, image string) *OCIResult {
+	result := &OCIResult{}
+
+	repo, ref := splitImageRef(image)
+	base = base + "/v2/" + repo
+
+	accept := strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerV2}, ", ")
+	resp, err := registryGet(ctx, client, base+"/manifests/"+ref, accept)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("获取清单失败，状态码: %d", resp.StatusCode)
+		return result
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	result.Manifest = strings.HasPrefix(contentType, "application/vnd.oci.")
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		result.Error = "响应中没有Docker-Content-Digest"
+		return result
+	}
+
+	resp, err = registryGet(ctx, client, base+"/referrers/"+digest, mediaTypeOCIIndex)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	// 支持referrers API时返回一个OCI index (没有附属制品时manifests为空)
+	if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), mediaTypeOCIIndex) {
+		var index struct {
+			Manifests []json.RawMessage `json:"manifests"`
+		}
+		result.Referrers = json.NewDecoder(resp.Body).Decode(&index) == nil
+	}
+	return result
+}
+
+// 拆分镜像引用，如 library/alpine:latest -> (library/alpine, latest)
+func splitImageRef(image string) (repo, ref string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// 发送GET请求，遇到401 Bearer认证时自动获取匿名token后重试
+func registryGet(ctx context.Context, client *http.Client, rawURL, accept string) (*http.Response, error) {
+	resp, err := registryRequest(ctx, client, rawURL, accept, "")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	token, err := fetchAnonymousToken(ctx, client, challenge)
+	if err != nil {
+		return nil, err
+	}
+	return registryRequest(ctx, client, rawURL, accept, token)
+}
+
+func registryRequest(ctx context.Context, client *http.Client, rawURL, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
+
+// 按照 WWW-Authenticate: Bearer realm="...",service="...",scope="..." 获取匿名token
+func fetchAnonymousToken(ctx context.Context, client *http.Client, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("不支持的认证方式: %q", challenge)
+	}
+
+	params := parseAuthParams(challenge[len("bearer "):])
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("认证信息中缺少realm: %q", challenge)
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("无效的realm: %v", err)
+	}
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("获取token失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("获取token失败，状态码: %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("解析token失败: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// 解析 key="value",key2="value2" 形式的认证参数
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 单个IP的检测结果
+type IPResult struct {
+	IP         string        `json:"ip" yaml:"ip"`
+	Available  bool          `json:"available" yaml:"available"`
+	StatusCode int           `json:"status_code" yaml:"status_code"`
+	Time       time.Duration `json:"time" yaml:"time"`
+	Error      string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// 解析host的所有A/AAAA记录，并分别直连每个IP进行检测
+func checkPerIP(ctx context.Context, host, url string, opts checkOptions) []IPResult {
+	hostname, port := host, "443"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+
+	// 直接写IP的host不需要解析
+	if net.ParseIP(hostname) != nil {
+		return nil
+	}
+
+	resolved, err := opts.Tape.call("dns "+hostname, func() (string, error) {
+		addrs, err := opts.DNS.lookupIPAddr(ctx, hostname)
+		ips := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP.String())
+		}
+		return strings.Join(ips, ","), err
+	})
+	if err != nil {
+		return []IPResult{{Error: fmt.Sprintf("解析失败: %v", err)}}
+	}
+
+	if resolved == "" {
+		return nil
+	}
+	ips := strings.Split(resolved, ",")
+	results := make([]IPResult, 0, len(ips))
+	for _, ip := range ips {
+		if !opts.Limiter.wait(ctx) {
+			break
+		}
+		results = append(results, probeIP(ctx, hostname, net.JoinHostPort(ip, port), url, opts))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].IP < results[j].IP
+	})
+	return results
+}
+
+// 连接指定的IP地址发送探测请求，SNI和Host头仍使用原主机名
+func probeIP(ctx context.Context, hostname, addr, url string, opts checkOptions) IPResult {
+	ip, _, _ := net.SplitHostPort(addr)
+	result := IPResult{IP: ip}
+
+	connect, tlsTimeout, response := opts.phaseTimeouts()
+	dialer := &net.Dialer{Timeout: connect}
+	transport := &http.Transport{
+		DialContext: opts.limitDial(func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}, nil),
+		TLSClientConfig: &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+			ClientSessionCache: opts.sessionCache(1),
+		},
+		TLSHandshakeTimeout:   tlsTimeout,
+		ResponseHeaderTimeout: response,
+		ForceAttemptHTTP2:     true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Timeout: opts.clientTimeout(), Transport: opts.Tape.wrap(transport, "ip "+addr)}
+
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	if opts.Warmup && opts.Limiter.wait(ctx) {
+		warmup(ctx, client, url)
+	}
+
+	start := time.Now()
+	resp, err := probe(ctx, client, method, url)
+	if err == nil && method == http.MethodHead && headRejected(resp.StatusCode) {
+		resp.Body.Close()
+		start = time.Now()
+		resp, err = probe(ctx, client, http.MethodGet, url)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Time = time.Since(start)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Available = opts.Criteria.accept(resp, url)
+	return result
+}
+
+// 输出逐IP检测结果，没有检测数据时不输出
+func writePerIP(w io.Writer, results []CheckResult) {
+	header := false
+	for _, result := range results {
+		if len(result.IPs) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\n逐IP检测:")
+			fmt.Fprintln(w, "Registry                       IP                                        状态       状态码     响应时间")
+			writeRule(w, "-", 105)
+			header = true
+		}
+
+		for _, ipResult := range result.IPs {
+			statusCode, timeStr := "-", "-"
+			if ipResult.StatusCode != 0 {
+				statusCode = fmt.Sprintf("%d", ipResult.StatusCode)
+				timeStr = fmt.Sprintf("%.2fs", ipResult.Time.Seconds())
+			}
+			ip := ipResult.IP
+			if ip == "" {
+				ip = ipResult.Error
+			}
+			fmt.Fprintf(w, "%-30s %-41s %-10s %-10s %s\n",
+				result.Host, ip, checkMark(ipResult.Available), statusCode, timeStr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "sort"
+
+// 默认的置顶列表文件，与docker.txt放在同一目录
+const defaultPinnedPath = "pinned.txt"
+
+// 置顶列表文件，path为空时使用默认位置
+//
+// 越靠前的镜像源优先级越高，需要调整顺序时直接编辑文件。
+func pinnedFile(path string) hostFile {
+	if path == "" {
+		path = defaultPinnedPath
+	}
+	return hostFile{Path: path, Name: "置顶列表", Header: "docker-registry-checker 置顶的镜像源，总是显示在结果最前面，-apply fastest 时优先选择，越靠前优先级越高", Flag: "pinned"}
+}
+
+// 每个置顶的host在置顶列表中的顺序 (从1开始)，用于标记检测结果的Pinned
+func pinPriority(pinned []listEntry) map[string]int {
+	priority := map[string]int{}
+	for i, entry := range pinned {
+		if _, ok := priority[entry.Host]; !ok {
+			priority[entry.Host] = i + 1
+		}
+	}
+	return priority
+}
+
+// 把置顶的镜像源按优先级移到最前面，其余结果保持原有顺序
+func pinFirst(results []CheckResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		pi, pj := results[i].Pinned, results[j].Pinned
+		if (pi > 0) != (pj > 0) {
+			return pi > 0
+		}
+		return pi < pj
+	})
+}
+
+// pin 子命令：置顶镜像源
+func runPin(args []string) error {
+	return runHostFileAdd("pin", pinnedFile(""), args)
+}
+
+// unpin 子命令：取消置顶
+func runUnpin(args []string) error {
+	return runHostFileRemove("unpin", pinnedFile(""), args)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 插件协议版本
+const pluginProtocolVersion = 1
+
+// 外部探测插件的结果
+type PluginResult struct {
+	Name string `json:"name" yaml:"name"`
+	// 插件是否认为该镜像源通过检查
+	Pass bool `json:"pass" yaml:"pass"`
+	// 对评分的调整，正数加分，负数减分
+	Score float64 `json:"score,omitempty" yaml:"score,omitempty"`
+	// 插件给出的说明
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+	// 插件运行失败的原因
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// 发送给插件的请求
+//
+// 插件是一个可执行文件，每个镜像源运行一次: 从stdin读取一个JSON请求，
+// 向stdout写入一个JSON响应后退出。例如:
+//
+//	stdin:  {"protocol":1,"host":"mirror.example.com","url":"https://mirror.example.com/v2/","timeout_seconds":10,"result":{...}}
+//	stdout: {"name":"corp-auth","pass":true,"score":5,"detail":"token ok"}
+//
+// result为内置检测的结果 (格式同 -output json)，name为空时使用插件文件名。
+// 退出码非0时视为插件运行失败，stderr的内容会记录在结果中。
+type pluginRequest struct {
+	Protocol       int         `json:"protocol"`
+	Host           string      `json:"host"`
+	URL            string      `json:"url"`
+	TimeoutSeconds float64     `json:"timeout_seconds"`
+	Result         CheckResult `json:"result"`
+}
+
+// 可以重复指定的字符串参数
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// 依次运行所有插件
+func runPlugins(ctx context.Context, plugins []string, url string, result CheckResult, opts checkOptions) []PluginResult {
+	results := make([]PluginResult, 0, len(plugins))
+	for _, plugin := range plugins {
+		results = append(results, runPlugin(ctx, plugin, url, result, opts))
+	}
+	return results
+}
+
+func runPlugin(ctx context.Context, plugin, url string, result CheckResult, opts checkOptions) PluginResult {
+	name := strings.TrimSuffix(filepath.Base(plugin), filepath.Ext(plugin))
+	failed := func(format string, args ...interface{}) PluginResult {
+		return PluginResult{Name: name, Error: fmt.Sprintf(format, args...)}
+	}
+
+	request, err := json.Marshal(pluginRequest{
+		Protocol:       pluginProtocolVersion,
+		Host:           result.Host,
+		URL:            url,
+		TimeoutSeconds: opts.Timeout.Seconds(),
+		Result:         result,
+	})
+	if err != nil {
+		return failed("生成请求失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, plugin)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return failed("运行插件失败: %v: %s", err, msg)
+		}
+		return failed("运行插件失败: %v", err)
+	}
+
+	var response PluginResult
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return failed("解析插件输出失败: %v", err)
+	}
+	if response.Name == "" {
+		response.Name = name
+	}
+	return response
+}
+
+// 所有插件是否都通过
+func pluginsPassed(results []PluginResult) bool {
+	for _, result := range results {
+		if !result.Pass {
+			return false
+		}
+	}
+	return true
+}
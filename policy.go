@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 镜像源选择策略，从YAML文件加载
+//
+// 示例:
+//
+//	require_tls_verify: true
+//	max_latency: 2s
+//	prefer_asn: [4134, 4837]
+//	exclude_asn: [9808]
+//	exclude_operators: ["aliyun"]
+//	max_mirrors: 3
+type Policy struct {
+	RequireTLSVerify bool          `yaml:"require_tls_verify"`
+	MaxLatency       time.Duration `yaml:"max_latency"`
+	PreferASN        []int         `yaml:"prefer_asn"`
+	ExcludeASN       []int         `yaml:"exclude_asn"`
+	ExcludeOperators []string      `yaml:"exclude_operators"`
+	MaxMirrors       int           `yaml:"max_mirrors"`
+}
+
+// 读取策略文件
+func loadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取策略文件失败: %v", err)
+	}
+
+	policy := &Policy{}
+	if err := unmarshalYAMLStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("解析策略文件失败: %v", err)
+	}
+	if policy.MaxMirrors < 0 {
+		return nil, fmt.Errorf("max_mirrors 不能为负数")
+	}
+	return policy, nil
+}
+
+// 是否需要查询ASN信息
+func (p *Policy) needsASN() bool {
+	return len(p.PreferASN) > 0 || len(p.ExcludeASN) > 0 || len(p.ExcludeOperators) > 0
+}
+
+// 策略需要ASN时预先查询可用镜像源的ASN，录制时在保存录制文件之前调用，使查询结果也被录制
+func (p *Policy) resolveASN(results []CheckResult, tape *tape) {
+	if !p.needsASN() {
+		return
+	}
+	for _, result := range results {
+		if isSuccess(result) {
+			lookupASN(result.IP, tape)
+		}
+	}
+}
+
+// 根据策略从成功的结果中选出要应用的镜像源，按优先级排序；ASN查询通过tape录制或回放
+func (p *Policy) Select(results []CheckResult, tape *tape) []CheckResult {
+	type candidate struct {
+		result    CheckResult
+		preferred bool
+	}
+
+	var candidates []candidate
+	for _, result := range results {
+		if !isSuccess(result) {
+			continue
+		}
+		if p.RequireTLSVerify && !result.TLSVerified {
+			continue
+		}
+		if p.MaxLatency > 0 && result.Time > p.MaxLatency {
+			continue
+		}
+
+		c := candidate{result: result}
+		if p.needsASN() {
+			info, err := lookupASN(result.IP, tape)
+			if err != nil {
+				// 查询不到ASN时无法判断排除条件，保守起见跳过
+				if len(p.ExcludeASN) > 0 || len(p.ExcludeOperators) > 0 {
+					continue
+				}
+			} else {
+				if containsInt(p.ExcludeASN, info.Number) || matchOperator(p.ExcludeOperators, info.Name) {
+					continue
+				}
+				c.preferred = containsInt(p.PreferASN, info.Number)
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	// 优先ASN在前，其余按响应时间排序
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].preferred != candidates[j].preferred {
+			return candidates[i].preferred
+		}
+		return candidates[i].result.Time < candidates[j].result.Time
+	})
+
+	if p.MaxMirrors > 0 && len(candidates) > p.MaxMirrors {
+		candidates = candidates[:p.MaxMirrors]
+	}
+
+	selected := make([]CheckResult, 0, len(candidates))
+	for _, c := range candidates {
+		selected = append(selected, c.result)
+	}
+	return selected
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 运营商名称按不区分大小写的子串匹配
+func matchOperator(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(name, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP所属的自治系统信息
+type ASNInfo struct {
+	Number int
+	Name   string
+}
+
+// 缓存已查询过的ASN，避免重复DNS查询
+var asnCache = map[string]ASNInfo{}
+
+// 通过Team Cymru的DNS接口查询IP所属ASN，tape为nil时直接查询
+func lookupASN(ipStr string, tape *tape) (ASNInfo, error) {
+	if info, ok := asnCache[ipStr]; ok {
+		return info, nil
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return ASNInfo{}, fmt.Errorf("无效的IP地址: %q", ipStr)
+	}
+
+	var query string
+	if v4 := ip.To4(); v4 != nil {
+		query = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	} else {
+		const hex = "0123456789abcdef"
+		var nibbles []string
+		for i := len(ip) - 1; i >= 0; i-- {
+			nibbles = append(nibbles, string(hex[ip[i]&0x0f]), string(hex[ip[i]>>4]))
+		}
+		query = strings.Join(nibbles, ".") + ".origin6.asn.cymru.com"
+	}
+
+	// 返回格式: "4134 | 1.2.3.0/24 | CN | apnic | 2010-01-01"
+	record, err := tape.call("asn "+query, func() (string, error) {
+		records, err := net.LookupTXT(query)
+		if err != nil {
+			return "", err
+		}
+		if len(records) == 0 {
+			return "", fmt.Errorf("没有TXT记录")
+		}
+		return records[0], nil
+	})
+	if err != nil {
+		return ASNInfo{}, fmt.Errorf("查询ASN失败: %v", err)
+	}
+	fields := strings.Split(record, "|")
+	// 多个ASN宣告同一前缀时取第一个
+	asns := strings.Fields(fields[0])
+	if len(asns) == 0 {
+		return ASNInfo{}, fmt.Errorf("解析ASN失败: %q", record)
+	}
+	number, err := strconv.Atoi(asns[0])
+	if err != nil {
+		return ASNInfo{}, fmt.Errorf("解析ASN失败: %q", record)
+	}
+
+	info := ASNInfo{Number: number}
+	// 返回格式: "4134 | CN | apnic | 2002-10-15 | CHINANET-BACKBONE No.31,Jin-rong Street, CN"
+	nameQuery := fmt.Sprintf("AS%d.asn.cymru.com", number)
+	name, err := tape.call("asn "+nameQuery, func() (string, error) {
+		names, err := net.LookupTXT(nameQuery)
+		if err != nil || len(names) == 0 {
+			return "", err
+		}
+		return names[0], nil
+	})
+	if err == nil && name != "" {
+		parts := strings.Split(name, "|")
+		info.Name = strings.TrimSpace(parts[len(parts)-1])
+	}
+
+	asnCache[ipStr] = info
+	return info, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// deep_checks 的可选值，决定何时执行深度检测
+var deepCheckPolicies = []string{
+	"always",             // 总是执行 (默认)
+	"ac_power",           // 只在接通电源时执行
+	"unmetered",          // 只在非按流量计费的网络下执行
+	"ac_power_unmetered", // 同时满足以上两个条件时执行
+}
+
+// 判断当前是否允许执行深度检测，不允许时返回原因
+//
+// 无法判断电源或网络计费状态的系统 (如台式机、服务器) 视为满足条件。
+func deepChecksBlocked(policy string) string {
+	if policy == "ac_power" || policy == "ac_power_unmetered" {
+		if onBattery, ok := onBatteryPower(); ok && onBattery {
+			return "正在使用电池供电"
+		}
+	}
+	if policy == "unmetered" || policy == "ac_power_unmetered" {
+		if metered, ok := meteredConnection(); ok && metered {
+			return "当前网络按流量计费"
+		}
+	}
+	return ""
+}
+
+// 去掉深度检测，只保留对探测路径的一次请求
+//
+// 深度检测包括复用连接测速、HTTP/3、OCI能力、内容一致性、逐IP检测和外部插件，
+// 会产生额外的请求和流量。
+func (o checkOptions) quick() checkOptions {
+	o.HTTP3 = false
+	o.Warm = false
+	o.OCIImage = ""
+	o.Integrity = nil
+	o.PerIP = false
+	o.Plugins = nil
+	return o
+}
+
+// 校验 deep_checks 的取值
+func validateDeepCheckPolicy(policy string) error {
+	if !containsString(deepCheckPolicies, policy) {
+		return fmt.Errorf("不支持的deep_checks: %s (可选 always/ac_power/unmetered/ac_power_unmetered)", policy)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build darwin
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// 通过 pmset 判断是否在使用电池供电，输出的第一行如 Now drawing from 'Battery Power'
+func onBatteryPower() (onBattery, ok bool) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, false
+	}
+	first, _, _ := strings.Cut(string(out), "\n")
+	switch {
+	case strings.Contains(first, "Battery Power"):
+		return true, true
+	case strings.Contains(first, "AC Power"):
+		return false, true
+	}
+	return false, false
+}
+
+// macOS没有判断低数据模式的命令行工具，视为未知
+func meteredConnection() (metered, ok bool) {
+	return false, false
+}
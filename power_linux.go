@@ -0,0 +1,64 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 通过 /sys/class/power_supply 判断是否在使用电池供电
+//
+// 有交流电源 (Mains) 时以其online为准；没有交流电源但有电池时以电池状态为准。
+func onBatteryPower() (onBattery, ok bool) {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	var mains, battery, discharging bool
+	for _, dir := range supplies {
+		switch readSysfs(filepath.Join(dir, "type")) {
+		case "Mains":
+			mains = true
+			if readSysfs(filepath.Join(dir, "online")) == "1" {
+				return false, true
+			}
+		case "Battery":
+			battery = true
+			if readSysfs(filepath.Join(dir, "status")) == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	if mains {
+		return true, true
+	}
+	return discharging, battery
+}
+
+func readSysfs(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// 通过NetworkManager判断当前网络是否按流量计费
+//
+// 每个网络设备的取值为 yes、yes (guessed) (如手机热点)、no 或 unknown (未连接的设备)，
+// 任一设备按流量计费即视为计费网络。
+func meteredConnection() (metered, ok bool) {
+	out, err := exec.Command("nmcli", "-t", "-g", "GENERAL.METERED", "device", "show").Output()
+	if err != nil {
+		return false, false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		switch value := strings.TrimSpace(line); {
+		case strings.HasPrefix(value, "yes"):
+			return true, true
+		case strings.HasPrefix(value, "no"):
+			ok = true
+		}
+	}
+	return false, ok
+}
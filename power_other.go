@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// 其他系统无法判断电源状态，视为未知
+func onBatteryPower() (onBattery, ok bool) {
+	return false, false
+}
+
+func meteredConnection() (metered, ok bool) {
+	return false, false
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// 通过 GetSystemPowerStatus 判断是否在使用电池供电，ACLineStatus 为255时表示未知
+func onBatteryPower() (onBattery, ok bool) {
+	var status systemPowerStatus
+	if r, _, _ := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status))); r == 0 {
+		return false, false
+	}
+	switch status.ACLineStatus {
+	case 0:
+		return true, true
+	case 1:
+		return false, true
+	}
+	return false, false
+}
+
+// 计费网络的状态需要通过WinRT接口获取，这里视为未知
+func meteredConnection() (metered, ok bool) {
+	return false, false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 写入配置前检查权限，普通用户无法写入系统级配置时，在用户做出选择之前
+// 询问是否通过sudo写入配置，拒绝时给出完整的sudo命令
+//
+// 返回true时写入配置和让配置生效的步骤通过sudo执行 (见 applyWithSudo)，
+// 检测、历史记录、-save 等仍以当前用户运行，不会执行两次。
+func ensurePrivileges(opts applyOptions) (bool, error) {
+	if os.Geteuid() <= 0 || !opts.Target.NeedRoot || opts.DryRun || configWritable(opts.Target.ConfigPath) {
+		return false, nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	command := "sudo " + shellQuote(append([]string{self}, opts.Args...))
+
+	fmt.Printf("\n写入 %s 和重启%s需要root权限\n", opts.Target.ConfigPath, opts.Target.Name)
+	if _, err := exec.LookPath("sudo"); err != nil || !confirm("sudo", "是否通过sudo写入配置? (y/n): ") {
+		return false, fmt.Errorf("权限不足，请使用以下命令重新运行:\n  %s", command)
+	}
+	return true, nil
+}
+
+// 写入配置并让其生效，sudo为true时通过sudo运行 apply-config 子命令执行
+func applyMirrors(req applyRequest, sudo bool) error {
+	if !sudo {
+		return executeApply(req)
+	}
+	return applyWithSudo(req)
+}
+
+// 把选好的镜像源写入权限为0600的临时文件，通过sudo运行隐藏的 apply-config 子命令写入配置
+func applyWithSudo(req applyRequest) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("序列化配置请求失败: %v", err)
+	}
+	tmp, err := os.CreateTemp("", "docker-registry-checker-apply-*.json")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+
+	cmd := exec.Command("sudo", self, "apply-config", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("通过sudo写入配置失败: %v", err)
+	}
+	return nil
+}
+
+// apply-config 子命令 (不在帮助中列出): 读取 applyWithSudo 写入的请求，写入配置并让其生效
+func runApplyConfig(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("用法: docker-registry-checker apply-config <请求文件>")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("读取配置请求失败: %v", err)
+	}
+	var req applyRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("解析配置请求失败: %v", err)
+	}
+	return executeApply(req)
+}
+
+// 能否写入配置文件: 写入时先在同目录创建临时文件再重命名，因此检查目录是否可写
+func configWritable(path string) bool {
+	dir := filepath.Dir(path)
+	// 目录不存在时检查能否创建
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+
+	tmp, err := os.CreateTemp(dir, ".docker-registry-checker-*.tmp")
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return true
+}
+
+// 生成可以直接复制到shell中执行的命令
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@%+", r))
+		}) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 进度条的最大和最小宽度，终端较窄时缩短进度条，太窄时只显示数字
+const (
+	progressBarMaxWidth = 40
+	progressBarMinWidth = 10
+)
+
+// 检测进度: 已完成的数量、成功/失败数、已用时间和按目前速度估算的剩余时间
+//
+// 每次更新时重新获取终端宽度，调整窗口大小后下一次更新即按新的宽度显示。
+// 标准输出不是终端时 (如重定向到文件) 不显示进度条，plain模式仍然输出进度行。
+type progressBar struct {
+	mu      sync.Mutex
+	out     *os.File
+	plain   bool
+	enabled bool
+	start   time.Time
+	total   int
+	done    int
+	success int
+	// 上一次输出的进度条的显示宽度，用于清除
+	lastWidth int
+}
+
+func newProgressBar(total int, plain bool) *progressBar {
+	_, _, tty := terminalSize(os.Stdout)
+	return &progressBar{
+		out:     os.Stdout,
+		plain:   plain,
+		enabled: plain || tty,
+		start:   time.Now(),
+		total:   total,
+	}
+}
+
+// 记录一个完成的检测并刷新进度
+func (p *progressBar) add(result CheckResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if isSuccess(result) {
+		p.success++
+	}
+	p.render()
+}
+
+// 清除当前行的进度条，在进度条所在行输出其他内容前调用，下一次更新时重新显示
+func (p *progressBar) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled || p.plain || p.lastWidth == 0 {
+		return
+	}
+	fmt.Fprintf(p.out, "\r%s\r", strings.Repeat(" ", p.lastWidth))
+	p.lastWidth = 0
+}
+
+// 调用时持有锁
+func (p *progressBar) render() {
+	if !p.enabled {
+		return
+	}
+	elapsed := time.Since(p.start)
+	counts := fmt.Sprintf("成功 %d 失败 %d 已用 %s", p.success, p.done-p.success, formatClock(elapsed))
+	if p.done < p.total && p.done > 0 {
+		remaining := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		counts += " 剩余约 " + formatClock(remaining)
+	}
+
+	if p.plain {
+		step := p.total / 10
+		if step < 1 {
+			step = 1
+		}
+		if p.done%step == 0 || p.done == p.total {
+			fmt.Fprintf(p.out, "已检测 %d 个，共 %d 个 (%s)\n", p.done, p.total, counts)
+		}
+		return
+	}
+
+	percentage := float64(p.done) / float64(p.total)
+	numbers := fmt.Sprintf("%d/%d (%.1f%%) %s", p.done, p.total, percentage*100, counts)
+	line := "检测进度: " + numbers
+	cols, _, _ := terminalSize(p.out)
+	// 留出一列，避免光标换到下一行
+	barWidth := progressBarMaxWidth
+	if cols > 0 {
+		barWidth = cols - 1 - displayWidth(line) - 3
+		if barWidth > progressBarMaxWidth {
+			barWidth = progressBarMaxWidth
+		}
+	}
+	if barWidth >= progressBarMinWidth {
+		filled := int(float64(barWidth) * percentage)
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+		line = fmt.Sprintf("检测进度: [%s] %s", bar, numbers)
+	}
+
+	width := displayWidth(line)
+	// 比上一次短时用空格覆盖上一次多出的部分
+	padding := p.lastWidth - width
+	if cols > 0 && width+padding >= cols {
+		padding = cols - 1 - width
+	}
+	if padding < 0 {
+		padding = 0
+	}
+	fmt.Fprintf(p.out, "\r%s%s", line, strings.Repeat(" ", padding))
+	p.lastWidth = width
+}
+
+// 终端中的显示宽度，中日韩文字和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x2E80 {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// 按 1:05 或 1:02:03 的形式显示时长
+func formatClock(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
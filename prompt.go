@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 交互式提问的来源，默认从终端读取，指定 -answers 时从应答文件读取
+type Prompter interface {
+	// 输出问题并读取一行回答，key 用于在应答文件中查找预设的回答
+	Ask(key, question string) (string, error)
+}
+
+// 当前使用的Prompter
+var prompter Prompter = newStdinPrompter(os.Stdin, os.Stdout)
+
+// 从终端读取回答
+//
+// 所有提问共用同一个bufio.Reader，通过管道输入多行回答时不会因为各自缓冲而丢失后面的行。
+type stdinPrompter struct {
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+func newStdinPrompter(in io.Reader, out io.Writer) *stdinPrompter {
+	return &stdinPrompter{reader: bufio.NewReader(in), out: out}
+}
+
+func (p *stdinPrompter) Ask(key, question string) (string, error) {
+	fmt.Fprint(p.out, question)
+	answer, err := p.reader.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// 应答文件中支持的key
+var answerKeys = []string{
+	"configure", // 检测完成后是否配置镜像源 (y/n)
+	"mode",      // 配置方式: 1 替换全部镜像源 / 2 选择镜像源 / 3 合并到现有镜像源
+	"mirrors",   // 选择的镜像源，编号或host，可以是列表
+	"restart",   // 写入配置后是否重启Docker (y/n)
+	"apply",     // -apply 写入前的确认 (y/n)
+	"sudo",      // 权限不足时是否通过sudo写入配置 (y/n)
+	"exit",      // 退出前的按键等待，任意值
+	"menu",      // 双击启动时的菜单选项 (1-4)，以及返回菜单前的按键等待
+	"backup",    // restore 要恢复的备份编号或文件
+	"restore",   // restore 覆盖配置前的确认 (y/n)
+	"share",     // share 上传前的确认 (y/n)
+	"discover",  // discover 提交PR前的确认 (y/n)
+}
+
+// 按应答文件回答，不读取终端，用于自动化脚本
+type scriptedPrompter struct {
+	answers map[string]string
+	out     io.Writer
+}
+
+// 应答文件中的一个回答，可以是单个值，也可以是列表 (多选时使用)
+type answerValue string
+
+func (a *answerValue) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*a = answerValue(node.Value)
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("第%d行: 列表中只能是单个值", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		*a = answerValue(strings.Join(values, ","))
+	default:
+		return fmt.Errorf("第%d行: 回答应为单个值或列表", node.Line)
+	}
+	return nil
+}
+
+// 读取应答文件，如:
+//
+//	configure: y
+//	mode: 2
+//	mirrors: [mirror.example.com, 3]
+//	restart: n
+func loadAnswers(path string) (*scriptedPrompter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取应答文件失败: %v", err)
+	}
+
+	var values map[string]answerValue
+	if err := unmarshalYAMLStrict(data, &values); err != nil {
+		return nil, fmt.Errorf("解析应答文件失败: %v", err)
+	}
+
+	answers := make(map[string]string, len(values))
+	for key, value := range values {
+		if !containsString(answerKeys, key) {
+			return nil, fmt.Errorf("应答文件中有未知的key: %s (支持: %s)", key, strings.Join(answerKeys, ", "))
+		}
+		answers[key] = string(value)
+	}
+	return &scriptedPrompter{answers: answers, out: os.Stdout}, nil
+}
+
+// 使用应答文件回答之后的提问，path为空时不做修改
+func useAnswersFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	answers, err := loadAnswers(path)
+	if err != nil {
+		return err
+	}
+	prompter = answers
+	noWait = true
+	return nil
+}
+
+func (p *scriptedPrompter) Ask(key, question string) (string, error) {
+	answer, ok := p.answers[key]
+	fmt.Fprint(p.out, question)
+	if !ok {
+		fmt.Fprintln(p.out)
+		return "", fmt.Errorf("应答文件中没有 %s 的回答", key)
+	}
+	// 回显预设的回答，便于在日志中查看
+	fmt.Fprintln(p.out, answer)
+	return answer, nil
+}
+
+// 询问用户是否继续，读取失败时视为否
+func confirm(key, prompt string) bool {
+	answer, err := prompter.Ask(key, prompt)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes", "true":
+		return true
+	}
+	return false
+}
+
+// 从选项中选择一个，回答可以是编号 (从1开始) 或选项本身，返回选项的下标
+func choose(key, question string, options []string) (int, error) {
+	for i, option := range options {
+		fmt.Printf("%d. %s\n", i+1, option)
+	}
+	answer, err := prompter.Ask(key, question)
+	if err != nil {
+		return 0, err
+	}
+	return parseChoice(answer, options)
+}
+
+// 从选项中选择多个，回答用逗号或空格分隔，返回选项的下标
+func multiSelect(key, question string, options []string) ([]int, error) {
+	for i, option := range options {
+		fmt.Printf("%d. %s\n", i+1, option)
+	}
+	answer, err := prompter.Ask(key, question)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []int
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		index, err := parseChoice(field, options)
+		if err != nil {
+			return nil, err
+		}
+		if !containsInt(selected, index) {
+			selected = append(selected, index)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("没有选择任何选项")
+	}
+	return selected, nil
+}
+
+// 解析一个选项，编号或选项的第一个词 (如镜像源的host)
+func parseChoice(answer string, options []string) (int, error) {
+	if index, err := strconv.Atoi(answer); err == nil {
+		if index < 1 || index > len(options) {
+			return 0, fmt.Errorf("无效的选择: %d", index)
+		}
+		return index - 1, nil
+	}
+	for i, option := range options {
+		if answer == option || strings.HasPrefix(option, answer+" ") {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("无效的选择: %s", answer)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在测试期间使用预设的回答
+func useAnswers(t *testing.T, answers map[string]string) {
+	t.Helper()
+	previous := prompter
+	prompter = &scriptedPrompter{answers: answers, out: io.Discard}
+	t.Cleanup(func() { prompter = previous })
+}
+
+func TestConfirm(t *testing.T) {
+	useAnswers(t, map[string]string{"apply": "Y", "restart": "yes", "sudo": "n", "configure": ""})
+	for key, want := range map[string]bool{"apply": true, "restart": true, "sudo": false, "configure": false, "mode": false} {
+		if got := confirm(key, "? "); got != want {
+			t.Errorf("confirm(%q) = %v，期望 %v", key, got, want)
+		}
+	}
+}
+
+func TestParseChoice(t *testing.T) {
+	options := []string{"a.example.com (响应时间: 0.10s)", "b.example.com (响应时间: 0.20s)", "合并"}
+	tests := []struct {
+		answer string
+		want   int
+		err    bool
+	}{
+		{answer: "1", want: 0},
+		{answer: "3", want: 2},
+		{answer: "b.example.com", want: 1},
+		{answer: "合并", want: 2},
+		{answer: "0", err: true},
+		{answer: "4", err: true},
+		{answer: "b.example", err: true},
+		{answer: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseChoice(tt.answer, options)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseChoice(%q) 应返回错误，得到 %d", tt.answer, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseChoice(%q) = %d, %v，期望 %d", tt.answer, got, err, tt.want)
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	useAnswers(t, map[string]string{"mode": "2"})
+	if got, err := choose("mode", "? ", []string{"替换", "选择", "合并"}); err != nil || got != 1 {
+		t.Errorf("choose = %d, %v，期望 1", got, err)
+	}
+	// 没有预设回答时返回错误，而不是默认选择第一项
+	if _, err := choose("mirrors", "? ", []string{"替换"}); err == nil {
+		t.Error("没有回答时 choose 应返回错误")
+	}
+}
+
+func TestMultiSelect(t *testing.T) {
+	options := []string{"a.example.com", "b.example.com", "c.example.com"}
+	tests := []struct {
+		answer string
+		want   []int
+		err    bool
+	}{
+		{answer: "3,1", want: []int{2, 0}},
+		{answer: "2, b.example.com 1", want: []int{1, 0}},
+		{answer: " , ", err: true},
+		{answer: "1,5", err: true},
+	}
+	for _, tt := range tests {
+		useAnswers(t, map[string]string{"mirrors": tt.answer})
+		got, err := multiSelect("mirrors", "? ", options)
+		if tt.err {
+			if err == nil {
+				t.Errorf("multiSelect(%q) 应返回错误，得到 %v", tt.answer, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("multiSelect(%q) = %v, %v，期望 %v", tt.answer, got, err, tt.want)
+		}
+	}
+}
+
+func writeAnswers(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "answers.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAnswers(t *testing.T) {
+	p, err := loadAnswers(writeAnswers(t, "configure: y\nmode: 2\nmirrors: [a.example.com, 3]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"configure": "y", "mode": "2", "mirrors": "a.example.com,3"}
+	if !reflect.DeepEqual(p.answers, want) {
+		t.Errorf("answers = %v，期望 %v", p.answers, want)
+	}
+
+	for name, content := range map[string]string{
+		"未知的key": "configure: y\nrestrat: n\n",
+		"列表中的列表": "mirrors:\n  - 1\n  - [2, 3]\n",
+		"列表中的映射": "mirrors:\n  - host: a.example.com\n",
+		"映射":     "mode:\n  value: 1\n",
+	} {
+		if _, err := loadAnswers(writeAnswers(t, content)); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}
+
+// 源码中所有提问使用的key都可以写在应答文件中
+func TestAnswerKeys(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile(`\b(?:Ask|confirm|choose|multiSelect)\("([a-z-]+)"`)
+	used := map[string]bool{}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range pattern.FindAllStringSubmatch(string(data), -1) {
+			used[match[1]] = true
+		}
+	}
+	for _, key := range []string{"exit", "menu", "restore", "share"} {
+		if !used[key] {
+			t.Errorf("源码中没有找到提问 %s", key)
+		}
+	}
+	for key := range used {
+		if !containsString(answerKeys, key) {
+			t.Errorf("提问 %s 不在 answerKeys 中，应答文件无法回答", key)
+		}
+	}
+
+	if _, err := loadAnswers(writeAnswers(t, "exit: \"\"\nmenu: 4\nbackup: 1\nrestore: y\nshare: n\ndiscover: n\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleInteractiveApply(t *testing.T) {
+	results := []CheckResult{
+		{Host: "a.example.com", Available: true, Time: 300 * time.Millisecond},
+		{Host: "b.example.com", Available: true, Time: 100 * time.Millisecond},
+		{Host: "c.example.com", Available: true, Time: 200 * time.Millisecond, Insecure: true},
+	}
+	tests := []struct {
+		name    string
+		answers string
+		want    []string
+	}{
+		{
+			name:    "替换全部",
+			answers: "mode: 1\n",
+			want:    []string{"https://a.example.com", "https://b.example.com", "http://c.example.com"},
+		},
+		{
+			name:    "选择",
+			answers: "mode: 2\nmirrors: [3, b.example.com]\n",
+			want:    []string{"http://c.example.com", "https://b.example.com"},
+		},
+		{
+			name:    "合并",
+			answers: "mode: 3\nmirrors: [1, 2]\n",
+			want:    []string{"https://b.example.com", "https://a.example.com", "https://old.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := loadAnswers(writeAnswers(t, tt.answers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.out = io.Discard
+			previous := prompter
+			prompter = p
+			t.Cleanup(func() { prompter = previous })
+
+			path := filepath.Join(t.TempDir(), "daemon.json")
+			existing := `{"registry-mirrors": ["https://old.example.com", "https://a.example.com"], "log-driver": "json-file"}`
+			if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Binary为true时跳过Docker是否安装的检查；没有Restart时写入后不询问是否重启
+			target := dockerTarget{Name: "Docker", ConfigPath: path, Binary: "true"}
+
+			if err := handleInteractiveApply(results, applyOptions{Target: target}); err != nil {
+				t.Fatal(err)
+			}
+			config, err := readDaemonConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.RegistryMirrors, tt.want) {
+				t.Errorf("registry-mirrors = %v，期望 %v", config.RegistryMirrors, tt.want)
+			}
+			data, _ := os.ReadFile(path)
+			if !strings.Contains(string(data), `"log-driver"`) {
+				t.Errorf("原配置中的其他字段丢失: %s", data)
+			}
+			if containsString(tt.want, "http://c.example.com") != containsString(config.InsecureRegistries, "c.example.com") {
+				t.Errorf("insecure-registries 不正确: %v", config.InsecureRegistries)
+			}
+		})
+	}
+}
+
+// 通过sudo写入配置时，选好的镜像源经过请求文件传给 apply-config 子命令
+func TestRunApplyConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "daemon.json")
+	existing := `{"registry-mirrors": ["https://old.example.com", "https://blocked.example.com"], "log-driver": "json-file"}`
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req := applyRequest{
+		Target:  dockerTarget{Name: "Docker", ConfigPath: path, Binary: "true"},
+		Mirrors: []string{"http://c.example.com"},
+		Merge:   true,
+		Blocked: map[string]string{"blocked.example.com": "测试"},
+		Results: []CheckResult{{Host: "c.example.com", Available: true, Time: 100 * time.Millisecond, Insecure: true}},
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := filepath.Join(dir, "request.json")
+	if err := os.WriteFile(request, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runApplyConfig([]string{request}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := readDaemonConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://c.example.com", "https://old.example.com"}; !reflect.DeepEqual(config.RegistryMirrors, want) {
+		t.Errorf("registry-mirrors = %v，期望 %v", config.RegistryMirrors, want)
+	}
+	if !containsString(config.InsecureRegistries, "c.example.com") {
+		t.Errorf("insecure-registries 不正确: %v", config.InsecureRegistries)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// QUIC v1 的版本号
+const quicVersion1 = 0x00000001
+
+// 探测主机是否在UDP端口上提供QUIC服务
+//
+// 发送一个使用保留版本号的Initial包，按照RFC 9000的要求，QUIC服务端会回复
+// 版本协商(Version Negotiation)包并列出支持的版本，无需完成TLS握手。
+func probeQUIC(host string, timeout time.Duration) (bool, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "443")
+	}
+
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	packet := make([]byte, 1200) // 服务端只会响应不小于1200字节的Initial包
+	packet[0] = 0xc0             // 长包头 + Initial类型
+	// 0x?a?a?a?a 形式的版本号为保留版本，用于触发版本协商
+	binary.BigEndian.PutUint32(packet[1:5], 0x1a2a3a4a)
+	packet[5] = 8
+	if _, err := rand.Read(packet[6:14]); err != nil {
+		return false, err
+	}
+	packet[14] = 8
+	if _, err := rand.Read(packet[15:23]); err != nil {
+		return false, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packet); err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return false, err
+	}
+
+	versions, err := parseVersionNegotiation(buf[:n])
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		if v == quicVersion1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 解析版本协商包，返回服务端支持的版本列表
+func parseVersionNegotiation(data []byte) ([]uint32, error) {
+	if len(data) < 7 || data[0]&0x80 == 0 || binary.BigEndian.Uint32(data[1:5]) != 0 {
+		return nil, fmt.Errorf("不是版本协商包")
+	}
+
+	offset := 5
+	// 依次跳过DCID和SCID
+	for i := 0; i < 2; i++ {
+		if offset >= len(data) {
+			return nil, fmt.Errorf("版本协商包不完整")
+		}
+		offset += 1 + int(data[offset])
+	}
+	if offset > len(data) {
+		return nil, fmt.Errorf("版本协商包不完整")
+	}
+
+	var versions []uint32
+	for ; offset+4 <= len(data); offset += 4 {
+		versions = append(versions, binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+	return versions, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 限制所有worker发出探测请求的总速度，避免短时间内的大量请求触发公司网络的IDS/WAF规则或出口IP被限流
+//
+// 相邻两次请求的间隔在平均间隔的50%~150%之间随机，平均速度为每秒rate次，
+// 请求不会呈现固定的节奏。nil表示不限制。
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// 下一次请求最早可以发出的时间
+	next time.Time
+}
+
+// 每秒最多rate次请求，rate不大于0时返回nil
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// 等待到可以发出下一次请求，ctx取消时返回false
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval/2 + time.Duration(rand.Int63n(int64(l.interval)+1)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	return sleepContext(ctx, delay)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+)
+
+// 推荐的镜像源数量
+const recommendCount = 3
+
+// 按评分选出推荐的镜像源
+func recommend(results []CheckResult, n int) []CheckResult {
+	sorted := append([]CheckResult(nil), filterSuccess(results)...)
+	sortResults(sorted, "score")
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// 在结果之后输出推荐的镜像源以及应用的命令
+func writeRecommended(w io.Writer, results []CheckResult) {
+	top := recommend(results, recommendCount)
+	if len(top) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	writeRule(w, "=", 60)
+	fmt.Fprintln(w, " 推荐 / Recommended")
+	writeRule(w, "=", 60)
+	mirrors := make([]string, 0, len(top))
+	for i, result := range top {
+		fmt.Fprintf(w, " %d. %-32s 评分: %5.1f  响应时间: %.2fs\n", i+1, result.Host, result.Score, result.Time.Seconds())
+		mirrors = append(mirrors, mirrorURL(result))
+	}
+
+	fmt.Fprintln(w, "\n应用以上镜像源:")
+	fmt.Fprintln(w, "  "+applyCommand(mirrors))
+	writeRule(w, "=", 60)
+}
+
+// 生成应用镜像源的命令
+func applyCommand(mirrors []string) string {
+	config := DaemonConfig{}
+	config.setMirrors(mirrors)
+	data, _ := json.Marshal(config)
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("在 Docker Desktop -> Settings -> Docker Engine 中将 registry-mirrors 设置为 %s", data)
+	}
+
+	// 注意会覆盖daemon.json中的其他配置
+	target := detectDockerTarget()
+	switch {
+	case target.ConfigPath == "":
+		return fmt.Sprintf("在 Docker Desktop -> Settings -> Docker Engine 中将 registry-mirrors 设置为 %s", data)
+	case target.Format == configColima:
+		list, _ := json.Marshal(mirrors)
+		return fmt.Sprintf("在 %s 的 docker 字段中加入 registry-mirrors: %s 后执行 %s", target.ConfigPath, list, target.Restart)
+	case target.NeedRoot:
+		return fmt.Sprintf("echo '%s' | sudo tee %s > /dev/null && sudo %s", data, target.ConfigPath, target.Restart)
+	default:
+		return fmt.Sprintf("mkdir -p %s && echo '%s' > %s && %s", filepath.Dir(target.ConfigPath), data, target.ConfigPath, target.Restart)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 远程主机上以非root用户登录时通过 sudo -n 执行，不会等待输入密码
+const remoteSudoPrelude = `SUDO=; if [ "$(id -u)" -ne 0 ]; then SUDO="sudo -n"; fi; `
+
+// 解析 -ssh 参数，如 root@host1,admin@host2:2222
+func parseSSHHosts(spec string) ([]string, error) {
+	var hosts []string
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if strings.HasPrefix(host, "-") {
+			return nil, fmt.Errorf("无效的主机: %s", host)
+		}
+		if _, port, ok := strings.Cut(host, ":"); ok {
+			if _, err := strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("无效的端口: %s", host)
+			}
+		}
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("-ssh 需要至少一台主机，如 root@host1,root@host2")
+	}
+	return hosts, nil
+}
+
+// 通过ssh在远程主机上执行脚本，stdin为传给脚本的内容
+//
+// 使用系统的ssh命令，复用 ~/.ssh/config、ssh-agent 和 known_hosts；
+// BatchMode 下不会提示输入密码，需要事先配置好密钥登录。
+func sshRun(host, script string, stdin []byte) ([]byte, error) {
+	target, port := host, ""
+	if name, p, ok := strings.Cut(host, ":"); ok {
+		target, port = name, p
+	}
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	// 通过sh执行，不受远程用户登录shell (如fish) 的影响
+	args = append(args, target, "sh -c "+shellQuote([]string{remoteSudoPrelude + script}))
+
+	cmd := exec.Command("ssh", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%v: %s", err, msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+// 把镜像源写入远程主机的daemon.json并让Docker重新加载配置
+//
+// 与本机写入相同: 先备份原配置，写入临时文件并用 dockerd --validate 检查后再替换，
+// 有systemd时执行 systemctl reload docker，否则向dockerd发送SIGHUP。
+func applyRemote(host string, mirrors []string, results []CheckResult, opts applyOptions) error {
+	configPath := daemonConfigPath
+	quotedPath := shellQuote([]string{configPath})
+
+	before, err := sshRun(host, fmt.Sprintf("if [ -e %s ]; then $SUDO cat %s; fi", quotedPath, quotedPath), nil)
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %v", configPath, err)
+	}
+
+	config := &DaemonConfig{}
+	if len(bytes.TrimSpace(before)) > 0 {
+		if err := json.Unmarshal(before, config); err != nil {
+			return fmt.Errorf("解析 %s 失败: %v", configPath, err)
+		}
+	}
+	if opts.Merge {
+		mirrors = mergeMirrors(withoutBlocked(config.RegistryMirrors, opts.Blocked), mirrors, results)
+	}
+	config.setMirrors(mirrors)
+	after, err := marshalDaemonConfig(config)
+	if err != nil {
+		return err
+	}
+	after = append(after, '\n')
+	if err := checkDaemonSchema(before, after); err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		fmt.Printf("\n[dry-run] %s 将写入 %s:\n", host, configPath)
+		writeDiff(os.Stdout, host+":"+configPath, before, after)
+		fmt.Println("写入后将执行 systemctl reload docker (没有systemd时向dockerd发送SIGHUP)")
+		return nil
+	}
+
+	tmp := shellQuote([]string{configPath + ".tmp"})
+	backup := shellQuote([]string{configPath + ".bak." + time.Now().Format(backupTimeFormat)})
+	script := strings.Join([]string{
+		"set -e",
+		"$SUDO mkdir -p " + shellQuote([]string{path.Dir(configPath)}),
+		fmt.Sprintf("if [ -e %s ]; then $SUDO cp -p %s %s; fi", quotedPath, quotedPath, backup),
+		fmt.Sprintf("$SUDO tee %s >/dev/null", tmp),
+		// 旧版本的dockerd没有 --validate 参数，此时跳过检查
+		fmt.Sprintf(`if command -v dockerd >/dev/null 2>&1; then out=$($SUDO dockerd --validate --config-file %s 2>&1) || case "$out" in *"unknown flag"*) ;; *) $SUDO rm -f %s; echo "$out" >&2; exit 1;; esac; fi`, tmp, tmp),
+		fmt.Sprintf("$SUDO mv %s %s", tmp, quotedPath),
+		`if [ -d /run/systemd/system ]; then $SUDO systemctl reload docker; else pid=$(pidof dockerd) || { echo "dockerd没有运行" >&2; exit 1; }; $SUDO kill -HUP $pid; fi`,
+	}, "\n")
+	if _, err := sshRun(host, script, after); err != nil {
+		return fmt.Errorf("写入配置失败: %v", err)
+	}
+	return nil
+}
+
+// 依次配置所有远程主机，单台失败不影响其他主机，返回失败的数量
+func applyRemoteHosts(hosts []string, mirrors []string, results []CheckResult, opts applyOptions) int {
+	failed := 0
+	for _, host := range hosts {
+		fmt.Printf("\n[%s] 正在配置...\n", host)
+		if err := applyRemote(host, mirrors, results, opts); err != nil {
+			fmt.Printf("[%s] 配置失败: %v\n", host, err)
+			failed++
+			continue
+		}
+		if !opts.DryRun {
+			fmt.Printf("[%s] 已写入 %s 并重新加载Docker\n", host, daemonConfigPath)
+		}
+	}
+	if !opts.DryRun {
+		fmt.Printf("\n远程主机配置完成: 成功 %d 台，失败 %d 台\n", len(hosts)-failed, failed)
+	}
+	return failed
+}
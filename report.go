@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+)
+
+// 保存到文件的检测报告
+type Report struct {
+	GeneratedAt time.Time     `json:"generated_at" yaml:"generated_at"`
+	Results     []CheckResult `json:"results" yaml:"results"`
+}
+
+// CSV文件的表头
+var csvHeader = []string{"host", "available", "status_code", "time", "timeout", "protocol", "quic", "warm_time", "attempts", "sources", "ttfb", "upstream", "family", "score", "region", "provider", "auth", "timeout_phase"}
+
+// 判断结果是否算作成功
+func isSuccess(result CheckResult) bool {
+	return result.Available && !result.IsTimeout
+}
+
+// 筛选出成功的结果
+func filterSuccess(results []CheckResult) []CheckResult {
+	var filtered []CheckResult
+	for _, result := range results {
+		if isSuccess(result) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// 按指定字段排序结果 (host / time / ttfb / warm / status / score)
+func sortResults(results []CheckResult, key string) error {
+	switch key {
+	case "", "host":
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Host < results[j].Host
+		})
+	case "time":
+		// 失败和超时的结果排在最后
+		sort.SliceStable(results, func(i, j int) bool {
+			si, sj := isSuccess(results[i]), isSuccess(results[j])
+			if si != sj {
+				return si
+			}
+			return results[i].Time < results[j].Time
+		})
+	case "ttfb":
+		// /v2/ 的响应体很小，首字节时间更能反映获取清单的延迟
+		sort.SliceStable(results, func(i, j int) bool {
+			si, sj := isSuccess(results[i]), isSuccess(results[j])
+			if si != sj {
+				return si
+			}
+			return results[i].TTFB < results[j].TTFB
+		})
+	case "warm":
+		// 没有复用连接数据的结果排在最后
+		sort.SliceStable(results, func(i, j int) bool {
+			wi, wj := results[i].WarmTime > 0, results[j].WarmTime > 0
+			if wi != wj {
+				return wi
+			}
+			return results[i].WarmTime < results[j].WarmTime
+		})
+	case "status":
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].StatusCode < results[j].StatusCode
+		})
+	case "score":
+		// 评分从高到低
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Score > results[j].Score
+		})
+	default:
+		return fmt.Errorf("不支持的排序字段: %s", key)
+	}
+	return nil
+}
+
+// 按指定格式输出结果 (table / json / csv / yaml)
+func writeResults(w io.Writer, results []CheckResult, format string) error {
+	switch format {
+	case "", "table":
+		writeTable(w, results)
+		return nil
+	case "json":
+		return writeJSON(w, results)
+	case "csv":
+		return writeCSV(w, results)
+	case "yaml":
+		return writeYAML(w, results)
+	default:
+		return fmt.Errorf("不支持的输出格式: %s", format)
+	}
+}
+
+// 以表格形式输出结果
+func writeTable(w io.Writer, results []CheckResult) {
+	if plainOutput {
+		writePlainTable(w, results)
+		return
+	}
+
+	fmt.Fprintln(w, "Registry                        状态       状态码     首字节     响应时间        协议        上游")
+	writeRule(w, "-", 103)
+
+	for _, result := range results {
+		status := "✓"
+		if !result.Available {
+			status = "✗"
+		}
+		if result.Pinned > 0 {
+			status += " 置顶"
+		}
+		if result.Cached {
+			status += " 缓存"
+		}
+
+		statusCode := fmt.Sprintf("%d", result.StatusCode)
+		if result.StatusCode == 0 {
+			statusCode = "-"
+		}
+
+		ttfbStr := "-"
+		if result.TTFB > 0 {
+			ttfbStr = fmt.Sprintf("%.2fs", result.TTFB.Seconds())
+		}
+
+		timeStr := timeoutLabel(result.TimeoutPhase)
+		if !result.IsTimeout {
+			timeStr = fmt.Sprintf("%.2fs", result.Time.Seconds())
+			// 冷启动/复用连接
+			if result.WarmTime > 0 {
+				timeStr += fmt.Sprintf("/%.2fs", result.WarmTime.Seconds())
+			}
+		}
+
+		upstream := result.Upstream
+		if upstream == "" {
+			upstream = defaultUpstream
+		}
+
+		fmt.Fprintf(w, "%-30s %-10s %-10s %-10s %-15s %-11s %s\n",
+			result.Host,
+			status,
+			statusCode,
+			ttfbStr,
+			timeStr,
+			protocolLabel(result),
+			upstream,
+		)
+	}
+}
+
+// 每个镜像源输出一行完整的说明，用于plain模式
+func writePlainTable(w io.Writer, results []CheckResult) {
+	for _, result := range results {
+		status := "可用"
+		if !result.Available {
+			status = "不可用"
+		}
+		line := fmt.Sprintf("%s: %s", result.Host, status)
+		if result.Pinned > 0 {
+			line += ", 已置顶"
+		}
+		if result.Cached {
+			line += ", 缓存的结果"
+		}
+
+		if result.StatusCode != 0 {
+			line += fmt.Sprintf(", 状态码 %d", result.StatusCode)
+		}
+		if result.TTFB > 0 {
+			line += fmt.Sprintf(", 首字节 %.2f 秒", result.TTFB.Seconds())
+		}
+		if result.IsTimeout {
+			line += ", " + timeoutLabel(result.TimeoutPhase)
+		} else {
+			line += fmt.Sprintf(", 响应时间 %.2f 秒", result.Time.Seconds())
+			if result.WarmTime > 0 {
+				line += fmt.Sprintf(", 复用连接 %.2f 秒", result.WarmTime.Seconds())
+			}
+		}
+		if protocol := protocolLabel(result); protocol != "" && protocol != "-" {
+			line += ", 协议 " + protocol
+		}
+
+		upstream := result.Upstream
+		if upstream == "" {
+			upstream = defaultUpstream
+		}
+		fmt.Fprintln(w, line+", 上游 "+upstream)
+	}
+}
+
+// 输出表头下的分隔线，plain模式下不输出
+func writeRule(w io.Writer, char string, width int) {
+	if !plainOutput {
+		fmt.Fprintln(w, strings.Repeat(char, width))
+	}
+}
+
+// 在结果表格之后输出各项附加检测的详细信息
+func writeDetails(w io.Writer, results []CheckResult) {
+	writeCapabilities(w, results)
+	writePerIP(w, results)
+	writeUpstreamErrors(w, results)
+}
+
+// 输出非Docker Hub镜像源验证上游失败的原因 (/v2/ 可以访问但拉取不到上游镜像)
+func writeUpstreamErrors(w io.Writer, results []CheckResult) {
+	header := false
+	for _, result := range results {
+		if result.Upstream == "" || result.StatusCode == 0 || result.Error == "" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\n上游验证失败:")
+			header = true
+		}
+		fmt.Fprintf(w, "%-30s %s\n", result.Host, result.Error)
+	}
+}
+
+// 输出各镜像源的能力探测结果 (OCI和插件)，没有探测数据时不输出
+func writeCapabilities(w io.Writer, results []CheckResult) {
+	var probed []CheckResult
+	hasOCI, hasIntegrity := false, false
+	var plugins []string
+	for _, result := range results {
+		if result.OCI == nil && result.Integrity == nil && len(result.Plugins) == 0 {
+			continue
+		}
+		probed = append(probed, result)
+		hasOCI = hasOCI || result.OCI != nil
+		hasIntegrity = hasIntegrity || result.Integrity != nil
+		for _, plugin := range result.Plugins {
+			if !containsString(plugins, plugin.Name) {
+				plugins = append(plugins, plugin.Name)
+			}
+		}
+	}
+	if len(probed) == 0 {
+		return
+	}
+
+	header := fmt.Sprintf("%-30s", "Registry")
+	if hasOCI {
+		header += fmt.Sprintf(" %-10s %-14s", "OCI清单", "Referrers API")
+	}
+	if hasIntegrity {
+		header += fmt.Sprintf(" %-10s", "内容一致")
+	}
+	for _, name := range plugins {
+		header += fmt.Sprintf(" %-12s", name)
+	}
+
+	fmt.Fprintln(w, "\n能力探测:")
+	fmt.Fprintln(w, header)
+	writeRule(w, "-", 65+13*len(plugins))
+	for _, result := range probed {
+		line := fmt.Sprintf("%-30s", result.Host)
+		var errors []string
+		if hasOCI {
+			if result.OCI != nil {
+				line += fmt.Sprintf(" %-10s %-14s", checkMark(result.OCI.Manifest), checkMark(result.OCI.Referrers))
+				if result.OCI.Error != "" {
+					errors = append(errors, result.OCI.Error)
+				}
+			} else {
+				line += fmt.Sprintf(" %-10s %-14s", "-", "-")
+			}
+		}
+		if hasIntegrity {
+			mark := "-"
+			if result.Integrity != nil {
+				if result.Integrity.Compared {
+					mark = checkMark(result.Integrity.Match)
+				}
+				if result.Integrity.Error != "" {
+					errors = append(errors, result.Integrity.Error)
+				}
+			}
+			line += fmt.Sprintf(" %-10s", mark)
+		}
+		for _, name := range plugins {
+			mark := "-"
+			for _, plugin := range result.Plugins {
+				if plugin.Name != name {
+					continue
+				}
+				mark = checkMark(plugin.Pass)
+				if plugin.Error != "" {
+					errors = append(errors, name+": "+plugin.Error)
+				} else if plugin.Detail != "" {
+					errors = append(errors, name+": "+plugin.Detail)
+				}
+			}
+			line += fmt.Sprintf(" %-12s", mark)
+		}
+		fmt.Fprintln(w, line+" "+strings.Join(errors, "; "))
+	}
+}
+
+func checkMark(ok bool) string {
+	switch {
+	case plainOutput && ok:
+		return "是"
+	case plainOutput:
+		return "否"
+	case ok:
+		return "✓"
+	}
+	return "✗"
+}
+
+// 协议的简短显示，如 h2、h1.1+h3
+func protocolLabel(result CheckResult) string {
+	var label string
+	switch result.Protocol {
+	case "":
+		label = "-"
+	case "HTTP/2.0":
+		label = "h2"
+	case "HTTP/1.1":
+		label = "h1.1"
+	default:
+		label = strings.ToLower(result.Protocol)
+	}
+	if result.QUIC {
+		label += "+h3"
+	}
+	// 只有一种地址族的镜像源
+	switch result.Family {
+	case familyIPv4:
+		label += " v4"
+	case familyIPv6:
+		label += " v6"
+	}
+	return label
+}
+
+func writeJSON(w io.Writer, results []CheckResult) error {
+	report := Report{
+		GeneratedAt: time.Now(),
+		Results:     results,
+	}
+	if report.Results == nil {
+		report.Results = []CheckResult{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(report)
+}
+
+func writeYAML(w io.Writer, results []CheckResult) error {
+	report := Report{
+		GeneratedAt: time.Now(),
+		Results:     results,
+	}
+	if report.Results == nil {
+		report.Results = []CheckResult{}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// 严格解析YAML，出现未知字段时报错，避免配置项拼写错误被静默忽略
+func unmarshalYAMLStrict(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// 空文件视为空配置
+	if err := decoder.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, results []CheckResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := writer.Write(csvRecord(result)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// 一个结果对应的CSV行，列的顺序与csvHeader一致
+func csvRecord(result CheckResult) []string {
+	return []string{
+		result.Host,
+		strconv.FormatBool(result.Available),
+		strconv.Itoa(result.StatusCode),
+		strconv.FormatFloat(result.Time.Seconds(), 'f', 3, 64),
+		strconv.FormatBool(result.IsTimeout),
+		result.Protocol,
+		strconv.FormatBool(result.QUIC),
+		strconv.FormatFloat(result.WarmTime.Seconds(), 'f', 3, 64),
+		strconv.Itoa(result.Attempts),
+		strings.Join(result.Sources, ";"),
+		strconv.FormatFloat(result.TTFB.Seconds(), 'f', 3, 64),
+		result.Upstream,
+		result.Family,
+		strconv.FormatFloat(result.Score, 'f', 1, 64),
+		result.Region,
+		result.Provider,
+		result.Auth,
+		result.TimeoutPhase,
+	}
+}
+
+// 根据文件扩展名推断报告格式和压缩方式，如 results.json.gz
+func formatFromPath(path string) (format, compression string, err error) {
+	name := strings.ToLower(path)
+	switch ext := filepath.Ext(name); ext {
+	case ".gz", ".zst":
+		compression = ext
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	switch filepath.Ext(name) {
+	case ".json":
+		return "json", compression, nil
+	case ".csv":
+		return "csv", compression, nil
+	case ".yaml", ".yml":
+		return "yaml", compression, nil
+	default:
+		return "", "", fmt.Errorf("无法识别的文件格式: %s (支持 .json / .csv / .yaml，可附加 .gz / .zst 压缩)", path)
+	}
+}
+
+// 将结果保存到文件，格式和压缩方式由扩展名决定
+func saveResults(path string, results []CheckResult) error {
+	return saveWith(path, func(w io.Writer, format string) error {
+		return writeResults(w, results, format)
+	})
+}
+
+// 将暂存的结果按完成顺序保存到文件，格式和压缩方式由扩展名决定
+func saveSpool(path string, spool *resultSpool) error {
+	return saveWith(path, func(w io.Writer, format string) error {
+		return writeSpool(w, spool, format, false)
+	})
+}
+
+// 创建文件并按扩展名选择压缩方式，由write写入对应格式的内容
+func saveWith(path string, write func(w io.Writer, format string) error) error {
+	format, compression, err := formatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var w io.WriteCloser
+	switch compression {
+	case ".gz":
+		w = gzip.NewWriter(file)
+	case ".zst":
+		if w, err = zstd.NewWriter(file); err != nil {
+			return fmt.Errorf("创建zstd压缩失败: %v", err)
+		}
+	default:
+		w = nopWriteCloser{file}
+	}
+
+	if err := write(w, format); err != nil {
+		return fmt.Errorf("写入结果失败: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("写入结果失败: %v", err)
+	}
+	return file.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// 从文件读取之前保存的结果，压缩文件会自动解压
+func loadResults(path string) ([]CheckResult, error) {
+	format, compression, err := formatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	switch compression {
+	case ".gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("解压gzip失败: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	case ".zst":
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("解压zstd失败: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	switch format {
+	case "json":
+		var report Report
+		if err := json.NewDecoder(r).Decode(&report); err != nil {
+			return nil, fmt.Errorf("解析JSON失败: %v", err)
+		}
+		return report.Results, nil
+	case "yaml":
+		var report Report
+		if err := yaml.NewDecoder(r).Decode(&report); err != nil {
+			return nil, fmt.Errorf("解析YAML失败: %v", err)
+		}
+		return report.Results, nil
+	default:
+		return readCSV(r)
+	}
+}
+
+func readCSV(r io.Reader) ([]CheckResult, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("解析CSV失败: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	var results []CheckResult
+	for i, record := range records[1:] {
+		// 旧版本的CSV没有协议相关的列
+		if len(record) < 5 {
+			return nil, fmt.Errorf("CSV第%d行字段不足", i+2)
+		}
+		available, _ := strconv.ParseBool(record[1])
+		statusCode, _ := strconv.Atoi(record[2])
+		seconds, _ := strconv.ParseFloat(record[3], 64)
+		isTimeout, _ := strconv.ParseBool(record[4])
+
+		result := CheckResult{
+			Host:       record[0],
+			Available:  available,
+			StatusCode: statusCode,
+			Time:       time.Duration(seconds * float64(time.Second)),
+			IsTimeout:  isTimeout,
+		}
+		if len(record) >= 7 {
+			result.Protocol = record[5]
+			result.QUIC, _ = strconv.ParseBool(record[6])
+		}
+		if len(record) >= 8 {
+			warm, _ := strconv.ParseFloat(record[7], 64)
+			result.WarmTime = time.Duration(warm * float64(time.Second))
+		}
+		if len(record) >= 9 {
+			result.Attempts, _ = strconv.Atoi(record[8])
+		}
+		if len(record) >= 10 && record[9] != "" {
+			result.Sources = strings.Split(record[9], ";")
+		}
+		if len(record) >= 11 {
+			ttfb, _ := strconv.ParseFloat(record[10], 64)
+			result.TTFB = time.Duration(ttfb * float64(time.Second))
+		}
+		if len(record) >= 12 {
+			result.Upstream = record[11]
+		}
+		if len(record) >= 13 {
+			result.Family = record[12]
+		}
+		if len(record) >= 14 {
+			result.Score, _ = strconv.ParseFloat(record[13], 64)
+		}
+		if len(record) >= 17 {
+			result.Region, result.Provider, result.Auth = record[14], record[15], record[16]
+		}
+		if len(record) >= 18 {
+			result.TimeoutPhase = record[17]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
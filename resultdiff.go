@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// 响应时间的变化小于该值时不报告，避免网络抖动产生大量无意义的变化
+const diffMinLatencyChange = 100 * time.Millisecond
+
+// 两次检测结果之间的变化
+type resultDiff struct {
+	Added     []CheckResult
+	Removed   []CheckResult
+	Recovered []CheckResult
+	Failed    []CheckResult
+	// 两次都可用且响应时间变化超过阈值的镜像源，按变化幅度排序
+	Slower, Faster [][2]CheckResult
+}
+
+// 比较两次检测的结果，threshold为响应时间相对变化的阈值 (如0.5表示变化超过50%)
+func diffResults(before, after []CheckResult, threshold float64) resultDiff {
+	old := map[string]CheckResult{}
+	for _, result := range before {
+		old[checkpointKey(result.Host, result.Upstream)] = result
+	}
+	var diff resultDiff
+	seen := map[string]bool{}
+	for _, result := range after {
+		key := checkpointKey(result.Host, result.Upstream)
+		seen[key] = true
+		previous, ok := old[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, result)
+		case !isSuccess(previous) && isSuccess(result):
+			diff.Recovered = append(diff.Recovered, result)
+		case isSuccess(previous) && !isSuccess(result):
+			diff.Failed = append(diff.Failed, result)
+		case isSuccess(previous) && isSuccess(result):
+			change := latencyChange(previous, result)
+			if change < diffMinLatencyChange || float64(change) < float64(previous.Time)*threshold {
+				continue
+			}
+			if result.Time > previous.Time {
+				diff.Slower = append(diff.Slower, [2]CheckResult{previous, result})
+			} else {
+				diff.Faster = append(diff.Faster, [2]CheckResult{previous, result})
+			}
+		}
+	}
+	for _, result := range before {
+		if !seen[checkpointKey(result.Host, result.Upstream)] {
+			diff.Removed = append(diff.Removed, result)
+		}
+	}
+
+	for _, pairs := range [][][2]CheckResult{diff.Slower, diff.Faster} {
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return latencyChange(pairs[i][0], pairs[i][1]) > latencyChange(pairs[j][0], pairs[j][1])
+		})
+	}
+	return diff
+}
+
+// 两次检测之间响应时间变化的绝对值
+func latencyChange(before, after CheckResult) time.Duration {
+	if after.Time > before.Time {
+		return after.Time - before.Time
+	}
+	return before.Time - after.Time
+}
+
+// 以文本形式输出变化，没有变化的部分不输出
+func writeResultDiff(w io.Writer, diff resultDiff) {
+	hosts := func(title string, results []CheckResult, detail func(CheckResult) string) {
+		if len(results) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s (%d):\n", title, len(results))
+		for _, result := range results {
+			fmt.Fprintf(w, "  %s%s\n", result.Host, detail(result))
+		}
+	}
+	latency := func(result CheckResult) string {
+		return fmt.Sprintf(" (%.2fs)", result.Time.Seconds())
+	}
+	reason := func(result CheckResult) string {
+		switch {
+		case result.IsTimeout:
+			return " (" + timeoutLabel(result.TimeoutPhase) + ")"
+		case result.StatusCode != 0:
+			return fmt.Sprintf(" (状态码: %d)", result.StatusCode)
+		case result.Error != "":
+			return " (" + result.Error + ")"
+		}
+		return ""
+	}
+	status := func(result CheckResult) string {
+		if isSuccess(result) {
+			return " 可用"
+		}
+		return " 不可用"
+	}
+	pairs := func(title string, pairs [][2]CheckResult) {
+		if len(pairs) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s (%d):\n", title, len(pairs))
+		for _, pair := range pairs {
+			fmt.Fprintf(w, "  %s %.2fs -> %.2fs\n", pair[1].Host, pair[0].Time.Seconds(), pair[1].Time.Seconds())
+		}
+	}
+
+	hosts("变为不可用", diff.Failed, reason)
+	hosts("恢复可用", diff.Recovered, latency)
+	pairs("变慢", diff.Slower)
+	pairs("变快", diff.Faster)
+	hosts("新增", diff.Added, status)
+	hosts("移除", diff.Removed, status)
+	if len(diff.Failed)+len(diff.Recovered)+len(diff.Slower)+len(diff.Faster)+len(diff.Added)+len(diff.Removed) == 0 {
+		fmt.Fprintln(w, "两次检测的结果没有变化")
+	}
+}
+
+// diff 子命令：比较两次保存的检测结果，列出可用性和响应时间的变化，支持 .gz / .zst 压缩的结果文件
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 0.5, "响应时间相对变化超过该比例 (且至少100ms) 时报告变慢或变快")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker diff [参数] <之前的结果文件> <之后的结果文件>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("请指定两个结果文件")
+	}
+	if *threshold < 0 {
+		return fmt.Errorf("-threshold 不能为负数")
+	}
+	before, err := loadResults(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := loadResults(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	writeResultDiff(os.Stdout, diffResults(before, after, *threshold))
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// 重启后等待Docker恢复运行的时间，Docker Desktop和Colima重启虚拟机较慢
+const dockerStartTimeout = 2 * time.Minute
+
+// 等待Docker恢复运行，超时后返回最后一次检查的错误
+func waitDockerUp(timeout time.Duration) error {
+	fmt.Println("正在等待Docker恢复运行...")
+	deadline := time.Now().Add(timeout)
+	for {
+		err := dockerUp()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s内没有恢复运行: %v", timeout, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// 检查Docker是否正常运行: 优先通过Engine API，socket不在默认位置时 (如Docker Desktop的context) 使用 docker info
+func dockerUp() error {
+	if enginePing(context.Background()) == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), engineTimeout*2)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{.ServerVersion}}").CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("docker info 失败: %s", msg)
+		}
+		return fmt.Errorf("docker info 失败: %v", err)
+	}
+	return nil
+}
+
+// 新配置导致Docker无法正常工作时恢复写入前的配置，并再次执行activate (重启或重新加载)
+//
+// backup为空表示写入前没有配置文件，此时删除新写入的文件。总是返回错误，说明失败原因和恢复结果。
+func rollbackConfig(target dockerTarget, backup, activate string, cause error) error {
+	fmt.Printf("\n%v\n正在恢复原配置...\n", cause)
+
+	var err error
+	if backup == "" {
+		err = os.Remove(target.ConfigPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(backup); err == nil {
+			err = atomicWriteFile(target.ConfigPath, data, nil)
+		}
+	}
+	if err != nil {
+		if backup == "" {
+			return fmt.Errorf("%v；恢复原配置失败: %v，请手动删除 %s", cause, err, target.ConfigPath)
+		}
+		return fmt.Errorf("%v；恢复原配置失败: %v，请手动执行 restore 子命令恢复 %s", cause, err, backup)
+	}
+	if backup == "" {
+		fmt.Printf("已删除新写入的 %s\n", target.ConfigPath)
+	} else {
+		fmt.Printf("已从 %s 恢复原配置\n", backup)
+	}
+
+	if target.DaemonReload != "" {
+		if err := execCommand(target.DaemonReload); err != nil {
+			return fmt.Errorf("%v；已恢复原配置，但重载Docker daemon失败: %v", cause, err)
+		}
+	}
+	if activate != "" {
+		if err := execCommand(activate); err != nil {
+			return fmt.Errorf("%v；已恢复原配置，但执行 %s 失败: %v", cause, activate, err)
+		}
+		if target.isDockerd() {
+			if err := waitDockerUp(dockerStartTimeout); err != nil {
+				return fmt.Errorf("%v；已恢复原配置，但Docker仍未正常运行: %v", cause, err)
+			}
+		}
+	}
+	return fmt.Errorf("%v，已恢复原配置", cause)
+}
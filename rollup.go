@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 单个镜像源在统计区间内的汇总
+type mirrorRollup struct {
+	Host   string
+	Checks int
+	OK     int
+	// 可用时的响应时间，用于计算中位数
+	latencies []time.Duration
+	Incidents []incident
+}
+
+// 一次故障: 从连续失败开始，到恢复为止
+type incident struct {
+	Start time.Time
+	// 恢复的时间，仍未恢复时为零值
+	End time.Time
+}
+
+func (m *mirrorRollup) uptime() float64 {
+	if m.Checks == 0 {
+		return 0
+	}
+	return float64(m.OK) * 100 / float64(m.Checks)
+}
+
+func (m *mirrorRollup) medianLatency() time.Duration {
+	if len(m.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), m.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// report 子命令：汇总历史记录，生成可以直接发到团队群里的镜像源健康报告
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	historyPath := fs.String("history", "history.jsonl", "历史记录文件 (通过 -history 或 agent 的 history 配置生成)")
+	since := fs.String("since", "7d", "统计最近多长时间的记录，如 7d、24h")
+	format := fs.String("format", "markdown", "报告格式 (markdown/html)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker report [参数]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	window, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	end := time.Now()
+	start := end.Add(-window)
+
+	runs, err := readHistory(*historyPath, start)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("%s 中没有 %s 之后的记录", *historyPath, start.Format("2006-01-02 15:04"))
+	}
+
+	rollups := rollupHistory(runs)
+	switch *format {
+	case "markdown", "md":
+		writeRollupMarkdown(os.Stdout, rollups, runs, start, end)
+	case "html":
+		writeRollupHTML(os.Stdout, rollups, runs, start, end)
+	default:
+		return fmt.Errorf("不支持的报告格式: %s", *format)
+	}
+	return nil
+}
+
+// 解析时间长度，在 time.ParseDuration 的基础上支持 d (天) 和 w (周)
+func parseSince(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			if value, err := strconv.ParseFloat(n, 64); err == nil && value > 0 {
+				return time.Duration(value * float64(unit)), nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("无效的时间长度: %s (如 7d、24h)", s)
+	}
+	return d, nil
+}
+
+// 按镜像源汇总历史记录，按可用率从高到低、延迟从低到高排序
+func rollupHistory(runs []historyRun) []*mirrorRollup {
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.Before(runs[j].Time) })
+
+	byHost := map[string]*mirrorRollup{}
+	for _, run := range runs {
+		for _, result := range run.Results {
+			m := byHost[result.Host]
+			if m == nil {
+				m = &mirrorRollup{Host: result.Host}
+				byHost[result.Host] = m
+			}
+			m.Checks++
+
+			// 故障中的镜像源第一次恢复时结束该次故障
+			ongoing := len(m.Incidents) > 0 && m.Incidents[len(m.Incidents)-1].End.IsZero()
+			if result.OK {
+				m.OK++
+				m.latencies = append(m.latencies, result.Time)
+				if ongoing {
+					m.Incidents[len(m.Incidents)-1].End = run.Time
+				}
+			} else if !ongoing {
+				m.Incidents = append(m.Incidents, incident{Start: run.Time})
+			}
+		}
+	}
+
+	rollups := make([]*mirrorRollup, 0, len(byHost))
+	for _, m := range byHost {
+		rollups = append(rollups, m)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		ui, uj := rollups[i].uptime(), rollups[j].uptime()
+		if ui != uj {
+			return ui > uj
+		}
+		li, lj := rollups[i].medianLatency(), rollups[j].medianLatency()
+		if li != lj {
+			return li < lj
+		}
+		return rollups[i].Host < rollups[j].Host
+	})
+	return rollups
+}
+
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+func formatIncident(in incident) (start, duration string) {
+	start = in.Start.Local().Format("01-02 15:04")
+	if in.End.IsZero() {
+		return start, "未恢复"
+	}
+	return start, in.End.Sub(in.Start).Round(time.Minute).String()
+}
+
+func writeRollupMarkdown(w io.Writer, rollups []*mirrorRollup, runs []historyRun, start, end time.Time) {
+	fmt.Fprintln(w, "# 镜像源健康报告")
+	fmt.Fprintf(w, "\n统计区间: %s ~ %s，共 %d 次检测\n\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"), len(runs))
+
+	fmt.Fprintln(w, "| 排名 | 镜像源 | 可用率 | 延迟中位数 | 故障次数 |")
+	fmt.Fprintln(w, "|---:|---|---:|---:|---:|")
+	for i, m := range rollups {
+		fmt.Fprintf(w, "| %d | %s | %.1f%% | %s | %d |\n", i+1, m.Host, m.uptime(), formatLatency(m.medianLatency()), len(m.Incidents))
+	}
+
+	header := false
+	for _, m := range rollups {
+		for _, in := range m.Incidents {
+			if !header {
+				fmt.Fprint(w, "\n## 故障记录\n\n")
+				fmt.Fprintln(w, "| 镜像源 | 开始时间 | 持续时间 |")
+				fmt.Fprintln(w, "|---|---|---|")
+				header = true
+			}
+			started, duration := formatIncident(in)
+			fmt.Fprintf(w, "| %s | %s | %s |\n", m.Host, started, duration)
+		}
+	}
+}
+
+func writeRollupHTML(w io.Writer, rollups []*mirrorRollup, runs []historyRun, start, end time.Time) {
+	fmt.Fprintln(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>镜像源健康报告</title></head><body>")
+	fmt.Fprintln(w, "<h1>镜像源健康报告</h1>")
+	fmt.Fprintf(w, "<p>统计区间: %s ~ %s，共 %d 次检测</p>\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"), len(runs))
+
+	fmt.Fprintln(w, "<table border=\"1\" cellspacing=\"0\" cellpadding=\"4\">")
+	fmt.Fprintln(w, "<tr><th>排名</th><th>镜像源</th><th>可用率</th><th>延迟中位数</th><th>故障次数</th></tr>")
+	for i, m := range rollups {
+		fmt.Fprintf(w, "<tr><td>%d</td><td>%s</td><td>%.1f%%</td><td>%s</td><td>%d</td></tr>\n",
+			i+1, html.EscapeString(m.Host), m.uptime(), formatLatency(m.medianLatency()), len(m.Incidents))
+	}
+	fmt.Fprintln(w, "</table>")
+
+	header := false
+	for _, m := range rollups {
+		for _, in := range m.Incidents {
+			if !header {
+				fmt.Fprintln(w, "<h2>故障记录</h2>")
+				fmt.Fprintln(w, "<table border=\"1\" cellspacing=\"0\" cellpadding=\"4\">")
+				fmt.Fprintln(w, "<tr><th>镜像源</th><th>开始时间</th><th>持续时间</th></tr>")
+				header = true
+			}
+			started, duration := formatIncident(in)
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n", html.EscapeString(m.Host), started, duration)
+		}
+	}
+	if header {
+		fmt.Fprintln(w, "</table>")
+	}
+	fmt.Fprintln(w, "</body></html>")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// 检测的优先级，数值越小越先检测
+const (
+	// 置顶的镜像源
+	priorityPinned = iota
+	// 当前已配置的镜像源
+	priorityConfigured
+	// 列表中的其余镜像源
+	priorityNormal
+)
+
+// 决定检测顺序的优先级，nil表示按列表顺序检测
+//
+// 置顶和当前已配置的镜像源是最关心的结果，排在最前面检测，
+// 检测几百个镜像源时不需要等到最后才知道它们是否仍然可用。
+type jobPriority map[string]int
+
+// 根据置顶列表和当前配置的镜像源构造优先级
+func newJobPriority(pinned []listEntry, configured []string) jobPriority {
+	priority := jobPriority{}
+	for _, mirror := range configured {
+		if host, _, err := normalizeListHost(mirror); err == nil {
+			priority[host] = priorityConfigured
+		}
+	}
+	for _, entry := range pinned {
+		priority[entry.Host] = priorityPinned
+	}
+	return priority
+}
+
+// host的优先级，不在置顶列表和当前配置中时为priorityNormal
+func (p jobPriority) of(host string) int {
+	if level, ok := p[host]; ok {
+		return level
+	}
+	return priorityNormal
+}
+
+// 按优先级排列检测顺序，同一优先级内保持原有顺序 (包括 -shuffle 打乱后的顺序)
+func (p jobPriority) schedule(entries []listEntry) []listEntry {
+	if len(p) == 0 {
+		return entries
+	}
+	ordered := append([]listEntry(nil), entries...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return p.of(ordered[i].Host) < p.of(ordered[j].Host)
+	})
+	return ordered
+}
+
+// 优先检测的镜像源完成后立即显示一行结果，其余镜像源在后台继续检测
+func (p jobPriority) announce(w io.Writer, result CheckResult) {
+	label := ""
+	switch p.of(result.Host) {
+	case priorityPinned:
+		label = "置顶"
+	case priorityConfigured:
+		label = "已配置"
+	default:
+		return
+	}
+
+	status := "不可用"
+	if isSuccess(result) {
+		status = fmt.Sprintf("可用, 响应时间 %.2fs", result.Time.Seconds())
+	} else if result.IsTimeout {
+		status += ", " + timeoutLabel(result.TimeoutPhase)
+	}
+	fmt.Fprintf(w, "%s的镜像源 %s: %s\n", label, result.Host, status)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// daemon.json 的JSON Schema，只包含dockerd的常用字段
+//
+//go:embed daemon.schema.json
+var daemonSchemaJSON []byte
+
+// 解析后的daemon.json Schema
+var daemonSchema = mustParseSchema(daemonSchemaJSON)
+
+// 支持的JSON Schema子集: type、properties、additionalProperties、items、enum、minimum
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	// false表示不允许其他字段，为schema时其他字段按其校验
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	Items                *jsonSchema     `json:"items"`
+	Enum                 []interface{}   `json:"enum"`
+	Minimum              *float64        `json:"minimum"`
+
+	additional *jsonSchema
+	closed     bool
+}
+
+func mustParseSchema(data []byte) *jsonSchema {
+	schema := &jsonSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		panic(fmt.Sprintf("解析daemon.json schema失败: %v", err))
+	}
+	schema.compile()
+	return schema
+}
+
+func (s *jsonSchema) compile() {
+	switch raw := strings.TrimSpace(string(s.AdditionalProperties)); {
+	case raw == "false":
+		s.closed = true
+	case strings.HasPrefix(raw, "{"):
+		s.additional = &jsonSchema{}
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			panic(fmt.Sprintf("解析daemon.json schema失败: %v", err))
+		}
+		s.additional.compile()
+	}
+	for _, property := range s.Properties {
+		property.compile()
+	}
+	if s.Items != nil {
+		s.Items.compile()
+	}
+}
+
+// 按schema检查daemon.json，类型不匹配为错误；未知字段为警告，schema中的字段列表可能不全
+func validateDaemonSchema(data []byte) []configProblem {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []configProblem{{Msg: jsonErrorPosition(data, err)}}
+	}
+	var problems []configProblem
+	daemonSchema.validate(value, "", &problems)
+	return problems
+}
+
+func (s *jsonSchema) validate(value interface{}, path string, problems *[]configProblem) {
+	addf := func(warning bool, format string, a ...interface{}) {
+		*problems = append(*problems, configProblem{Warning: warning, Msg: fmt.Sprintf(format, a...)})
+	}
+	name := path
+	if name == "" {
+		name = "daemon.json"
+	}
+
+	if s.Type != "" && !schemaTypeMatches(s.Type, value) {
+		addf(false, "%s 应为%s，实际为%s", name, schemaTypeName(s.Type), schemaTypeName(jsonTypeOf(value)))
+		return
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		addf(false, "%s 的值 %v 无效，可选: %s", name, value, joinValues(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			switch property, ok := s.Properties[key]; {
+			case ok:
+				property.validate(v[key], child, problems)
+			case s.additional != nil:
+				s.additional.validate(v[key], child, problems)
+			case s.closed:
+				addf(true, "未知的配置项 %s，dockerd遇到不认识的配置项会拒绝启动，请检查拼写", child)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", name, i), problems)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			addf(false, "%s 不能小于 %v", name, *s.Minimum)
+		}
+	}
+}
+
+func schemaTypeMatches(schemaType string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	if schemaType == "number" && actual == "integer" {
+		return true
+	}
+	return schemaType == actual
+}
+
+// JSON值的类型，整数单独区分
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func schemaTypeName(t string) string {
+	switch t {
+	case "null":
+		return "null"
+	case "boolean":
+		return "布尔值"
+	case "integer":
+		return "整数"
+	case "number":
+		return "数字"
+	case "string":
+		return "字符串"
+	case "array":
+		return "数组"
+	case "object":
+		return "对象"
+	}
+	return t
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func joinValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, "/")
+}
+
+// 写入daemon.json前按schema检查: 原配置中已有的问题作为警告输出，新配置引入的错误阻止写入
+func checkDaemonSchema(before, after []byte) error {
+	existing := map[string]bool{}
+	if len(strings.TrimSpace(string(before))) > 0 {
+		for _, problem := range validateDaemonSchema(before) {
+			existing[problem.Msg] = true
+			fmt.Printf("警告: 原配置中已有的问题: %s\n", problem.Msg)
+		}
+	}
+
+	var introduced []string
+	for _, problem := range validateDaemonSchema(after) {
+		if problem.Warning || existing[problem.Msg] {
+			continue
+		}
+		introduced = append(introduced, problem.Msg)
+	}
+	if len(introduced) > 0 {
+		return fmt.Errorf("新配置不符合daemon.json的格式，dockerd将无法启动:\n  %s", strings.Join(introduced, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "math"
+
+// 计算镜像源的综合评分 (0-100)，不可用的镜像源为0
+//
+// 以响应时间为基础: 0.1s约91分，0.5s约67分，1s为50分；
+// 证书无法通过校验扣10分，插件的评分调整直接累加，插件未通过时扣50分。
+func scoreResult(result CheckResult) float64 {
+	if !isSuccess(result) {
+		return 0
+	}
+
+	latency := result.Time
+	if result.WarmTime > 0 {
+		latency = result.WarmTime
+	}
+	score := 100 / (1 + latency.Seconds())
+
+	if !result.TLSVerified {
+		score -= 10
+	}
+	for _, plugin := range result.Plugins {
+		score += plugin.Score
+		if !plugin.Pass {
+			score -= 50
+		}
+	}
+
+	score = math.Max(0, math.Min(100, score))
+	return math.Round(score*10) / 10
+}
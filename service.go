@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// install-service 默认的服务名
+const defaultServiceName = "docker-registry-checker"
+
+// systemd unit文件所在的目录，user为true时为当前用户的目录
+func systemdUnitDir(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("无法确定用户目录: %v", err)
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// systemctl命令，user为true时管理当前用户的服务
+func systemctlCommand(user bool, args ...string) string {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	return "systemctl " + shellQuote(args)
+}
+
+// 确认可以管理systemd服务: 系统使用systemd，系统级服务需要root权限
+func checkSystemd(user bool) error {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return fmt.Errorf("当前系统没有使用systemd")
+	}
+	if !user && os.Geteuid() > 0 {
+		return fmt.Errorf("安装系统级服务需要root权限，请使用sudo运行，或指定 -user 安装为当前用户的服务")
+	}
+	return nil
+}
+
+// 按systemd的规则引用ExecStart中的参数
+//
+// ExecStart不经过shell，但会展开 % 开头的说明符和 $ 开头的环境变量，需要分别写成 %% 和 $$。
+func systemdQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+		if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// 生成服务的unit
+//
+// timer为true时是由定时器触发的一次性服务，否则是常驻的watch服务，异常退出后自动重启。
+func renderServiceUnit(command []string, workdir string, timer, user bool) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	if timer {
+		b.WriteString("Description=Docker镜像源检测\n")
+	} else {
+		b.WriteString("Description=Docker镜像源定期检测 (watch)\n")
+	}
+	b.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+
+	b.WriteString("[Service]\n")
+	if timer {
+		b.WriteString("Type=oneshot\n")
+	} else {
+		b.WriteString("Type=simple\n")
+	}
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote([]string{workdir}))
+	fmt.Fprintf(&b, "ExecStart=%s\n", systemdQuote(command))
+	if timer {
+		return b.String()
+	}
+	b.WriteString("Restart=on-failure\nRestartSec=30\n\n")
+
+	b.WriteString("[Install]\n")
+	if user {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String()
+}
+
+// 生成每隔interval触发一次服务的定时器
+func renderTimerUnit(interval time.Duration) string {
+	return fmt.Sprintf(`[Unit]
+Description=定期检测Docker镜像源
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=%ds
+
+[Install]
+WantedBy=timers.target
+`, int64(interval.Seconds()))
+}
+
+// install-service 子命令：生成并启用运行本工具的systemd服务
+//
+// 默认安装常驻的 watch 服务；指定 -timer 时安装一个定时器，每隔一段时间以无人值守的方式执行一次检测。
+// -- 之后的参数原样传给 watch (或检测)。
+func runInstallService(args []string) error {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	name := fs.String("name", defaultServiceName, "服务名")
+	user := fs.Bool("user", false, "安装为当前用户的systemd服务 (systemctl --user)，不需要root权限")
+	timer := fs.Duration("timer", 0, "安装定时器，每隔该时间 (如 1h) 执行一次检测 (check -yes -plain)，而不是常驻的watch")
+	dryRun := fs.Bool("dry-run", false, "只输出将要写入的unit文件，不安装")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker install-service [参数] [-- watch或检测的参数]")
+		fmt.Fprintln(fs.Output(), "示例: docker-registry-checker install-service -- -interval 10m -apply fastest:2 -listen :8080")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *name == "" || strings.ContainsAny(*name, "/ ") {
+		return fmt.Errorf("无效的服务名: %q", *name)
+	}
+	if *timer != 0 && *timer < time.Second {
+		return fmt.Errorf("-timer 至少为1s")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("无法确定程序路径: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(self); err == nil {
+		self = resolved
+	}
+	// 列表文件、黑名单、状态文件等相对路径都相对于安装时的当前目录
+	workdir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("无法确定当前目录: %v", err)
+	}
+
+	command := []string{self, "watch"}
+	if *timer > 0 {
+		command = []string{self, "check", "-yes", "-plain"}
+	}
+	command = append(command, fs.Args()...)
+
+	units := map[string]string{*name + ".service": renderServiceUnit(command, workdir, *timer > 0, *user)}
+	unit := *name + ".service"
+	if *timer > 0 {
+		unit = *name + ".timer"
+		units[unit] = renderTimerUnit(*timer)
+	}
+
+	dir, err := systemdUnitDir(*user)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, file := range []string{*name + ".service", *name + ".timer"} {
+			if content, ok := units[file]; ok {
+				fmt.Printf("# %s\n%s\n", filepath.Join(dir, file), content)
+			}
+		}
+		return nil
+	}
+
+	if err := checkSystemd(*user); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	// 从定时器改为常驻服务时去掉之前的定时器；反过来时先停用之前常驻的服务
+	if *timer == 0 {
+		removeTimer(dir, *name, *user)
+	} else if _, err := os.Stat(filepath.Join(dir, *name+".service")); err == nil {
+		execCommand(systemctlCommand(*user, "disable", "--now", *name+".service"))
+	}
+	for _, file := range []string{*name + ".service", *name + ".timer"} {
+		content, ok := units[file]
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", file, err)
+		}
+		fmt.Printf("已写入 %s\n", filepath.Join(dir, file))
+	}
+
+	if err := execCommand(systemctlCommand(*user, "daemon-reload")); err != nil {
+		return fmt.Errorf("重新加载systemd配置失败: %v", err)
+	}
+	if err := execCommand(systemctlCommand(*user, "enable", unit)); err != nil {
+		return fmt.Errorf("启用 %s 失败: %v", unit, err)
+	}
+	// 重新安装时让新的参数立即生效
+	if err := execCommand(systemctlCommand(*user, "restart", unit)); err != nil {
+		return fmt.Errorf("启动 %s 失败: %v", unit, err)
+	}
+	journal := "journalctl -u " + *name
+	if *user {
+		journal = "journalctl --user -u " + *name
+	}
+	fmt.Printf("已启用并启动 %s，查看日志: %s\n", unit, journal)
+	return nil
+}
+
+// 停用并删除服务的定时器，没有安装定时器时什么也不做
+func removeTimer(dir, name string, user bool) {
+	path := filepath.Join(dir, name+".timer")
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	execCommand(systemctlCommand(user, "disable", "--now", name+".timer"))
+	if err := os.Remove(path); err == nil {
+		fmt.Printf("已删除 %s\n", path)
+	}
+}
+
+// uninstall-service 子命令：停用并删除 install-service 安装的服务和定时器
+func runUninstallService(args []string) error {
+	fs := flag.NewFlagSet("uninstall-service", flag.ExitOnError)
+	name := fs.String("name", defaultServiceName, "服务名")
+	user := fs.Bool("user", false, "卸载当前用户的systemd服务 (systemctl --user)")
+	fs.Parse(args)
+
+	if *name == "" || strings.ContainsAny(*name, "/ ") {
+		return fmt.Errorf("无效的服务名: %q", *name)
+	}
+	dir, err := systemdUnitDir(*user)
+	if err != nil {
+		return err
+	}
+	service := filepath.Join(dir, *name+".service")
+	if _, err := os.Stat(service); err != nil {
+		return fmt.Errorf("没有找到已安装的服务: %s", service)
+	}
+	if err := checkSystemd(*user); err != nil {
+		return err
+	}
+
+	removeTimer(dir, *name, *user)
+	// 服务可能已经停止或没有启用，失败时继续删除
+	execCommand(systemctlCommand(*user, "disable", "--now", *name+".service"))
+	if err := os.Remove(service); err != nil {
+		return fmt.Errorf("删除 %s 失败: %v", service, err)
+	}
+	fmt.Printf("已删除 %s\n", service)
+	if err := execCommand(systemctlCommand(*user, "daemon-reload")); err != nil {
+		return fmt.Errorf("重新加载systemd配置失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 请求头参数，可以重复指定
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("请求头格式应为 \"Name: Value\"")
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// share 子命令：上传脱敏后的检测结果并输出链接
+func runShare(args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "上传地址，默认读取环境变量 DRC_SHARE_ENDPOINT")
+	method := fs.String("method", "POST", "上传使用的请求方法 (POST/PUT)")
+	keepIP := fs.Bool("keep-ip", false, "保留公网IP地址")
+	printOnly := fs.Bool("print", false, "只输出脱敏后的内容，不上传")
+	yes := fs.Bool("y", false, "上传前不再确认")
+	answersPath := fs.String("answers", "", "从YAML应答文件读取上传前的确认 (share)")
+	var headers headerFlags
+	fs.Var(&headers, "header", "附加的请求头，如 \"Authorization: Bearer xxx\"，可重复指定")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker share [参数] <结果文件>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("请指定一个结果文件")
+	}
+	if err := useAnswersFile(*answersPath); err != nil {
+		return err
+	}
+
+	results, err := loadResults(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, redactResults(results, *keepIP)); err != nil {
+		return err
+	}
+
+	if *printOnly {
+		_, err := io.Copy(os.Stdout, &buf)
+		return err
+	}
+
+	// 上传地址可能带有签名或token，不作为参数的默认值，以免在 -h 中显示
+	if *endpoint == "" {
+		*endpoint = os.Getenv("DRC_SHARE_ENDPOINT")
+	}
+	if *endpoint == "" {
+		return fmt.Errorf("请通过 -endpoint 或环境变量 DRC_SHARE_ENDPOINT 指定上传地址")
+	}
+
+	if !*yes && !confirm("share", fmt.Sprintf("将上传 %d 条脱敏后的结果 (%d 字节) 到 %s，可以先用 -print 查看内容。是否继续? (y/n): ",
+		len(results), buf.Len(), *endpoint)) {
+		return fmt.Errorf("已取消")
+	}
+
+	link, err := uploadReport(*endpoint, strings.ToUpper(*method), headers, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	fmt.Println(link)
+	return nil
+}
+
+// 上传报告，返回可访问的链接
+func uploadReport(endpoint, method string, headers []string, body []byte) (string, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("无效的上传地址: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("上传失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("上传失败，状态码: %d", resp.StatusCode)
+	}
+
+	// 依次尝试: JSON中的链接字段、Location头、纯文本的URL
+	var parsed map[string]interface{}
+	if json.Unmarshal(respBody, &parsed) == nil {
+		for _, key := range []string{"url", "link", "html_url"} {
+			if value, ok := parsed[key].(string); ok && value != "" {
+				return value, nil
+			}
+		}
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		return location, nil
+	}
+	if text := strings.TrimSpace(string(respBody)); strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://") {
+		return text, nil
+	}
+	// 预签名URL的PUT上传通常不返回内容，上传地址去掉签名参数即为访问地址
+	if method == http.MethodPut {
+		if u, err := url.Parse(endpoint); err == nil {
+			u.RawQuery = ""
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("上传成功，但无法从响应中识别链接")
+}
+
+// 对结果脱敏:
+//   - 内网地址的镜像源 (私有IP或内部域名) 替换为 redacted-N，重定向地址中的这些主机同样替换
+//   - 去掉IP地址，包括逐IP检测结果中的地址 (除非keepIP且为公网IP)
+//   - 去掉重定向地址和列表URL中的查询参数，其中可能包含签名或token
+//   - 列表文件只保留文件名
+//   - 错误信息中的内网主机名和IP地址同样替换
+func redactResults(results []CheckResult, keepIP bool) []CheckResult {
+	redacted := make([]CheckResult, 0, len(results))
+	aliases := collectHostAliases(results)
+	scrub := aliases.textScrubber(keepIP)
+
+	for _, result := range results {
+		r := result
+		alias, internal := aliases.lookup(r.Host)
+		if internal {
+			r.Host = alias
+			r.IP = ""
+		}
+		if !keepIP {
+			r.IP = ""
+		}
+
+		// 内网镜像源的逐IP结果整个去掉，其余的只保留可用性和耗时
+		if internal {
+			r.IPs = nil
+		} else if len(r.IPs) > 0 {
+			ips := make([]IPResult, len(r.IPs))
+			for i, ip := range r.IPs {
+				if !keepIP || !isPublicIP(ip.IP) {
+					ip.IP = ""
+				}
+				ip.Error = scrub(ip.Error)
+				ips[i] = ip
+			}
+			r.IPs = ips
+		}
+
+		if len(r.Redirects) > 0 {
+			hops := make([]RedirectHop, len(r.Redirects))
+			for i, hop := range r.Redirects {
+				hop.From = aliases.redactURL(hop.From)
+				hop.To = aliases.redactURL(hop.To)
+				hops[i] = hop
+			}
+			r.Redirects = hops
+		}
+		if len(r.Sources) > 0 {
+			sources := make([]string, len(r.Sources))
+			for i, source := range r.Sources {
+				sources[i] = aliases.redactSource(source)
+			}
+			r.Sources = sources
+		}
+
+		// 错误信息中常带有主机名和IP，如 "dial tcp 10.0.0.5:443: connect: connection refused"
+		r.Error = scrub(r.Error)
+		if r.OCI != nil {
+			oci := *r.OCI
+			oci.Error = scrub(oci.Error)
+			r.OCI = &oci
+		}
+		if r.Integrity != nil {
+			integrity := *r.Integrity
+			integrity.Error = scrub(integrity.Error)
+			r.Integrity = &integrity
+		}
+		if len(r.Plugins) > 0 {
+			plugins := make([]PluginResult, len(r.Plugins))
+			for i, plugin := range r.Plugins {
+				plugin.Detail = scrub(plugin.Detail)
+				plugin.Error = scrub(plugin.Error)
+				plugins[i] = plugin
+			}
+			r.Plugins = plugins
+		}
+		redacted = append(redacted, r)
+	}
+	return redacted
+}
+
+// 需要隐藏的内网主机名 (小写，不含端口) 及其替代名 redacted-N
+type hostAliases map[string]string
+
+// 收集结果中的内网镜像源，以及重定向地址和列表URL中的内网主机，按出现的顺序编号
+func collectHostAliases(results []CheckResult) hostAliases {
+	aliases := hostAliases{}
+	add := func(host, ip string) {
+		hostname := aliasKey(host)
+		if _, ok := aliases[hostname]; !ok && hostname != "" && isInternalHost(host, ip) {
+			aliases[hostname] = fmt.Sprintf("redacted-%d", len(aliases)+1)
+		}
+	}
+	for _, result := range results {
+		add(result.Host, result.IP)
+		urls := append([]string(nil), result.Sources...)
+		for _, hop := range result.Redirects {
+			urls = append(urls, hop.From, hop.To)
+		}
+		for _, raw := range urls {
+			if u, err := url.Parse(raw); err == nil {
+				add(u.Host, "")
+			}
+		}
+	}
+	return aliases
+}
+
+func aliasKey(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// host (可以带端口) 的替代名，不需要隐藏时ok为false
+func (a hostAliases) lookup(host string) (string, bool) {
+	alias, ok := a[aliasKey(host)]
+	return alias, ok
+}
+
+// 去掉URL中的查询参数和用户信息，内网主机替换为替代名
+func (a hostAliases) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if alias, ok := a.lookup(u.Host); ok {
+		u.Host = alias
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+// 文本中可能是IP地址的部分: IPv4，以及含冒号的IPv6候选 (替换前用net.ParseIP确认)
+var textIPPattern = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b|[0-9A-Fa-f]*:[0-9A-Fa-f:.]*[0-9A-Fa-f]`)
+
+// 返回对错误信息等文本脱敏的函数: 内网主机名替换为替代名，IP地址替换为 redacted-ip (keepIP时保留公网IP)
+func (a hostAliases) textScrubber(keepIP bool) func(string) string {
+	hostnames := make([]string, 0, len(a))
+	for hostname := range a {
+		// IP形式的主机名由下面的正则替换，按字符串替换会误伤更长的IP
+		if net.ParseIP(hostname) == nil {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	// 较长的主机名优先，避免 a.corp 替换掉 mirror.a.corp 的一部分
+	sort.Slice(hostnames, func(i, j int) bool {
+		if len(hostnames[i]) != len(hostnames[j]) {
+			return len(hostnames[i]) > len(hostnames[j])
+		}
+		return hostnames[i] < hostnames[j]
+	})
+	pairs := make([]string, 0, 2*len(hostnames))
+	for _, hostname := range hostnames {
+		pairs = append(pairs, hostname, a[hostname])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	return func(text string) string {
+		if text == "" {
+			return text
+		}
+		text = replacer.Replace(text)
+		return textIPPattern.ReplaceAllStringFunc(text, func(match string) string {
+			if net.ParseIP(match) == nil || (keepIP && isPublicIP(match)) {
+				return match
+			}
+			return "redacted-ip"
+		})
+	}
+}
+
+// 列表来源只保留文件名，不暴露本地的目录结构；URL按重定向地址的规则处理
+func (a hostAliases) redactSource(source string) string {
+	if isListURL(source) {
+		return a.redactURL(source)
+	}
+	return filepath.Base(source)
+}
+
+// 判断是否是内网镜像源
+func isInternalHost(host, ip string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.ToLower(hostname)
+
+	if addr := net.ParseIP(hostname); addr != nil {
+		return isPrivateIP(addr)
+	}
+	if addr := net.ParseIP(ip); addr != nil && isPrivateIP(addr) {
+		return true
+	}
+	// 没有点的主机名和常见的内部域名后缀
+	if !strings.Contains(hostname, ".") {
+		return true
+	}
+	for _, suffix := range []string{".local", ".lan", ".internal", ".corp", ".intranet", ".home.arpa"} {
+		if strings.HasSuffix(hostname, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublicIP(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && !isPrivateIP(addr)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
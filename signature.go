@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// 下载的列表需要通过的签名校验，支持 minisign 和 cosign (sign-blob，使用密钥对) 的分离签名
+//
+// 签名文件与列表放在同一位置: minisign 为 <URL>.minisig，cosign 为 <URL>.sig。
+type listVerifier struct {
+	// minisign 公钥
+	minisign *minisignPublicKey
+	// cosign 公钥 (ECDSA)
+	cosign *ecdsa.PublicKey
+}
+
+type minisignPublicKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// 加载公钥，spec可以是公钥文件的路径或minisign公钥字符串 (如 RWQ...)
+//
+// PEM格式的公钥 (cosign.pub) 按cosign签名校验，其余按minisign公钥解析。
+func loadListVerifier(spec string) (*listVerifier, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	data := []byte(spec)
+	if fileExists(spec) {
+		var err error
+		if data, err = os.ReadFile(spec); err != nil {
+			return nil, fmt.Errorf("读取公钥失败: %v", err)
+		}
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析cosign公钥失败: %v", err)
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("只支持ECDSA格式的cosign公钥")
+		}
+		return &listVerifier{cosign: ecKey}, nil
+	}
+
+	key, err := parseMinisignPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &listVerifier{minisign: key}, nil
+}
+
+// 解析minisign公钥，公钥文件第一行为 untrusted comment，第二行为base64编码的公钥
+func parseMinisignPublicKey(data []byte) (*minisignPublicKey, error) {
+	var encoded string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			encoded = line
+			break
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, fmt.Errorf("无效的minisign公钥")
+	}
+	key := &minisignPublicKey{key: ed25519.PublicKey(raw[10:])}
+	copy(key.id[:], raw[2:10])
+	return key, nil
+}
+
+// 签名文件相对于列表地址的后缀
+func (v *listVerifier) signatureSuffix() string {
+	if v.cosign != nil {
+		return ".sig"
+	}
+	return ".minisig"
+}
+
+// 下载列表的签名并校验，url为列表的地址，返回下载的签名
+func (v *listVerifier) verifyURL(client *http.Client, url string, data []byte) ([]byte, error) {
+	sigURL := url + v.signatureSuffix()
+	resp, err := client.Get(sigURL)
+	if err != nil {
+		return nil, fmt.Errorf("下载签名失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载签名 %s 失败，状态码: %d", sigURL, resp.StatusCode)
+	}
+	// 签名文件很小，限制大小避免读取异常的响应
+	signature, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("下载签名失败: %v", err)
+	}
+	return signature, v.verify(data, signature)
+}
+
+// 本地列表的签名文件，与列表放在同一目录，如 docker.txt.minisig
+func (v *listVerifier) signaturePath(path string) string {
+	return path + v.signatureSuffix()
+}
+
+// 读取本地列表并用同一目录下保存的签名校验，没有签名文件或校验失败时返回错误
+//
+// 下载的列表在使用前可能被修改，每次读取都重新校验，而不是只在下载时校验一次。
+func (v *listVerifier) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开%s文件: %v", path, err)
+	}
+	signature, err := os.ReadFile(v.signaturePath(path))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s没有签名文件 %s", path, v.signaturePath(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取签名失败: %v", err)
+	}
+	if err := v.verify(data, signature); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+func (v *listVerifier) verify(data, signature []byte) error {
+	if v.cosign != nil {
+		return verifyCosign(v.cosign, data, signature)
+	}
+	return verifyMinisign(v.minisign, data, signature)
+}
+
+// 校验cosign sign-blob生成的签名: base64编码的ASN.1格式ECDSA签名，签名内容为SHA-256摘要
+func verifyCosign(key *ecdsa.PublicKey, data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("无效的cosign签名: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return fmt.Errorf("签名校验失败，列表可能被篡改")
+	}
+	return nil
+}
+
+// 校验minisign签名
+//
+// 签名文件共四行: untrusted comment、签名、trusted comment、对签名和trusted comment的全局签名。
+// 签名算法为 Ed 时直接对内容签名，为 ED (minisign 0.10之后的默认值) 时对内容的BLAKE2b-512摘要签名。
+func verifyMinisign(key *minisignPublicKey, data, signature []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("无效的minisign签名文件")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("无效的minisign签名")
+	}
+	algorithm, keyID, sig := string(raw[:2]), raw[2:10], raw[10:]
+	if !bytes.Equal(keyID, key.id[:]) {
+		return fmt.Errorf("签名使用的密钥 (%X) 与公钥 (%X) 不一致", reverseBytes(keyID), reverseBytes(key.id[:]))
+	}
+
+	message := data
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		digest := blake2b.Sum512(data)
+		message = digest[:]
+	default:
+		return fmt.Errorf("不支持的minisign签名算法: %q", algorithm)
+	}
+	if !ed25519.Verify(key.key, message, sig) {
+		return fmt.Errorf("签名校验失败，列表可能被篡改")
+	}
+
+	// trusted comment 同样受签名保护
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("无效的minisign全局签名")
+	}
+	if !ed25519.Verify(key.key, append(append([]byte{}, sig...), trustedComment...), globalSig) {
+		return fmt.Errorf("trusted comment 签名校验失败")
+	}
+	return nil
+}
+
+// minisign显示的密钥ID为小端序
+func reverseBytes(b []byte) []byte {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return reversed
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"os"
+	"testing"
+)
+
+// 在临时目录中运行，列表文件使用相对路径 (如 docker.txt)
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// 生成cosign格式的密钥对，返回校验器和签名函数
+func testCosignVerifier(t *testing.T) (*listVerifier, func([]byte) []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := loadListVerifier(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(base64.StdEncoding.EncodeToString(sig))
+	}
+	return verifier, sign
+}
+
+func TestLoadCategoryListVerifiesLocalFile(t *testing.T) {
+	chdirTemp(t)
+	previous := infoOut
+	infoOut = io.Discard
+	t.Cleanup(func() { infoOut = previous })
+
+	verifier, sign := testCosignVerifier(t)
+	category := listCategories[0]
+	list := []byte("mirror.example.com\n")
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(category.File, list)
+
+	// 没有指定公钥时不校验
+	if entries, err := loadCategoryList(category, listSourceOptions{}); err != nil || len(entries) != 1 {
+		t.Fatalf("没有公钥时: %v, %v", entries, err)
+	}
+	// 没有签名文件
+	opts := listSourceOptions{Verifier: verifier}
+	if _, err := loadCategoryList(category, opts); err == nil {
+		t.Error("没有签名文件时应拒绝使用本地列表")
+	}
+
+	write(verifier.signaturePath(category.File), sign(list))
+	if entries, err := loadCategoryList(category, opts); err != nil || len(entries) != 1 || entries[0].Host != "mirror.example.com" {
+		t.Fatalf("签名正确时: %v, %v", entries, err)
+	}
+
+	// 下载后被修改的列表
+	write(category.File, []byte("mirror.example.com\nevil.example.com\n"))
+	if _, err := loadCategoryList(category, opts); err == nil {
+		t.Error("签名校验失败时应拒绝使用本地列表")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 通过snap安装的Docker，dockerd读取snap目录下的daemon.json，/etc/docker/daemon.json 不会生效
+var snapDocker = dockerTarget{
+	Name:       "Docker (snap)",
+	ConfigPath: "/var/snap/docker/current/config/daemon.json",
+	Reload:     "kill -HUP $(pidof dockerd)",
+	Restart:    "snap restart docker",
+	NeedRoot:   true,
+}
+
+// 是否是通过snap安装的Docker
+func snapDockerInstalled() bool {
+	if _, err := os.Stat("/snap/bin/docker"); err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Dir(snapDocker.ConfigPath))
+	return err == nil && info.IsDir()
+}
+
+// 从正在运行的dockerd的命令行参数中找出 --config-file 指定的配置文件，没有指定时返回空
+//
+// 部分发行版或自定义的systemd unit会把daemon.json放在其他位置，
+// 此时修改 /etc/docker/daemon.json 不会生效。
+func runningDockerdConfigFile() string {
+	procs, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	for _, proc := range procs {
+		data, err := os.ReadFile(proc)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		args := strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+		if filepath.Base(args[0]) != "dockerd" {
+			continue
+		}
+		for i, arg := range args[1:] {
+			if value, ok := strings.CutPrefix(arg, "--config-file="); ok {
+				return value
+			}
+			if arg == "--config-file" && i+2 < len(args) {
+				return args[i+2]
+			}
+		}
+		return ""
+	}
+	return ""
+}
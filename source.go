@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// 单个列表来源的统计
+type sourceStats struct {
+	Source    string
+	Total     int
+	Available int
+	// 可用镜像源的响应时间之和，用于计算平均值
+	totalTime time.Duration
+	// 只出现在这个来源中的可用镜像源数量
+	Unique int
+}
+
+// 按列表条目为检测结果标记来源
+func attributeSources(results []CheckResult, entries []listEntry) {
+	sources := sourcesByHost(entries)
+	for i := range results {
+		results[i].Sources = sources[results[i].Host]
+	}
+}
+
+// 每个host所在的列表来源
+func sourcesByHost(entries []listEntry) map[string][]string {
+	sources := map[string][]string{}
+	for _, entry := range entries {
+		if !containsString(sources[entry.Host], entry.Source) {
+			sources[entry.Host] = append(sources[entry.Host], entry.Source)
+		}
+	}
+	return sources
+}
+
+// 按来源汇总检测结果，同一来源中重复的host只统计一次
+func collectSourceStats(results []CheckResult) []*sourceStats {
+	byName := map[string]*sourceStats{}
+	seen := map[string]bool{}
+	for _, result := range results {
+		for _, source := range result.Sources {
+			key := source + "\x00" + result.Host
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			stats := byName[source]
+			if stats == nil {
+				stats = &sourceStats{Source: source}
+				byName[source] = stats
+			}
+			stats.Total++
+			if isSuccess(result) {
+				stats.Available++
+				stats.totalTime += result.Time
+				if len(result.Sources) == 1 {
+					stats.Unique++
+				}
+			}
+		}
+	}
+
+	list := make([]*sourceStats, 0, len(byName))
+	for _, stats := range byName {
+		list = append(list, stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Source < list[j].Source
+	})
+	return list
+}
+
+// 输出各列表来源的可用率，只有一个来源时不输出
+func writeSourceStats(w io.Writer, results []CheckResult) {
+	stats := collectSourceStats(results)
+	if len(stats) < 2 {
+		return
+	}
+
+	fmt.Fprintln(w, "\n列表来源统计:")
+	fmt.Fprintln(w, "可用/总数    可用率    平均响应时间    独有可用    来源")
+	writeRule(w, "-", 75)
+	for _, s := range stats {
+		average := "-"
+		if s.Available > 0 {
+			average = fmt.Sprintf("%.2fs", (s.totalTime / time.Duration(s.Available)).Seconds())
+		}
+		fmt.Fprintf(w, "%-12s %-9s %-15s %-11d %s\n",
+			fmt.Sprintf("%d/%d", s.Available, s.Total),
+			fmt.Sprintf("%.0f%%", float64(s.Available)*100/float64(s.Total)),
+			average,
+			s.Unique,
+			s.Source,
+		)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
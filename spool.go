@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 默认检测的镜像源超过该数量时，结果写入临时文件而不是保存在内存中
+const defaultStreamThreshold = 50000
+
+// 检测结果的暂存区
+//
+// 结果数量不超过threshold时全部保存在内存中，与之前一样显示、排序和推荐；
+// 超过后所有结果按完成顺序写入临时文件 (每行一个JSON对象)，内存中只保留可用的镜像源用于推荐和配置，
+// 检测几十万个镜像源时内存占用只与可用的镜像源数量有关。
+type resultSpool struct {
+	threshold int
+	// 没有写入临时文件时为全部结果，写入后只有可用的结果
+	results []CheckResult
+	count   int
+	file    *os.File
+	writer  *bufio.Writer
+	err     error
+}
+
+// threshold不大于0时总是保存在内存中
+func newResultSpool(threshold int) *resultSpool {
+	return &resultSpool{threshold: threshold}
+}
+
+// 添加一个检测结果，写入临时文件失败时记录错误，之后的结果只保留可用的镜像源
+func (s *resultSpool) add(result CheckResult) {
+	s.count++
+	if s.file == nil && s.err == nil && s.threshold > 0 && s.count > s.threshold {
+		s.spill()
+	}
+	if s.file == nil && s.err == nil {
+		s.results = append(s.results, result)
+		return
+	}
+	s.write(result)
+	if isSuccess(result) {
+		s.results = append(s.results, result)
+	}
+}
+
+// 创建临时文件，把内存中已有的结果写进去，只保留其中可用的镜像源
+func (s *resultSpool) spill() {
+	file, err := os.CreateTemp("", "docker-registry-checker-*.jsonl")
+	if err != nil {
+		s.err = fmt.Errorf("创建临时文件失败: %v", err)
+		return
+	}
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	kept := s.results[:0]
+	for _, result := range s.results {
+		s.write(result)
+		if isSuccess(result) {
+			kept = append(kept, result)
+		}
+	}
+	// 释放不可用的结果占用的内存
+	s.results = append([]CheckResult(nil), kept...)
+}
+
+func (s *resultSpool) write(result CheckResult) {
+	if s.err != nil {
+		return
+	}
+	line, err := json.Marshal(result)
+	if err == nil {
+		_, err = s.writer.Write(append(line, '\n'))
+	}
+	if err != nil {
+		s.err = fmt.Errorf("写入临时文件失败: %v", err)
+	}
+}
+
+// 结果是否已写入临时文件 (或写入失败)，此时内存中只有可用的镜像源
+func (s *resultSpool) spilled() bool {
+	return s.file != nil || s.err != nil
+}
+
+// 按完成顺序遍历所有结果，结果写入临时文件时从文件中读取
+func (s *resultSpool) each(fn func(CheckResult) error) error {
+	if !s.spilled() {
+		for _, result := range s.results {
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("读取临时文件失败: %v", err)
+	}
+	// 读完后回到文件末尾，之后还可以继续写入
+	defer s.file.Seek(0, io.SeekEnd)
+
+	decoder := json.NewDecoder(bufio.NewReader(s.file))
+	for {
+		var result CheckResult
+		if err := decoder.Decode(&result); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("读取临时文件失败: %v", err)
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+}
+
+// 删除临时文件
+func (s *resultSpool) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
+
+// 按完成顺序逐个输出暂存的结果，不排序，onlySuccess为true时只输出可用的镜像源
+//
+// 格式与writeResults相同，但不需要把所有结果读入内存。
+func writeSpool(w io.Writer, spool *resultSpool, format string, onlySuccess bool) error {
+	each := func(fn func(CheckResult) error) error {
+		return spool.each(func(result CheckResult) error {
+			if onlySuccess && !isSuccess(result) {
+				return nil
+			}
+			return fn(result)
+		})
+	}
+
+	switch format {
+	case "json":
+		// 与writeJSON的输出结构相同
+		generatedAt, err := json.Marshal(time.Now())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "{\n    \"generated_at\": %s,\n    \"results\": [", generatedAt); err != nil {
+			return err
+		}
+		first := true
+		err = each(func(result CheckResult) error {
+			line, err := json.MarshalIndent(result, "        ", "    ")
+			if err != nil {
+				return err
+			}
+			sep := ",\n        "
+			if first {
+				sep = "\n        "
+				first = false
+			}
+			_, err = fmt.Fprintf(w, "%s%s", sep, line)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		closing := "\n    ]\n}\n"
+		if first {
+			closing = "]\n}\n"
+		}
+		_, err = io.WriteString(w, closing)
+		return err
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		err := each(func(result CheckResult) error {
+			return writer.Write(csvRecord(result))
+		})
+		if err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	case "yaml":
+		generatedAt, err := yaml.Marshal(map[string]time.Time{"generated_at": time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%sresults:", generatedAt); err != nil {
+			return err
+		}
+		empty := true
+		err = each(func(result CheckResult) error {
+			var buf bytes.Buffer
+			encoder := yaml.NewEncoder(&buf)
+			encoder.SetIndent(2)
+			if err := encoder.Encode([]CheckResult{result}); err != nil {
+				return err
+			}
+			if err := encoder.Close(); err != nil {
+				return err
+			}
+			if empty {
+				empty = false
+				if _, err := io.WriteString(w, "\n"); err != nil {
+					return err
+				}
+			}
+			// 单个元素的序列，缩进后依次拼接成results的值，与writeYAML的输出相同
+			lines := strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			for _, line := range lines {
+				if _, err := io.WriteString(w, "  "+line); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(w, "\n")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if empty {
+			_, err = io.WriteString(w, " []\n")
+		}
+		return err
+	default:
+		return fmt.Errorf("不支持的输出格式: %s", format)
+	}
+}
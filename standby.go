@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// 备用镜像源列表中最多保留的数量
+const standbyCount = 5
+
+// 备用镜像源列表的路径，与配置文件放在一起
+func standbyPath(target dockerTarget) string {
+	return target.ConfigPath + ".standby"
+}
+
+// 从检测结果中选出未写入配置的镜像源，按响应时间排序
+func standbyMirrors(results []CheckResult, configured []string) []CheckResult {
+	sorted := append([]CheckResult(nil), results...)
+	sortResults(sorted, "time")
+
+	var standby []CheckResult
+	for _, result := range sorted {
+		if containsString(configured, mirrorURL(result)) {
+			continue
+		}
+		standby = append(standby, result)
+		if len(standby) == standbyCount {
+			break
+		}
+	}
+	return standby
+}
+
+// 写入备用镜像源列表
+//
+// daemon.json不支持注释，这里单独写入一个列表文件，已配置的镜像源故障时可以直接从中挑选，
+// 不用重新完整检测一次。文件使用列表文件的格式，也可以在列表中通过 @include 引入后重新检测。
+func writeStandby(target dockerTarget, results []CheckResult, configured []string, checkedAt time.Time) (string, error) {
+	standby := standbyMirrors(results, configured)
+	if len(standby) == 0 {
+		return "", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "# docker-registry-checker 备用镜像源 (已验证可用，按响应时间排序)")
+	fmt.Fprintf(&b, "# 检测时间: %s\n", checkedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "# 当前配置: %s\n", strings.Join(configured, ", "))
+	fmt.Fprintf(&b, "# 已配置的镜像源故障时，可以先用 healthcheck -mirror <host> 确认备用镜像源仍然可用，再写入 %s\n", target.ConfigPath)
+	for _, result := range standby {
+		fmt.Fprintf(&b, "%s  # %.2fs %s\n", result.Host, result.Time.Seconds(), checkedAt.Format("2006-01-02 15:04"))
+	}
+
+	path := standbyPath(target)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("写入备用镜像源列表失败: %v", err)
+	}
+	return path, nil
+}
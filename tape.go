@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+)
+
+// 录制文件格式版本
+const tapeVersion = 1
+
+// 一次运行中的所有网络交互，用于录制和回放
+//
+// 录制时包装HTTP transport并记录每次请求的响应、耗时和证书，DNS查询和QUIC探测
+// 通过call记录结果；回放时按相同的key依次返回录制的内容，并等待录制时的耗时，
+// 使维护者无需访问用户的网络就能复现检测和评分结果。
+type tape struct {
+	Version int `json:"version"`
+	// 录制时的命令行参数，仅供参考
+	Args []string `json:"args"`
+	// 录制时检测的列表，回放时代替docker.txt
+	Entries      []listEntry    `json:"entries"`
+	Interactions []*interaction `json:"interactions"`
+
+	mu     sync.Mutex
+	replay bool
+	// 回放时每个key尚未使用的记录
+	pending map[string][]*interaction
+}
+
+// 一次网络交互
+type interaction struct {
+	// http 或 call
+	Kind     string        `json:"kind"`
+	Key      string        `json:"key"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+
+	StatusCode int         `json:"status_code,omitempty"`
+	Proto      string      `json:"proto,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	// 服务端证书链 (DER)
+	Certificates [][]byte `json:"certificates,omitempty"`
+	// 连接的远端地址、是否复用了连接以及首字节时间
+	RemoteAddr string        `json:"remote_addr,omitempty"`
+	Reused     bool          `json:"reused,omitempty"`
+	TTFB       time.Duration `json:"ttfb,omitempty"`
+
+	// call 的返回值
+	Value string `json:"value,omitempty"`
+}
+
+func newRecordTape(args []string) *tape {
+	return &tape{Version: tapeVersion, Args: args}
+}
+
+// 读取录制文件用于回放
+func loadTape(path string) (*tape, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取录制文件失败: %v", err)
+	}
+
+	t := &tape{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("解析录制文件失败: %v", err)
+	}
+	if t.Version != tapeVersion {
+		return nil, fmt.Errorf("不支持的录制文件版本: %d", t.Version)
+	}
+
+	t.replay = true
+	t.pending = map[string][]*interaction{}
+	for _, it := range t.Interactions {
+		t.pending[it.Key] = append(t.pending[it.Key], it)
+	}
+	return t, nil
+}
+
+func (t *tape) save(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("序列化录制内容失败: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入录制文件失败: %v", err)
+	}
+	return nil
+}
+
+func (t *tape) add(it *interaction) {
+	t.mu.Lock()
+	t.Interactions = append(t.Interactions, it)
+	t.mu.Unlock()
+}
+
+// 取出key对应的下一条记录
+func (t *tape) next(key string) (*interaction, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	queue := t.pending[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("录制文件中没有该请求: %s", key)
+	}
+	t.pending[key] = queue[1:]
+	return queue[0], nil
+}
+
+// 包装HTTP transport，scope用于区分直连不同IP的请求；t为nil时原样返回
+func (t *tape) wrap(next http.RoundTripper, scope string) http.RoundTripper {
+	if t == nil {
+		return next
+	}
+	return &tapeTransport{tape: t, scope: scope, next: next}
+}
+
+// 执行并记录一次非HTTP的网络操作 (如DNS查询)，回放时直接返回录制的结果；t为nil时直接执行
+func (t *tape) call(key string, fn func() (string, error)) (string, error) {
+	if t == nil {
+		return fn()
+	}
+
+	if t.replay {
+		it, err := t.next(key)
+		if err != nil {
+			return "", err
+		}
+		time.Sleep(it.Duration)
+		if it.Error != "" {
+			return it.Value, errors.New(it.Error)
+		}
+		return it.Value, nil
+	}
+
+	start := time.Now()
+	value, err := fn()
+	it := &interaction{Kind: "call", Key: key, Duration: time.Since(start), Value: value}
+	if err != nil {
+		it.Error = err.Error()
+	}
+	t.add(it)
+	return value, err
+}
+
+type tapeTransport struct {
+	tape  *tape
+	scope string
+	next  http.RoundTripper
+}
+
+func (tt *tapeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.String()
+	if tt.scope != "" {
+		key = tt.scope + " " + key
+	}
+	if tt.tape.replay {
+		return tt.replayHTTP(req, key)
+	}
+
+	it := &interaction{Kind: "http", Key: key}
+	start := time.Now()
+	// 在原有的trace之外记录连接信息和首字节时间
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			it.RemoteAddr = info.Conn.RemoteAddr().String()
+			it.Reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			it.TTFB = time.Since(start)
+		},
+	}))
+	resp, err := tt.next.RoundTrip(req)
+	if err != nil {
+		it.Duration = time.Since(start)
+		it.Error = err.Error()
+		tt.tape.add(it)
+		return nil, err
+	}
+
+	// 读出响应体以便记录，再替换为内存中的副本
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	it.Duration = time.Since(start)
+	it.StatusCode = resp.StatusCode
+	it.Proto = resp.Proto
+	it.Header = resp.Header
+	it.Body = body
+	if err != nil {
+		it.Error = err.Error()
+	}
+	if resp.TLS != nil {
+		for _, cert := range resp.TLS.PeerCertificates {
+			it.Certificates = append(it.Certificates, cert.Raw)
+		}
+	}
+	tt.tape.add(it)
+	return resp, nil
+}
+
+func (tt *tapeTransport) replayHTTP(req *http.Request, key string) (*http.Response, error) {
+	it, err := tt.tape.next(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按录制时的耗时等待，并触发和真实请求相同的trace回调
+	trace := httptrace.ContextClientTrace(req.Context())
+	if !sleepContext(req.Context(), it.TTFB) {
+		return nil, req.Context().Err()
+	}
+	if trace != nil && trace.GotConn != nil && it.RemoteAddr != "" {
+		if addr, err := net.ResolveTCPAddr("tcp", it.RemoteAddr); err == nil {
+			trace.GotConn(httptrace.GotConnInfo{Conn: tapeConn{addr: addr}, Reused: it.Reused})
+		}
+	}
+	if trace != nil && trace.GotFirstResponseByte != nil && it.TTFB > 0 {
+		trace.GotFirstResponseByte()
+	}
+	if !sleepContext(req.Context(), it.Duration-it.TTFB) {
+		return nil, req.Context().Err()
+	}
+
+	if it.StatusCode == 0 {
+		return nil, errors.New(it.Error)
+	}
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.StatusCode, http.StatusText(it.StatusCode)),
+		StatusCode:    it.StatusCode,
+		Proto:         it.Proto,
+		Header:        it.Header,
+		Body:          io.NopCloser(bytes.NewReader(it.Body)),
+		ContentLength: int64(len(it.Body)),
+		Request:       req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if len(it.Certificates) > 0 {
+		state := &tls.ConnectionState{}
+		for _, der := range it.Certificates {
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				state.PeerCertificates = append(state.PeerCertificates, cert)
+			}
+		}
+		resp.TLS = state
+	}
+	return resp, nil
+}
+
+// 回放时传给trace回调的连接，只提供远端地址
+type tapeConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c tapeConn) RemoteAddr() net.Addr { return c.addr }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// 镜像源配置写入的目标: daemon.json 的位置以及让配置生效的命令
+type dockerTarget struct {
+	// 显示名称，如 "Docker" 或 "rootless Docker"
+	Name string
+	// daemon.json 的路径
+	ConfigPath string
+	// 配置文件的格式，空为daemon.json格式
+	Format string
+	// 用于检查是否已安装的命令，空为docker
+	Binary string
+	// 修改配置后执行的命令，没有systemd时DaemonReload为空，
+	// 不支持热加载时Reload与Restart相同
+	DaemonReload string
+	Reload       string
+	Restart      string
+	// 无法重启服务 (如Docker-in-Docker)，Restart为发送SIGHUP重新加载配置
+	ReloadOnly bool
+	// 写入配置是否需要root权限
+	NeedRoot bool
+	// 写入后输出的提示
+	Note string
+}
+
+// 配置文件格式
+const (
+	configDaemonJSON = ""
+	// Colima 的 colima.yaml，daemon配置位于 docker 字段中
+	configColima = "colima"
+	// containerd 的 hosts.toml
+	configHostsTOML = "hosts.toml"
+	// K3s/RKE2 的 registries.yaml
+	configRegistriesYAML = "registries.yaml"
+	// BuildKit 的 buildkitd.toml
+	configBuildkitTOML = "buildkitd.toml"
+)
+
+// 以root运行的系统级Docker
+var systemDocker = dockerTarget{
+	Name:         "Docker",
+	ConfigPath:   daemonConfigPath,
+	DaemonReload: "systemctl daemon-reload",
+	Reload:       "systemctl reload docker",
+	Restart:      "systemctl restart docker",
+	NeedRoot:     true,
+}
+
+// rootless模式的Docker，由当前用户的systemd管理，配置位于 ~/.config/docker/daemon.json
+func rootlessDocker() dockerTarget {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		configDir = filepath.Join(home, ".config")
+	}
+	return dockerTarget{
+		Name:         "rootless Docker",
+		ConfigPath:   filepath.Join(configDir, "docker", "daemon.json"),
+		DaemonReload: "systemctl --user daemon-reload",
+		Reload:       "systemctl --user reload docker",
+		Restart:      "systemctl --user restart docker",
+	}
+}
+
+// 判断当前使用的Docker: Linux上为系统级或rootless Docker，macOS上为 Docker Desktop、Colima 或 OrbStack
+//
+// Linux上只检查环境变量、socket文件和进程参数，不执行docker命令，healthcheck 这类频繁调用的场景也可以使用。
+// 以root运行时总是使用系统级Docker。没有systemd时按init系统调整命令。
+// WSL2中使用Docker Desktop集成时，配置位于Windows一侧。
+func detectDockerTarget() dockerTarget {
+	if runtime.GOOS == "darwin" {
+		return detectMacTarget()
+	}
+	if runtime.GOOS == "linux" && inWSL() && dockerDesktopWSLIntegration() {
+		return dockerDesktopWSLTarget()
+	}
+	// Windows上Geteuid返回-1
+	if os.Geteuid() > 0 {
+		for _, socket := range rootlessSockets() {
+			if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+				return rootlessDocker().forInitSystem(rootlessPidFile())
+			}
+		}
+	}
+	return detectSystemDocker()
+}
+
+// 系统级Docker: snap安装的Docker，或dockerd通过 --config-file 使用了其他位置的配置
+func detectSystemDocker() dockerTarget {
+	if runtime.GOOS != "linux" {
+		return systemDocker
+	}
+	if snapDockerInstalled() {
+		return snapDocker
+	}
+	target := systemDocker.forInitSystem("/var/run/docker.pid")
+	if path := runningDockerdConfigFile(); path != "" && path != target.ConfigPath {
+		target.ConfigPath = path
+		target.Note = "dockerd通过 --config-file 使用 " + path + "，已写入该文件"
+	}
+	return target
+}
+
+// rootless Docker 可能使用的socket路径
+func rootlessSockets() []string {
+	var sockets []string
+	// DOCKER_HOST=unix:///run/user/1000/docker.sock
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if u, err := url.Parse(host); err == nil && u.Scheme == "unix" && strings.HasPrefix(u.Path, "/run/user/") {
+			sockets = append(sockets, u.Path)
+		}
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sockets = append(sockets, filepath.Join(runtimeDir, "docker.sock"))
+	}
+	return sockets
+}
+
+// 提示中显示的系统名称
+func systemName(t dockerTarget) string {
+	if runtime.GOOS == "linux" && t.NeedRoot {
+		return "Linux系统"
+	}
+	return t.Name
+}
+
+// 是否支持自动写入配置
+func canApply() bool {
+	return runtime.GOOS == "linux" || runtime.GOOS == "darwin"
+}
+
+// 按 -runtime 参数选择写入配置的目标
+func selectTarget(name string) (dockerTarget, error) {
+	switch name {
+	case "", "docker":
+		return detectDockerTarget(), nil
+	case "containerd":
+		return containerdTarget, nil
+	case "k3s", "rke2":
+		return rancherTarget(name), nil
+	case "buildkit":
+		return buildkitTarget, nil
+	case "buildx":
+		return buildxTarget(), nil
+	default:
+		return dockerTarget{}, fmt.Errorf("不支持的运行时: %s (支持 docker/containerd/k3s/rke2/buildkit/buildx)", name)
+	}
+}
+
+// 是否是dockerd的配置，只有dockerd可以通过 docker pull 验证
+func (t dockerTarget) isDockerd() bool {
+	return t.Format == configDaemonJSON || t.Format == configColima
+}
+
+// 检查是否已安装，dockerd正在运行时通过Engine API即可确认
+func (t dockerTarget) installed() bool {
+	if t.Binary == "" && enginePing(context.Background()) == nil {
+		return true
+	}
+	binary := t.Binary
+	if binary == "" {
+		binary = "docker"
+	}
+	return exec.Command(binary, "--version").Run() == nil
+}
+
+// 读取当前配置
+func (t dockerTarget) readConfig() (*DaemonConfig, error) {
+	switch t.Format {
+	case configColima:
+		return readColimaConfig(t.ConfigPath)
+	case configHostsTOML:
+		return readHostsTOML(t.ConfigPath)
+	case configRegistriesYAML:
+		return readRegistriesYAML(t.ConfigPath)
+	case configBuildkitTOML:
+		return readBuildkitTOML(t.ConfigPath)
+	}
+	return readDaemonConfigFile(t.ConfigPath)
+}
+
+// 生成写入配置文件的内容
+func (t dockerTarget) renderConfig(config *DaemonConfig) ([]byte, error) {
+	switch t.Format {
+	case configColima:
+		return renderColimaConfig(t.ConfigPath, config)
+	case configHostsTOML:
+		return renderHostsTOMLFile(t.ConfigPath, config)
+	case configRegistriesYAML:
+		return renderRegistriesYAML(t.ConfigPath, config)
+	case configBuildkitTOML:
+		return renderBuildkitTOML(t.ConfigPath, config)
+	}
+	return marshalDaemonConfig(config)
+}
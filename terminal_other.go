@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// 其他系统无法获取终端大小，视为不是终端
+func terminalSize(f *os.File) (cols, rows int, ok bool) {
+	return 0, 0, false
+}
+
+func enableVirtualTerminal(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 终端的宽度和高度 (列数和行数)，f不是终端时返回false
+func terminalSize(f *os.File) (cols, rows int, ok bool) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
+
+// 类Unix系统的终端都支持ANSI控制序列
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
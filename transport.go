@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 共享的DNS查询的超时时间，与单个请求的超时无关，避免某个请求被取消时其他等待同一结果的请求也失败
+const dnsLookupTimeout = 10 * time.Second
+
+// 进程内的DNS缓存，同一轮检测中每个域名只查询一次
+//
+// 重试、OCI探测、地址族检测等对同一域名的多次查询直接使用缓存的结果，
+// 同时查询同一域名时只发出一次请求。解析失败的结果同样缓存到本轮检测结束。
+// nil表示不缓存，直接使用系统的解析器。
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	// 查询完成时关闭
+	ready chan struct{}
+	addrs []net.IPAddr
+	err   error
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{entries: map[string]*dnsEntry{}}
+}
+
+// 查询域名的IP地址
+func (c *dnsCache) lookupIPAddr(ctx context.Context, hostname string) ([]net.IPAddr, error) {
+	if c == nil {
+		return net.DefaultResolver.LookupIPAddr(ctx, hostname)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[hostname]
+	if !ok {
+		entry = &dnsEntry{ready: make(chan struct{})}
+		c.entries[hostname] = entry
+		go func() {
+			lookupCtx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+			defer cancel()
+			entry.addrs, entry.err = net.DefaultResolver.LookupIPAddr(lookupCtx, hostname)
+			close(entry.ready)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.addrs, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 使用缓存的解析结果建立连接，依次尝试每个IP直到连接成功
+func (c *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, err := c.lookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%s 没有解析到IP地址", host)
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// 所有worker共用的HTTP transport，复用连接并使用DNS缓存
+//
+// 每个worker各自建立transport时，连接池和socket的数量随并发数增长，
+// 同一镜像源的重试和后续探测也可能落在不同的连接池上，使响应时间不稳定。
+//
+// bandwidth不为nil时连接受 -max-bandwidth 限速，只用于深度检测。
+func newCheckTransport(opts checkOptions, bandwidth *bandwidthLimiter) *http.Transport {
+	connect, tlsTimeout, response := opts.phaseTimeouts()
+	dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		DialContext: opts.limitDial(opts.DNS.dialContext(dialer), bandwidth),
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: opts.sessionCache(warmupSessionCacheSize),
+		},
+		TLSHandshakeTimeout:   tlsTimeout,
+		ResponseHeaderTimeout: response,
+		// 自定义TLS配置和DialContext时需要显式开启HTTP/2
+		ForceAttemptHTTP2: true,
+		// 同一镜像源的请求是依次发出的，每个host保留少量空闲连接即可
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// 预热时TLS会话缓存的容量，预热后紧接着就是计时的探测，只需容纳同时检测的镜像源
+const warmupSessionCacheSize = 256
+
+// 开启预热时使用的TLS会话缓存，计时的探测恢复预热时建立的会话；不预热时返回nil，每次都完整握手
+func (o checkOptions) sessionCache(capacity int) tls.ClientSessionCache {
+	if !o.Warmup {
+		return nil
+	}
+	return tls.NewLRUClientSessionCache(capacity)
+}
+
+// 超时发生的阶段
+const (
+	timeoutConnect  = "connect"
+	timeoutTLS      = "tls"
+	timeoutResponse = "response"
+)
+
+// 建立连接、TLS握手和等待响应头各自的超时时间，没有单独指定时使用Timeout
+func (o checkOptions) phaseTimeouts() (connect, tls, response time.Duration) {
+	connect, tls, response = o.ConnectTimeout, o.TLSTimeout, o.ResponseTimeout
+	if connect <= 0 {
+		connect = o.Timeout
+	}
+	if tls <= 0 {
+		tls = o.Timeout
+	}
+	if response <= 0 {
+		response = o.Timeout
+	}
+	return connect, tls, response
+}
+
+// 整个请求 (包括重定向和读取响应体) 的超时时间
+//
+// 单独指定了各阶段的超时时间时，不能小于它们的和，否则响应较慢但可用的镜像源仍会被Timeout判为超时。
+func (o checkOptions) clientTimeout() time.Duration {
+	total := o.ConnectTimeout + o.TLSTimeout + o.ResponseTimeout
+	if total > o.Timeout {
+		return total
+	}
+	return o.Timeout
+}
+
+// 通过httptrace记录请求进行到的阶段，请求超时时据此判断是哪个阶段超时
+type phaseTracker struct {
+	mu    sync.Mutex
+	phase string
+}
+
+func (p *phaseTracker) set(phase string) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+}
+
+func (p *phaseTracker) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+// 超时阶段的显示名称
+func timeoutLabel(phase string) string {
+	switch phase {
+	case timeoutConnect:
+		return "连接超时"
+	case timeoutTLS:
+		return "TLS超时"
+	case timeoutResponse:
+		return "响应超时"
+	}
+	return "超时"
+}
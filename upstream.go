@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 默认上游，未标注 upstream= 的镜像源视为Docker Hub的镜像
+const defaultUpstream = "docker.io"
+
+// 各上游用于验证镜像源的公开镜像
+//
+// 只请求 /v2/ 无法区分镜像源代理的是哪个上游，非Docker Hub的镜像源
+// 需要能拉取到对应上游的清单才视为可用。
+var upstreamProbeImages = map[string]string{
+	"docker.io":       "",
+	"gcr.io":          "distroless/static:latest",
+	"registry.k8s.io": "pause:3.9",
+	"ghcr.io":         "linuxserver/nginx:latest",
+	"quay.io":         "prometheus/busybox:latest",
+}
+
+// 上游名称的别名
+var upstreamAliases = map[string]string{
+	"":                     defaultUpstream,
+	"hub.docker.com":       defaultUpstream,
+	"registry-1.docker.io": defaultUpstream,
+	"k8s.gcr.io":           "registry.k8s.io",
+}
+
+// 规范化上游名称，不支持的上游返回错误
+func normalizeUpstream(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := upstreamAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := upstreamProbeImages[name]; !ok {
+		return "", fmt.Errorf("不支持的上游: %q (支持 docker.io / gcr.io / k8s.gcr.io / registry.k8s.io / ghcr.io / quay.io)", name)
+	}
+	return name, nil
+}
+
+// 通过镜像源拉取上游镜像的清单，验证镜像源确实代理了该上游，base为镜像源地址
+func probeUpstream(ctx context.Context, client *http.Client, base, image string) error {
+	repo, ref := splitImageRef(image)
+	accept := strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerV2}, ", ")
+	resp, err := registryGet(ctx, client, base+"/v2/"+repo+"/manifests/"+ref, accept)
+	if err != nil {
+		return fmt.Errorf("获取上游镜像 %s 失败: %v", image, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("获取上游镜像 %s 失败，状态码: %d", image, resp.StatusCode)
+	}
+	return nil
+}
+
+// 筛选出Docker Hub的镜像源，daemon.json中的registry-mirrors只对Docker Hub生效
+func filterDockerHub(results []CheckResult) []CheckResult {
+	var filtered []CheckResult
+	for _, result := range results {
+		if result.Upstream == "" || result.Upstream == defaultUpstream {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
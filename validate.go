@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// validate-config 的退出码
+const (
+	validateOK      = 0
+	validateInvalid = 1
+	validateError   = 2
+)
+
+// daemon.json 中发现的一个问题
+type configProblem struct {
+	// 为true时是警告，不影响Docker启动
+	Warning bool
+	Msg     string
+}
+
+// validate-config 子命令：检查daemon.json的语法、各配置项的类型和镜像源相关配置，可以在CI中检查模板生成的文件
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "有警告时也以非0状态码退出")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker validate-config [参数] <daemon.json>...")
+		fmt.Fprintln(fs.Output(), "退出码: 0 通过, 1 有错误 (或 -strict 时有警告), 2 无法读取文件")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return validateError
+	}
+
+	code := validateOK
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			code = validateError
+			continue
+		}
+
+		problems := validateDaemonJSON(data)
+		for _, problem := range problems {
+			level := "错误"
+			if problem.Warning {
+				level = "警告"
+			}
+			fmt.Printf("%s: %s: %s\n", path, level, problem.Msg)
+			if (!problem.Warning || *strict) && code == validateOK {
+				code = validateInvalid
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	return code
+}
+
+// 检查daemon.json的内容
+func validateDaemonJSON(data []byte) []configProblem {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return []configProblem{{Msg: jsonErrorPosition(data, err)}}
+	}
+
+	// 各配置项的类型和未知的配置项按schema检查
+	problems := validateDaemonSchema(data)
+	addf := func(warning bool, format string, a ...interface{}) {
+		problems = append(problems, configProblem{Warning: warning, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	// 类型不正确时schema已经报告，这里只检查内容
+	var mirrors, insecure []string
+	json.Unmarshal(fields["registry-mirrors"], &mirrors)
+	json.Unmarshal(fields["insecure-registries"], &insecure)
+
+	seen := map[string]bool{}
+	for _, mirror := range mirrors {
+		u, err := url.Parse(mirror)
+		switch {
+		case err != nil:
+			addf(false, "registry-mirrors 中的地址无效: %q", mirror)
+			continue
+		case u.Scheme != "http" && u.Scheme != "https":
+			addf(false, "registry-mirrors 中的地址需要以 https:// 开头: %q", mirror)
+			continue
+		case u.Host == "":
+			addf(false, "registry-mirrors 中的地址缺少主机名: %q", mirror)
+			continue
+		case u.User != nil:
+			addf(false, "registry-mirrors 中的地址不能包含用户名或密码，请使用 docker login: %q", mirror)
+		case u.Path != "" && u.Path != "/", u.RawQuery != "", u.Fragment != "":
+			addf(false, "registry-mirrors 中的地址不能包含路径或参数: %q", mirror)
+		case u.Path == "/":
+			addf(true, "registry-mirrors 中的地址结尾有多余的 /: %q", mirror)
+		}
+
+		if u.Scheme == "http" && !containsString(insecure, u.Host) && !insecureCIDRContains(insecure, u.Hostname()) {
+			addf(false, "HTTP镜像源 %s 没有加入 insecure-registries，Docker会拒绝通过HTTP访问", u.Host)
+		}
+		if seen[u.Host] {
+			addf(true, "registry-mirrors 中有重复的镜像源: %s", u.Host)
+		}
+		seen[u.Host] = true
+	}
+
+	for _, registry := range insecure {
+		switch {
+		case strings.Contains(registry, "://"):
+			addf(false, "insecure-registries 中的条目不能带协议: %q，应写为 %q", registry, mirrorHost(registry))
+		case strings.Contains(registry, "/"):
+			if _, _, err := net.ParseCIDR(registry); err != nil {
+				addf(false, "insecure-registries 中的条目应为 host[:port] 或CIDR: %q", registry)
+			}
+		case registry == "":
+			addf(false, "insecure-registries 中有空字符串")
+		}
+	}
+	return problems
+}
+
+// insecure-registries 中的CIDR是否包含该IP
+func insecureCIDRContains(insecure []string, host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, registry := range insecure {
+		if _, network, err := net.ParseCIDR(registry); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 把JSON解析错误转换为带行号和列号的说明
+func jsonErrorPosition(data []byte, err error) string {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		if typeErr.Field == "" {
+			return "daemon.json 的最外层应为JSON对象"
+		}
+	default:
+		return fmt.Sprintf("JSON格式错误: %v", err)
+	}
+
+	line, column := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Sprintf("第%d行第%d列: JSON格式错误: %v", line, column, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// 拉取验证的最长时间
+const verifyPullTimeout = 5 * time.Minute
+
+// 配置生效后通过本机docker实际拉取一个镜像，验证镜像源能被daemon使用
+//
+// 本地已有同名镜像时不会删除 (可能正被容器使用)，docker pull 仍会通过镜像源获取镜像清单；
+// 本地原来没有的镜像在验证后删除，不在本机留下多余的镜像。
+// 注意docker在镜像源失败时会自动回退到Docker Hub，因此还会检查
+// daemon是否已经加载了新的镜像源配置。
+func verifyPull(image string, mirrors []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyPullTimeout)
+	defer cancel()
+
+	loaded, err := loadedMirrors(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mirror := range mirrors {
+		if !containsString(loaded, strings.TrimSuffix(mirror, "/")+"/") && !containsString(loaded, mirror) {
+			return fmt.Errorf("Docker daemon尚未加载镜像源 %s，请确认Docker服务已重启", mirror)
+		}
+	}
+
+	existed := exec.CommandContext(ctx, "docker", "image", "inspect", image).Run() == nil
+	if existed {
+		fmt.Printf("本地已有镜像 %s，保留该镜像，只通过镜像源检查更新\n", image)
+	}
+
+	fmt.Printf("正在拉取 %s ...\n", image)
+	cmd := exec.CommandContext(ctx, "docker", "pull", image)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("拉取镜像失败: %v", err)
+	}
+	fmt.Printf("拉取验证成功，耗时 %.2fs\n", time.Since(start).Seconds())
+	if !existed {
+		// 清理失败不影响验证结果
+		if out, err := exec.CommandContext(ctx, "docker", "image", "rm", image).CombinedOutput(); err != nil {
+			fmt.Printf("删除验证时拉取的镜像失败: %v: %s\n", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// 查询Docker daemon当前使用的镜像源，优先通过Engine API读取，无法访问socket时使用docker info
+func loadedMirrors(ctx context.Context) ([]string, error) {
+	if info, err := dockerdInfo(ctx); err == nil {
+		return info.RegistryConfig.Mirrors, nil
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .RegistryConfig.Mirrors}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("查询Docker配置失败: %v", err)
+	}
+
+	var mirrors []string
+	if err := json.Unmarshal(out, &mirrors); err != nil {
+		return nil, fmt.Errorf("解析Docker配置失败: %v", err)
+	}
+	return mirrors, nil
+}
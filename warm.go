@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 同时拉取的镜像数量
+const warmConcurrency = 3
+
+// 解析 -warm-cache 参数: 逗号分隔的镜像，或 @文件 (每行一个镜像，忽略空行和#开头的注释)
+func parseWarmImages(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(spec, "@") {
+		var images []string
+		for _, image := range strings.Split(spec, ",") {
+			if image = strings.TrimSpace(image); image != "" {
+				images = append(images, image)
+			}
+		}
+		return images, nil
+	}
+
+	data, err := os.ReadFile(spec[1:])
+	if err != nil {
+		return nil, fmt.Errorf("读取预热镜像列表失败: %v", err)
+	}
+	var images []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			images = append(images, line)
+		}
+	}
+	return images, scanner.Err()
+}
+
+// 配置生效后通过新的镜像源预先拉取常用镜像，让镜像源缓存这些镜像，
+// 之后第一次真正部署时不用等待镜像源回源
+//
+// 预热失败不影响配置结果，只输出每个镜像的结果。
+func warmCache(images []string) {
+	fmt.Printf("\n正在预热镜像源缓存 (%d 个镜像，同时拉取 %d 个)...\n", len(images), warmConcurrency)
+
+	var (
+		mu     sync.Mutex
+		done   int
+		failed []string
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, warmConcurrency)
+	for _, image := range images {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(image string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			err := pullImage(image)
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				failed = append(failed, image)
+				fmt.Printf("[%d/%d] %s 拉取失败: %v\n", done, len(images), image, err)
+				return
+			}
+			fmt.Printf("[%d/%d] %s 完成，耗时 %.1fs\n", done, len(images), image, time.Since(start).Seconds())
+		}(image)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		fmt.Printf("预热完成，%d 个镜像拉取失败: %s\n", len(failed), strings.Join(failed, ", "))
+		return
+	}
+	fmt.Println("预热完成")
+}
+
+// 通过本机docker拉取镜像，不输出拉取进度
+func pullImage(image string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyPullTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "pull", "-q", image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watch 默认保留的最近检测轮数
+const defaultWatchWindow = 10
+
+// 最近几轮的可用率不低于该比例的镜像源视为稳定，重新配置时优先选择
+const watchStableAvailability = 0.9
+
+// 一个镜像源在一轮检测中的结果
+type watchSample struct {
+	Time    time.Time     `json:"time"`
+	OK      bool          `json:"ok"`
+	Latency time.Duration `json:"latency"`
+}
+
+// 一个镜像源最近几轮检测的结果，按时间顺序
+type mirrorStats struct {
+	Host    string
+	Samples []watchSample
+}
+
+// 记录一轮的结果，只保留最近window轮
+func (s *mirrorStats) add(sample watchSample, window int) {
+	s.Samples = append(s.Samples, sample)
+	if len(s.Samples) > window {
+		s.Samples = s.Samples[len(s.Samples)-window:]
+	}
+}
+
+// 最近几轮中可用的比例
+func (s *mirrorStats) availability() float64 {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	ok := 0
+	for _, sample := range s.Samples {
+		if sample.OK {
+			ok++
+		}
+	}
+	return float64(ok) / float64(len(s.Samples))
+}
+
+// 最近几轮中可用时响应时间的中位数，一直不可用时为0
+func (s *mirrorStats) medianLatency() time.Duration {
+	var latencies []time.Duration
+	for _, sample := range s.Samples {
+		if sample.OK {
+			latencies = append(latencies, sample.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)/2]
+}
+
+// 截至最近一轮连续不可用的轮数
+func (s *mirrorStats) failStreak() int {
+	streak := 0
+	for i := len(s.Samples) - 1; i >= 0 && !s.Samples[i].OK; i-- {
+		streak++
+	}
+	return streak
+}
+
+// watch 的滚动状态: 每个镜像源最近window轮的可用性和响应时间
+type watchState struct {
+	Window  int
+	Rounds  int
+	LastRun time.Time
+	Mirrors map[string]*mirrorStats
+	// 已判定为劣化的已配置镜像源及原因，恢复后删除
+	Degraded map[string]string
+}
+
+func newWatchState(window int) *watchState {
+	return &watchState{Window: window, Mirrors: map[string]*mirrorStats{}, Degraded: map[string]string{}}
+}
+
+// 记录一轮检测的结果，不再出现在列表中的镜像源不再保留
+func (s *watchState) record(results []CheckResult, at time.Time) {
+	seen := map[string]bool{}
+	for _, result := range results {
+		seen[result.Host] = true
+		stats, ok := s.Mirrors[result.Host]
+		if !ok {
+			stats = &mirrorStats{Host: result.Host}
+			s.Mirrors[result.Host] = stats
+		}
+		stats.add(watchSample{Time: at, OK: isSuccess(result), Latency: result.Time}, s.Window)
+	}
+	for host := range s.Mirrors {
+		if !seen[host] {
+			delete(s.Mirrors, host)
+		}
+	}
+	s.Rounds++
+	s.LastRun = at
+}
+
+// 本轮可用的镜像源中最近几轮稳定可用的部分，没有稳定的镜像源时返回本轮所有可用的镜像源
+//
+// 重新配置时不希望换上一个刚刚恢复、随时可能再次故障的镜像源。
+func (s *watchState) stable(results []CheckResult) []CheckResult {
+	success := filterSuccess(results)
+	var stable []CheckResult
+	for _, result := range success {
+		if stats := s.Mirrors[result.Host]; stats != nil && stats.availability() >= watchStableAvailability {
+			stable = append(stable, result)
+		}
+	}
+	if len(stable) == 0 {
+		return success
+	}
+	return stable
+}
+
+// 判定已配置镜像源劣化的条件
+type watchPolicy struct {
+	// 连续不可用达到该轮数视为劣化
+	DegradeAfter int
+	// 响应时间的中位数超过该值视为劣化，0表示不按响应时间判断
+	MaxLatency time.Duration
+}
+
+// 镜像源劣化的原因，没有劣化时返回空字符串
+func (p watchPolicy) degradation(stats *mirrorStats) string {
+	if stats == nil {
+		return ""
+	}
+	if streak := stats.failStreak(); streak >= p.DegradeAfter {
+		return fmt.Sprintf("连续 %d 轮不可用", streak)
+	}
+	if latency := stats.medianLatency(); p.MaxLatency > 0 && latency > p.MaxLatency {
+		return fmt.Sprintf("响应时间中位数 %.2fs 超过 %s", latency.Seconds(), p.MaxLatency)
+	}
+	return ""
+}
+
+// 写入 -state 文件的镜像源状态
+type watchMirrorReport struct {
+	Host         string     `json:"host"`
+	Availability float64    `json:"availability"`
+	LatencyMs    int64      `json:"latency_ms"`
+	FailStreak   int        `json:"fail_streak"`
+	Checks       int        `json:"checks"`
+	LastOK       *time.Time `json:"last_ok,omitempty"`
+	Configured   bool       `json:"configured,omitempty"`
+	Degraded     string     `json:"degraded,omitempty"`
+}
+
+type watchReport struct {
+	Rounds  int                 `json:"rounds"`
+	LastRun time.Time           `json:"last_run"`
+	Window  int                 `json:"window"`
+	Mirrors []watchMirrorReport `json:"mirrors"`
+}
+
+// 当前状态的汇总，按可用率和响应时间排序
+func (s *watchState) report(configured []string) watchReport {
+	isConfigured := map[string]bool{}
+	for _, mirror := range configured {
+		isConfigured[mirrorHost(mirror)] = true
+	}
+	report := watchReport{Rounds: s.Rounds, LastRun: s.LastRun, Window: s.Window}
+	for host, stats := range s.Mirrors {
+		mirror := watchMirrorReport{
+			Host:         host,
+			Availability: stats.availability(),
+			LatencyMs:    stats.medianLatency().Milliseconds(),
+			FailStreak:   stats.failStreak(),
+			Checks:       len(stats.Samples),
+			Configured:   isConfigured[host],
+			Degraded:     s.Degraded[host],
+		}
+		for i := range stats.Samples {
+			if stats.Samples[i].OK {
+				mirror.LastOK = &stats.Samples[i].Time
+			}
+		}
+		report.Mirrors = append(report.Mirrors, mirror)
+	}
+	sort.Slice(report.Mirrors, func(i, j int) bool {
+		a, b := report.Mirrors[i], report.Mirrors[j]
+		if a.Availability != b.Availability {
+			return a.Availability > b.Availability
+		}
+		if (a.LatencyMs == 0) != (b.LatencyMs == 0) {
+			return b.LatencyMs == 0
+		}
+		if a.LatencyMs != b.LatencyMs {
+			return a.LatencyMs < b.LatencyMs
+		}
+		return a.Host < b.Host
+	})
+	return report
+}
+
+func writeWatchReport(path string, report watchReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态失败: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("写入状态文件失败: %v", err)
+	}
+	return nil
+}
+
+// watch 的运行参数
+type watchOptions struct {
+	Interval  time.Duration
+	Workers   int
+	List      listSourceOptions
+	Check     checkOptions
+	Policy    watchPolicy
+	StateFile string
+	// 已配置的镜像源劣化时重新写入的镜像源数量，0表示只报告不修改配置
+	ApplyCount int
+	Apply      applyOptions
+	// 黑名单和置顶列表文件，每轮重新读取，运行期间通过 block / pin 子命令的修改在下一轮生效
+	Blocklist string
+	Pinned    string
+}
+
+// watch 子命令：常驻运行，按固定间隔检测镜像源并维护最近几轮的可用率和响应时间
+//
+// 与 agent 相比参数全部通过命令行指定，适合直接在终端或systemd中运行；
+// 指定 -apply 时，当前配置的镜像源劣化后自动换成最近稳定可用且最快的镜像源。
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 10*time.Minute, "检测间隔")
+	window := fs.Int("window", defaultWatchWindow, "计算可用率和响应时间时使用的最近检测轮数")
+	var lists stringsFlag
+	fs.Var(&lists, "list", "检测的列表文件或URL，可重复指定 (默认: docker.txt)")
+	workers := fs.Int("workers", 0, "并发worker数量，默认自动调整 (同检测时的 -workers)")
+	timeout := fs.Duration("timeout", 10*time.Second, "每个镜像源的超时时间")
+	retries := fs.Int("retries", 1, "失败后的重试次数")
+	runtime := fs.String("runtime", "docker", "读取和修改镜像源配置的容器运行时 (docker/containerd/k3s/rke2/buildkit/buildx)")
+	apply := fs.String("apply", "", "已配置的镜像源劣化时自动重新配置，格式同检测时的 -apply (fastest 或 fastest:N)，默认只报告")
+	degradeAfter := fs.Int("degrade-after", 2, "已配置的镜像源连续不可用达到该轮数时视为劣化")
+	maxLatency := fs.Duration("max-latency", 0, "已配置的镜像源响应时间的中位数超过该值时视为劣化 (如 2s)，默认不按响应时间判断")
+	dryRun := fs.Bool("dry-run", false, "劣化时只显示将要进行的修改，不写入配置")
+	stateFile := fs.String("state", "", "每轮检测后写入滚动状态 (JSON) 的文件")
+	blocklist := fs.String("blocklist", defaultBlocklistPath, "黑名单文件，其中的镜像源不会被检测或写入配置，已配置的视为劣化")
+	pinned := fs.String("pinned", defaultPinnedPath, "置顶列表文件，其中的镜像源总是会检测，重新配置时优先选择")
+	listen := fs.String("listen", "", "提供 /healthz 和 /readyz 接口的地址 (如 :8080)，用于Kubernetes或systemd的存活和就绪检查，默认不提供")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker-registry-checker watch [-interval 10m] [参数]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	switch {
+	case *interval <= 0:
+		return fmt.Errorf("-interval 必须大于0")
+	case *window < 1:
+		return fmt.Errorf("-window 必须大于0")
+	case *degradeAfter < 1 || *degradeAfter > *window:
+		return fmt.Errorf("-degrade-after 必须在1到 -window (%d) 之间", *window)
+	}
+
+	target, err := selectTarget(*runtime)
+	if err != nil {
+		return err
+	}
+	criteria, _ := newSuccessCriteria("", "")
+	opts := watchOptions{
+		Interval:  *interval,
+		Workers:   *workers,
+		List:      listSourceOptions{Lists: lists},
+		Check:     checkOptions{Timeout: *timeout, Method: "GET", ProbePath: "/v2/", Criteria: criteria, Retries: *retries},
+		Policy:    watchPolicy{DegradeAfter: *degradeAfter, MaxLatency: *maxLatency},
+		StateFile: *stateFile,
+		Apply:     applyOptions{DryRun: *dryRun, Target: target},
+		Blocklist: *blocklist,
+		Pinned:    *pinned,
+	}
+	if *apply != "" {
+		if opts.ApplyCount, err = parseApplySpec(*apply); err != nil {
+			return err
+		}
+		// 无人值守时不能再询问是否通过sudo运行，启动时就确认有写入权限
+		if !*dryRun && target.NeedRoot && os.Geteuid() > 0 && !configWritable(target.ConfigPath) {
+			return fmt.Errorf("-apply 需要写入 %s，请使用root权限运行", target.ConfigPath)
+		}
+	}
+	// 标准输出留给重新配置时的输出，日志写入标准错误
+	infoOut = os.Stderr
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := log.New(os.Stderr, "[watch] ", log.LstdFlags)
+	mode := "只报告"
+	if opts.ApplyCount > 0 {
+		mode = "劣化时自动重新配置"
+	}
+	logger.Printf("已启动 (间隔: %s, 最近 %d 轮, 已配置的镜像源劣化时: %s)", opts.Interval, *window, mode)
+
+	scheduler := newWatchScheduler(opts.Interval)
+	if *listen != "" {
+		if err := serveHealth(ctx, *listen, scheduler, logger); err != nil {
+			return err
+		}
+	}
+
+	state := newWatchState(*window)
+	for {
+		start := time.Now()
+		scheduler.begin(start)
+		err := watchRound(ctx, logger, opts, state)
+		if err != nil {
+			logger.Printf("%v", err)
+		}
+		next := start.Add(opts.Interval)
+		scheduler.end(time.Now(), next, err, ctx.Err() != nil)
+		if !sleepContext(ctx, time.Until(next)) {
+			break
+		}
+	}
+	logger.Printf("已退出 (共执行 %d 轮检测)", state.Rounds)
+	return nil
+}
+
+// 执行一轮检测，更新滚动状态并检查已配置的镜像源
+//
+// 只有没能完成检测时 (如读取列表失败) 返回错误，重新配置失败等只记录到日志。
+func watchRound(ctx context.Context, logger *log.Logger, opts watchOptions, state *watchState) error {
+	start := time.Now()
+	entries, err := loadCheckList(opts.List)
+	if err != nil {
+		return fmt.Errorf("读取列表失败: %v", err)
+	}
+	blocked, err := loadBlocklist(opts.Blocklist)
+	if err != nil {
+		return err
+	}
+	pinned, err := pinnedFile(opts.Pinned).load()
+	if err != nil {
+		return err
+	}
+	// 置顶的镜像源不在列表中时也要检测
+	entries = append(entries, pinned...)
+	var configured []string
+	if config, err := opts.Apply.Target.readConfig(); err != nil {
+		logger.Printf("读取%s配置失败: %v", opts.Apply.Target.Name, err)
+	} else {
+		configured = config.RegistryMirrors
+	}
+	// 已配置但不在列表中的镜像源也要检测
+	for _, mirror := range configured {
+		entries = append(entries, listEntry{Host: mirrorHost(mirror), Insecure: strings.HasPrefix(mirror, "http://"), Upstream: defaultUpstream})
+	}
+
+	entries, _ = filterBlocked(entries, blocked)
+
+	results := checkAll(ctx, dedupeEntries(entries, nil), opts.Workers, opts.Check, nil)
+	// 被中断的一轮结果不完整，不计入状态
+	if ctx.Err() != nil {
+		return nil
+	}
+	priority := pinPriority(pinned)
+	for i := range results {
+		results[i].Pinned = priority[results[i].Host]
+	}
+	state.record(results, start)
+	logger.Printf("第 %d 轮检测完成 (成功: %d, 总计: %d, 耗时: %.1fs)", state.Rounds,
+		len(filterSuccess(results)), len(results), time.Since(start).Seconds())
+
+	degraded := watchConfigured(logger, opts.Policy, state, configured, blocked)
+	if opts.StateFile != "" {
+		if err := writeWatchReport(opts.StateFile, state.report(configured)); err != nil {
+			logger.Printf("%v", err)
+		}
+	}
+	if len(degraded) == 0 || opts.ApplyCount == 0 {
+		return nil
+	}
+
+	// 从本轮可用的镜像源中排除劣化的镜像源，重新选出最快的几个
+	var candidates []CheckResult
+	for _, result := range state.stable(results) {
+		if state.Degraded[result.Host] == "" {
+			candidates = append(candidates, result)
+		}
+	}
+	if len(candidates) == 0 {
+		logger.Println("没有可替换的镜像源，保持当前配置")
+		return nil
+	}
+	mirrors := fastestMirrors(candidates, opts.ApplyCount)
+	logger.Printf("%s 已劣化，重新配置镜像源: %s", strings.Join(degraded, ", "), strings.Join(mirrors, ", "))
+	apply := opts.Apply
+	apply.Blocked = blocked
+	if err := applyFastest(candidates, opts.ApplyCount, apply); err != nil {
+		logger.Printf("重新配置失败: %v", err)
+		return nil
+	}
+	if !opts.Apply.DryRun {
+		// 新配置的镜像源从下一轮开始重新判断
+		state.Degraded = map[string]string{}
+	}
+	return nil
+}
+
+// 检查已配置的镜像源，记录劣化和恢复，返回当前劣化的镜像源；加入黑名单的镜像源总是视为劣化
+func watchConfigured(logger *log.Logger, policy watchPolicy, state *watchState, configured []string, blocked map[string]string) []string {
+	current := map[string]bool{}
+	for _, mirror := range configured {
+		current[mirrorHost(mirror)] = true
+	}
+	// 配置被其他方式修改后，不再配置的镜像源不再跟踪
+	for host := range state.Degraded {
+		if !current[host] {
+			delete(state.Degraded, host)
+		}
+	}
+
+	var degraded []string
+	for _, mirror := range configured {
+		host := mirrorHost(mirror)
+		stats := state.Mirrors[host]
+		reason := policy.degradation(stats)
+		if comment, ok := blocked[host]; ok {
+			reason = "在黑名单中"
+			if comment != "" {
+				reason += " (" + comment + ")"
+			}
+		}
+		switch {
+		case reason != "" && state.Degraded[host] == "":
+			logger.Printf("已配置的镜像源 %s 劣化: %s", host, reason)
+		case reason == "" && state.Degraded[host] != "":
+			logger.Printf("已配置的镜像源 %s 已恢复", host)
+		}
+		if reason == "" {
+			delete(state.Degraded, host)
+			continue
+		}
+		state.Degraded[host] = reason
+		degraded = append(degraded, host)
+	}
+	return degraded
+}
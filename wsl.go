@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Docker Desktop 的WSL集成挂载到各发行版中的目录
+const dockerDesktopWSLDir = "/mnt/wsl/docker-desktop"
+
+// 是否运行在WSL中
+func inWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
+
+// 是否使用Docker Desktop的WSL集成: docker命令或socket指向Docker Desktop挂载的目录
+//
+// 在WSL发行版中自行安装的dockerd使用普通的socket，不受影响。
+func dockerDesktopWSLIntegration() bool {
+	if _, err := os.Stat(dockerDesktopWSLDir); err != nil {
+		return false
+	}
+	if path, err := filepath.EvalSymlinks(dockerSocket()); err == nil && strings.HasPrefix(path, dockerDesktopWSLDir) {
+		return true
+	}
+	if path, err := exec.LookPath("docker"); err == nil {
+		if path, err = filepath.EvalSymlinks(path); err == nil && strings.HasPrefix(path, dockerDesktopWSLDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// WSL2中通过Docker Desktop集成使用的Docker
+//
+// dockerd运行在Docker Desktop自己的发行版中，当前发行版的 /etc/docker/daemon.json 不会生效，
+// 需要修改Windows用户目录下的 .docker\daemon.json 并重启Docker Desktop。
+// 无法确定Windows用户目录时ConfigPath为空，此时不写入任何文件，只给出正确的位置。
+func dockerDesktopWSLTarget() dockerTarget {
+	restart := `powershell.exe -NoProfile -Command 'Stop-Process -Name "Docker Desktop" -ErrorAction SilentlyContinue; Start-Sleep -Seconds 5; Start-Process "$env:ProgramFiles\Docker\Docker\Docker Desktop.exe"'`
+	target := dockerTarget{
+		Name:    "Docker Desktop (WSL2)",
+		Reload:  restart,
+		Restart: restart,
+	}
+	if profile := windowsUserProfile(); profile != "" {
+		target.ConfigPath = filepath.Join(profile, ".docker", "daemon.json")
+		target.Note = "Docker Desktop重启后生效，也可以在 Settings -> Docker Engine 中查看"
+	} else {
+		target.Note = `当前在WSL2中使用Docker Desktop，/etc/docker/daemon.json 不会生效。` +
+			`请修改Windows上的 %USERPROFILE%\.docker\daemon.json，或在 Docker Desktop -> Settings -> Docker Engine 中设置 registry-mirrors`
+	}
+	return target
+}
+
+// 通过WSL互操作获取Windows用户目录，并转换为WSL中的路径，如 /mnt/c/Users/name
+func windowsUserProfile() string {
+	cmd := exec.Command("cmd.exe", "/c", "echo %USERPROFILE%")
+	// 在Windows盘符下执行，避免cmd.exe提示不支持UNC路径
+	if _, err := os.Stat("/mnt/c"); err == nil {
+		cmd.Dir = "/mnt/c"
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	profile := strings.TrimSpace(string(out))
+	if profile == "" || strings.Contains(profile, "%") {
+		return ""
+	}
+
+	out, err = exec.Command("wslpath", "-u", profile).Output()
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimSpace(string(out))
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return ""
+	}
+	return path
+}